@@ -0,0 +1,314 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tele "github.com/Henry96Markle/telebot"
+)
+
+var (
+	ErrMissingArg  = errors.New("missing argument")
+	ErrInvalidArg  = errors.New("invalid argument")
+	ErrTooManyArgs = errors.New("too many arguments")
+)
+
+func (e *ArgError) Error() string {
+	if e.Param.Name == "" {
+		return e.Err.Error()
+	}
+
+	return fmt.Sprintf("%v: %s %q", e.Err, e.Param.Name, e.Value)
+}
+
+func (e *ArgError) Unwrap() error { return e.Err }
+
+// Parses the arguments of a command after its spec. A PARAM_TARGET can only be the first parameter; it's
+// resolved with ResolveTarget, and the other parameters take the arguments that follow it. An optional
+// target is left zero when none is given. The arguments may only end before an optional parameter.
+//
+// An optional parameter is left out, and its argument given to the next parameter, when the argument
+// fits the next parameter but not this one; or when it's the last argument and a date that the next
+// parameter takes, so that a PARAM_DATE can be given without the parameters before it.
+func ParseArgs(c tele.Context, spec CommandSpec) (Args, error) {
+	var (
+		args   = c.Args()
+		params = spec.Params
+		parsed = Args{values: map[string][]string{}}
+	)
+
+	if len(params) > 0 && params[0].Kind == PARAM_TARGET {
+		target, err := ResolveTarget(c)
+
+		switch {
+		case errors.Is(err, ErrNoTarget) && params[0].Optional:
+			params = params[1:]
+		case err != nil:
+			return Args{}, err
+		default:
+			parsed.Target, args, params = target, target.Args, params[1:]
+		}
+	}
+
+	for i, p := range params {
+		if len(args) == 0 {
+			if p.Optional {
+				return parsed, nil
+			}
+
+			return Args{}, &ArgError{Param: p, Err: ErrMissingArg}
+		}
+
+		if skippable(params, i) {
+			next := params[i+1]
+
+			if fitsParam(next, args[0]) && (!fitsParam(p, args[0]) || (len(args) == 1 && next.Kind == PARAM_DATE && p.Kind != PARAM_DATE)) {
+				continue
+			}
+		}
+
+		if !fitsParam(p, args[0]) {
+			return Args{}, &ArgError{Param: p, Value: args[0], Err: ErrInvalidArg}
+		}
+
+		switch p.Kind {
+		case PARAM_WORD, PARAM_INT, PARAM_DATE:
+			parsed.values[p.Name] = args[:1]
+		case PARAM_ENUM:
+			parsed.values[p.Name] = []string{enumChoice(p, args[0])}
+		case PARAM_USER:
+			target, err := ResolveUser(c, args[0])
+
+			if errors.Is(err, ErrInvalidTarget) {
+				return Args{}, &ArgError{Param: p, Value: args[0], Err: ErrInvalidArg}
+			} else if err != nil {
+				return Args{}, err
+			}
+
+			parsed.values[p.Name] = []string{strconv.FormatInt(target.ID, 10)}
+		case PARAM_REST:
+			parsed.values[p.Name] = []string{strings.TrimSpace(strings.Join(args, " "))}
+		case PARAM_LIST:
+			parsed.values[p.Name] = SplitNotes(strings.Join(args, " "))
+		default:
+			panic(fmt.Sprintf("command \"%s\": parameter \"%s\" can't be of kind %d here", spec.Name, p.Name, p.Kind))
+		}
+
+		if p.Kind == PARAM_REST || p.Kind == PARAM_LIST {
+			args = nil
+		} else {
+			args = args[1:]
+		}
+	}
+
+	if len(args) > 0 {
+		return Args{}, &ArgError{Value: strings.Join(args, " "), Err: ErrTooManyArgs}
+	}
+
+	return parsed, nil
+}
+
+// Reports whether an optional parameter can be left out while the next one is given; see ParseArgs.
+func skippable(params []Param, i int) bool {
+	if !params[i].Optional || i+1 >= len(params) {
+		return false
+	}
+
+	switch params[i].Kind {
+	case PARAM_ENUM, PARAM_INT, PARAM_DATE:
+		return true
+	default:
+		return params[i+1].Kind == PARAM_DATE
+	}
+}
+
+// Reports whether an argument can be given for a parameter. Only PARAM_ENUM, PARAM_INT and PARAM_DATE
+// check their arguments here; the others take any word.
+func fitsParam(p Param, arg string) bool {
+	switch p.Kind {
+	case PARAM_ENUM:
+		return enumChoice(p, arg) != ""
+	case PARAM_INT:
+		_, err := strconv.Atoi(arg)
+		return err == nil
+	case PARAM_DATE:
+		_, err := ParseSince(arg)
+		return err == nil
+	default:
+		return true
+	}
+}
+
+// Returns the choice of a PARAM_ENUM that an argument stands for, regardless of case, or "" if none.
+func enumChoice(p Param, arg string) string {
+	for _, ch := range p.Choices {
+		if strings.EqualFold(ch, arg) {
+			return ch
+		}
+	}
+
+	return ""
+}
+
+// Reports whether a parameter was given.
+func (a Args) Has(name string) bool {
+	_, ok := a.values[name]
+	return ok
+}
+
+// Returns the value of a PARAM_WORD, PARAM_ENUM or PARAM_REST, or "" if it wasn't given.
+func (a Args) Str(name string) string {
+	return strings.Join(a.values[name], " ")
+}
+
+// Returns the value of a PARAM_INT, or 0 if it wasn't given.
+func (a Args) Int(name string) int {
+	i, _ := strconv.Atoi(a.Str(name))
+	return i
+}
+
+// Returns the time a PARAM_DATE stands for, or the zero time if it wasn't given.
+func (a Args) Date(name string) time.Time {
+	t, _ := ParseSince(a.Str(name))
+	return t
+}
+
+// Returns the ID of a PARAM_USER, or 0 if it wasn't given.
+func (a Args) ID(name string) int64 {
+	id, _ := strconv.ParseInt(a.Str(name), 10, 64)
+	return id
+}
+
+// Returns the values of a PARAM_LIST, trimmed, without the empty ones.
+func (a Args) List(name string) []string {
+	if values, ok := a.values[name]; ok {
+		return values
+	}
+
+	return []string{}
+}
+
+// Renders a parameter the way the syntax of commands is written in their help.
+func ParamToStr(p Param) string {
+	switch p.Kind {
+	case PARAM_TARGET:
+		return "<user>"
+	case PARAM_USER:
+		return "<" + p.Name + ">"
+	case PARAM_ENUM:
+		if len(p.Choices) == 1 {
+			return p.Choices[0]
+		} else if len(p.Choices) > ENUM_CHOICES_SHOWN {
+			return "<" + p.Name + ">"
+		}
+
+		return "<" + strings.Join(p.Choices, "/") + ">"
+	case PARAM_REST:
+		return "<" + p.Name + "..>"
+	case PARAM_LIST:
+		return "<" + p.Name + "1; " + p.Name + "2; ..>"
+	default:
+		return "<" + p.Name + ">"
+	}
+}
+
+// Renders the syntax of a command, e.g. "/delrec <user> [<category> [<index>]]". The parameters after an
+// optional one are nested in its brackets, unless it can be left out on its own, as in
+// "/perm <user> [[set] <level>]".
+func Usage(spec CommandSpec) string {
+	var (
+		b     = strings.Builder{}
+		depth = 0
+	)
+
+	b.WriteString("/" + spec.Name)
+
+	for i, p := range spec.Params {
+		b.WriteString(" ")
+
+		switch {
+		case skippable(spec.Params, i) && spec.Params[i+1].Optional:
+			b.WriteString("[" + ParamToStr(p) + "]")
+		case skippable(spec.Params, i):
+			b.WriteString("[[" + ParamToStr(p) + "]")
+			depth++
+		case p.Optional:
+			b.WriteString("[" + ParamToStr(p))
+			depth++
+		default:
+			b.WriteString(ParamToStr(p))
+		}
+	}
+
+	b.WriteString(strings.Repeat("]", depth))
+
+	return b.String()
+}
+
+// Replies with why the arguments of a command couldn't be parsed, and the command's syntax.
+func ReplyArgsErr(c tele.Context, spec CommandSpec, err error) error {
+	var arg_err *ArgError
+
+	if !errors.As(err, &arg_err) {
+		return ReplyTargetErr(c, err)
+	}
+
+	var reason string
+
+	switch {
+	case errors.Is(err, ErrMissingArg):
+		reason = fmt.Sprintf("Missing %s.", ParamToStr(arg_err.Param))
+	case errors.Is(err, ErrTooManyArgs):
+		reason = fmt.Sprintf("Too many arguments: \"%s\".", arg_err.Value)
+	case arg_err.Param.Kind == PARAM_ENUM:
+		choices := arg_err.Param.Choices
+		expected := LastOf(choices)
+
+		if len(choices) > 1 {
+			expected = strings.Join(choices[:len(choices)-1], ", ") + " or " + expected
+		}
+
+		reason = fmt.Sprintf("Unknown %s: \"%s\"; expected %s.", arg_err.Param.Name, arg_err.Value, expected)
+	case arg_err.Param.Kind == PARAM_USER:
+		reason = fmt.Sprintf("Invalid %s: \"%s\" isn't an ID or @username.", arg_err.Param.Name, arg_err.Value)
+	case arg_err.Param.Kind == PARAM_DATE:
+		reason = fmt.Sprintf("Invalid %s: \"%s\" isn't a date (2022-07-01) or a period of time (7d, 36h).", arg_err.Param.Name, arg_err.Value)
+	case arg_err.Param.Kind == PARAM_INT:
+		reason = fmt.Sprintf("Invalid %s: \"%s\" isn't a number.", arg_err.Param.Name, arg_err.Value)
+	default:
+		log.Printf("error parsing arguments of /%s: %v\n", spec.Name, err)
+		reason = fmt.Sprintf("Invalid %s: \"%s\".", arg_err.Param.Name, arg_err.Value)
+	}
+
+	return c.Reply(reason + "\n\nUsage: " + Usage(spec))
+}
+
+// Lists the declared commands for Telegram to suggest as they're typed, with their syntax as a hint.
+func BotCommands() []tele.Command {
+	commands := make([]tele.Command, 0, len(CommandSpecs))
+
+	for _, spec := range CommandSpecs {
+		description := spec.Description
+
+		if hint := strings.TrimPrefix(Usage(spec), "/"+spec.Name); hint != "" {
+			description += " —" + hint
+		}
+
+		commands = append(commands, tele.Command{Text: spec.Name, Description: description})
+	}
+
+	sort.Slice(commands, func(i, j int) bool { return commands[i].Text < commands[j].Text })
+
+	return commands
+}
+
+// Writes the help of a command out of its help text, in which the syntax of the command, as Usage renders
+// it, takes the place of the "%s" verb.
+func CommandHelp(name, help string) string {
+	return fmt.Sprintf(help, Usage(CommandSpecs[name]))
+}
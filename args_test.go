@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestParseArgs(t *testing.T) {
+	spec := CommandSpec{Name: "test", Params: []Param{
+		{Kind: PARAM_TARGET},
+		{Name: "op", Kind: PARAM_ENUM, Choices: []string{"add", "remove"}},
+		{Name: "count", Kind: PARAM_INT, Optional: true},
+		{Name: "items", Kind: PARAM_LIST},
+	}}
+
+	tests := []struct {
+		name   string
+		spec   CommandSpec
+		text   string
+		target int64
+		want   map[string][]string
+		err    error
+	}{
+		{name: "all", spec: spec, text: "/test 2000 ADD 2 a; b ;; c", target: 2000, want: map[string][]string{"op": {"add"}, "count": {"2"}, "items": {"a", "b", "c"}}},
+		{name: "optional left out", spec: spec, text: "/test 2000 remove", target: 2000, want: map[string][]string{"op": {"remove"}}},
+		{name: "missing after optional", spec: spec, text: "/test 2000 add 2", err: ErrMissingArg},
+		{name: "missing", spec: spec, text: "/test 2000", err: ErrMissingArg},
+		{name: "unknown choice", spec: spec, text: "/test 2000 put 2 a", err: ErrInvalidArg},
+		{name: "not a number", spec: spec, text: "/test 2000 add two a", target: 2000, want: map[string][]string{"op": {"add"}, "items": {"two a"}}},
+		{name: "last not a number", spec: CommandSpecs[CMD_DELREC], text: "/delrec 2000 bans x", err: ErrInvalidArg},
+		{name: "date alone", spec: CommandSpecs[CMD_AUDIT], text: "/audit 2000 7d", target: 2000, want: map[string][]string{"since": {"7d"}}},
+		{name: "word alone", spec: CommandSpecs[CMD_AUDIT], text: "/audit 2000 record", target: 2000, want: map[string][]string{"action": {"record"}}},
+		{name: "word and date", spec: CommandSpecs[CMD_AUDIT], text: "/audit 2000 record 2022-07-01", target: 2000, want: map[string][]string{"action": {"record"}, "since": {"2022-07-01"}}},
+		{name: "fits neither", spec: CommandSpecs[CMD_AUDIT], text: "/audit 2000 rename", err: ErrInvalidArg},
+		{name: "invalid date", spec: CommandSpecs[CMD_AUDIT], text: "/audit 2000 record yesterday", err: ErrInvalidArg},
+		{name: "choice left out", spec: CommandSpecs[CMD_RECALL], text: "/recall Miles Edgeworth", want: map[string][]string{"query": {"Miles Edgeworth"}}},
+		{name: "choice given", spec: CommandSpecs[CMD_RECALL], text: "/recall name Miles", want: map[string][]string{"field": {"name"}, "query": {"Miles"}}},
+		{name: "no target", spec: spec, text: "/test add 2 a", err: ErrNoTarget},
+		{name: "too many", spec: CommandSpecs[CMD_UNREG], text: "/unreg 2000 now", err: ErrTooManyArgs},
+		{name: "rest", spec: CommandSpecs[CMD_SET], text: "/set 2000 The  prosecutor", target: 2000, want: map[string][]string{"description": {"The  prosecutor"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTest(t)
+
+			args, err := ParseArgs(newCommand(testWriterID, testGroupID, tt.text, nil), tt.spec)
+
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("expected error %v, got %v", tt.err, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if args.Target.ID != tt.target || !reflect.DeepEqual(args.values, tt.want) {
+				t.Errorf("expected %d %v, got %d %v", tt.target, tt.want, args.Target.ID, args.values)
+			}
+		})
+	}
+}
+
+func TestUsage(t *testing.T) {
+	tests := map[string]string{
+		CMD_REG:     "/reg <user> [<description..>]",
+		CMD_RECORD:  "/record <user> <category> [<note1; note2; ..>]",
+		CMD_PERM:    "/perm <user> [[set] <level>]",
+		CMD_DELREC:  "/delrec <user> [<category> [<index>]]",
+		CMD_AUDIT:   "/audit <user> [<action>] [<since>]",
+		CMD_RECALL:  "/recall [[<name/username>] <query..>]",
+		CMD_EDITREC: "/editrec <user> <category> <index> <replace/append/remove/move> <value..>",
+	}
+
+	for name, want := range tests {
+		if got := Usage(CommandSpecs[name]); got != want {
+			t.Errorf("expected %q, got %q", want, got)
+		}
+	}
+}
+
+func TestReplyArgsErr(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{name: "missing", text: "/perm 2000 set", want: "Missing <level>.\n\nUsage: /perm <user> [[set] <level>]"},
+		{name: "too many", text: "/perm 2000 set 1 now", want: "Too many arguments: \"now\".\n\nUsage: /perm <user> [[set] <level>]"},
+		{name: "target", text: "/perm", want: MSG_ID_REQUIRED},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTest(t, recordedTarget())
+
+			ctx := newCommand(testOperatorID, testGroupID, tt.text, nil)
+
+			if err := PermHandler(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			if ctx.last() != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, ctx.last())
+			}
+		})
+	}
+}
+
+func TestBotCommands(t *testing.T) {
+	valid := regexp.MustCompile(`^[a-z0-9_]{1,32}$`)
+	commands := BotCommands()
+
+	if len(commands) != len(Commands) {
+		t.Fatalf("expected %d commands, got %d", len(Commands), len(commands))
+	}
+
+	for _, c := range commands {
+		if !valid.MatchString(c.Text) || len(c.Description) < 3 || len(c.Description) > 256 {
+			t.Errorf("command not accepted by Telegram: %+v", c)
+		}
+	}
+
+	if commands[0].Text != CMD_ALIAS || commands[0].Description != "Add or remove a user's aliases — <user> <add/remove> <name/id/username> <value1; value2; ..>" {
+		t.Errorf("unexpected first command %+v", commands[0])
+	}
+}
+
+func TestCommandSyntax(t *testing.T) {
+	for _, name := range Commands {
+		if _, ok := CommandSpecs[name]; !ok {
+			t.Errorf("/%s has no spec", name)
+		}
+
+		help, ok := CommandSyntax[name]
+
+		if !ok || strings.Contains(help, "%!") || !strings.Contains(help, Usage(CommandSpecs[name])) {
+			t.Errorf("/%s: unexpected help %q", name, help)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tele "github.com/Henry96Markle/telebot"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Writes an audit entry for a mutation of target, made by actor. Either before or after may be nil,
+// when the user didn't exist before the mutation, or doesn't anymore.
+// Failures are only logged, since the mutation itself has already gone through.
+func AuditLog(actor int64, target int64, action string, before *User, after *User) {
+	entry := AuditEntry{
+		ID:      primitive.NewObjectID(),
+		Actor:   actor,
+		Target:  target,
+		Action:  action,
+		Changes: Diff(before, after),
+		Date:    time.Now(),
+	}
+
+	if err := Data.AddAudit(entry); err != nil {
+		log.Printf("error writing audit entry: %v\n", err)
+	}
+}
+
+// Lists the fields that differ between two versions of a user.
+func Diff(before *User, after *User) []AuditChange {
+	var (
+		changes = make([]AuditChange, 0)
+
+		b = auditFields(before)
+		a = auditFields(after)
+
+		fields = make([]string, 0, len(a)+len(b))
+	)
+
+	for k := range b {
+		fields = append(fields, k)
+	}
+
+	for k := range a {
+		if _, ok := b[k]; !ok {
+			fields = append(fields, k)
+		}
+	}
+
+	sort.Strings(fields)
+
+	for _, f := range fields {
+		if b[f] != a[f] {
+			changes = append(changes, AuditChange{Field: f, Before: b[f], After: a[f]})
+		}
+	}
+
+	return changes
+}
+
+// Flattens a user into plain-text fields, keyed by their document names; empty fields are omitted.
+func auditFields(user *User) map[string]string {
+	fields := map[string]string{}
+
+	if user == nil {
+		return fields
+	}
+
+	set := func(k, v string) {
+		if v != "" {
+			fields[k] = v
+		}
+	}
+
+	set("names", strings.Join(user.Names, "; "))
+	set("usernames", strings.Join(user.Usernames, "; "))
+	set("alias_ids", strings.Join(IntToStrSlice(user.AliasIDs...), "; "))
+	set("description", user.Description)
+	set("permission_level", strconv.Itoa(user.Permission))
+
+	for category, records := range user.Records {
+		lines := make([]string, 0, len(records))
+
+		for _, r := range records {
+			lines = append(lines, fmt.Sprintf("[%s] %s", r.Date.UTC().Format("2006-01-02 15:04"), strings.Join(r.Notes, "; ")))
+		}
+
+		set("records."+category, strings.Join(lines, "\n"))
+	}
+
+	return fields
+}
+
+// Parses the "since" argument of /audit: either a date (2006-01-02), a number of days (7d),
+// or a duration (36h).
+func ParseSince(s string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+
+	if strings.HasSuffix(s, "d") {
+		if n, err := strconv.Atoi(strings.TrimSuffix(s, "d")); err == nil && n >= 0 {
+			return time.Now().AddDate(0, 0, -n), nil
+		}
+	}
+
+	d, err := time.ParseDuration(s)
+
+	if err != nil || d < 0 {
+		return time.Time{}, fmt.Errorf("invalid date or duration \"%s\"", s)
+	}
+
+	return time.Now().Add(-d), nil
+}
+
+// Renders a page of a user's audit entries, with navigation buttons if there's more than one page.
+func AuditPage(target int64, action string, since time.Time, page int) (string, *tele.ReplyMarkup, error) {
+	entries, count, err := Data.FindAudit(target, action, since, int64(page*AUDIT_PAGE_SIZE), AUDIT_PAGE_SIZE)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	if count == 0 {
+		return "No audit entries.", nil, nil
+	}
+
+	pages := int((count + AUDIT_PAGE_SIZE - 1) / AUDIT_PAGE_SIZE)
+
+	str := make([]string, 0, len(entries))
+
+	for _, e := range entries {
+		changes := make([]string, 0, len(e.Changes))
+
+		for _, c := range e.Changes {
+			changes = append(changes, fmt.Sprintf(
+				"\t- <b>%s</b>: %s → %s",
+				c.Field,
+				BoolToStr(c.Before != "", "<code>"+html.EscapeString(TruncateStr(c.Before, AUDIT_VALUE_LIMIT))+"</code>", "<i>none</i>"),
+				BoolToStr(c.After != "", "<code>"+html.EscapeString(TruncateStr(c.After, AUDIT_VALUE_LIMIT))+"</code>", "<i>none</i>"),
+			))
+		}
+
+		str = append(str, fmt.Sprintf(
+			"<b>%s</b> #%s by <code>%d</code>%s",
+			e.Date.UTC().Format("2006-01-02 15:04"),
+			e.Action,
+			e.Actor,
+			BoolToStr(len(changes) > 0, "\n"+strings.Join(changes, "\n"), ""),
+		))
+	}
+
+	var (
+		markup = &tele.ReplyMarkup{}
+		row    = make([]tele.InlineButton, 0, 2)
+
+		unix = int64(0)
+
+		data = func(p int) string {
+			return fmt.Sprintf("%d|%s|%d|%d", target, action, unix, p)
+		}
+	)
+
+	if !since.IsZero() {
+		unix = since.Unix()
+	}
+
+	if page > 0 {
+		row = append(row, *tele.Btn{Unique: BTN_AUDIT_PAGE, Text: "« Prev", Data: data(page - 1)}.Inline())
+	}
+
+	if page < pages-1 {
+		row = append(row, *tele.Btn{Unique: BTN_AUDIT_PAGE, Text: "Next »", Data: data(page + 1)}.Inline())
+	}
+
+	if len(row) > 0 && FitsCallbackData(BTN_AUDIT_PAGE, data(pages-1)) {
+		markup.InlineKeyboard = [][]tele.InlineButton{row}
+	} else {
+		markup = nil
+	}
+
+	return fmt.Sprintf(
+		"Audit log of ID <code>%d</code> (page %d/%d):\n\n%s",
+		target, page+1, pages,
+		strings.Join(str, "\n\n"),
+	), markup, nil
+}
+
+// Syntax:
+//
+//	- /audit <ID/@username/mention/reply-to-message> [action] [since]
+func AuditHandler(c tele.Context) error {
+	args, args_err := ParseArgs(c, CommandSpecs[CMD_AUDIT])
+
+	if args_err != nil {
+		return ReplyArgsErr(c, CommandSpecs[CMD_AUDIT], args_err)
+	}
+
+	id, action, since := args.Target.ID, args.Str("action"), args.Date("since")
+
+	text, markup, err := AuditPage(id, action, since, 0)
+
+	if err != nil {
+		log.Printf("error querying audit log: %v\n", err)
+		return c.Reply(MSG_COULD_NOT_PERFORM)
+	}
+
+	return c.Reply(text, markup, tele.ModeHTML)
+}
+
+func AuditPageBtnHandler(c tele.Context) error {
+	args := c.Args()
+
+	if len(args) != 4 {
+		return c.Edit("Invalid callback data.")
+	}
+
+	id, err1 := strconv.ParseInt(args[0], 0, 64)
+	unix, err2 := strconv.ParseInt(args[2], 0, 64)
+	page, err3 := strconv.Atoi(args[3])
+
+	if err1 != nil || err2 != nil || err3 != nil || page < 0 {
+		return c.Edit("Invalid callback data.")
+	}
+
+	var since time.Time
+
+	if unix != 0 {
+		since = time.Unix(unix, 0)
+	}
+
+	text, markup, err := AuditPage(id, args[1], since, page)
+
+	if err != nil {
+		log.Printf("error querying audit log: %v\n", err)
+		return c.Edit(MSG_COULD_NOT_PERFORM)
+	}
+
+	return c.Edit(text, markup, tele.ModeHTML)
+}
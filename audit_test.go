@@ -0,0 +1,133 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiff(t *testing.T) {
+	before := recordedTarget()
+	after, _ := cloneUser(before)
+
+	after.Description = "Prosecutor"
+	after.Records["bans"] = after.Records["bans"][:1]
+
+	changes := Diff(&before, &after)
+
+	if len(changes) != 2 || changes[0].Field != "description" || changes[1].Field != "records.bans" {
+		t.Fatalf("expected description and records.bans to change, got %v", changes)
+	}
+
+	if changes[0].Before != "" || changes[0].After != "Prosecutor" {
+		t.Errorf("unexpected description change: %v", changes[0])
+	}
+
+	if len(Diff(nil, &after)) == 0 || len(Diff(&before, nil)) == 0 {
+		t.Errorf("expected registering and unregistering to produce changes")
+	}
+
+	if len(Diff(&before, &before)) != 0 {
+		t.Errorf("expected no changes between identical users")
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	if d, err := ParseSince("2022-07-01"); err != nil || !d.Equal(time.Date(2022, 7, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected a date, got %v (%v)", d, err)
+	}
+
+	if d, err := ParseSince("7d"); err != nil || time.Since(d) < 7*24*time.Hour-time.Minute {
+		t.Errorf("expected seven days ago, got %v (%v)", d, err)
+	}
+
+	if d, err := ParseSince("36h"); err != nil || time.Since(d) < 36*time.Hour-time.Minute {
+		t.Errorf("expected 36 hours ago, got %v (%v)", d, err)
+	}
+
+	for _, s := range []string{"record", "-3d", "-1h", ""} {
+		if _, err := ParseSince(s); err == nil {
+			t.Errorf("expected %q to be invalid", s)
+		}
+	}
+}
+
+func TestAuditHandler(t *testing.T) {
+	setupTest(t, recordedTarget())
+
+	for _, text := range []string{
+		"/set 2000 Prosecutor",
+		"/record 2000 kicks flood",
+		"/record 2000 kicks spam",
+		"/delrec 2000 bans 1",
+		"/perm 2000 set 1",
+		"/alias 2000 add name Edgey",
+	} {
+		ctx := newCommand(testOperatorID, testGroupID, text, nil)
+		handler := CommandMap[strings.TrimPrefix(strings.Split(text, " ")[0], "/")]
+
+		if err := handler(ctx); err != nil {
+			t.Fatalf("%s: unexpected error: %v", text, err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		text     string
+		replyTo  bool
+		contains []string
+		missing  []string
+		pages    bool
+	}{
+		{name: "all", text: "/audit 2000", contains: []string{"page 1/2", "#alias", "#perm", "#delrec"}, missing: []string{"#set"}, pages: true},
+		{name: "by reply", text: "/audit", replyTo: true, contains: []string{"page 1/2"}, pages: true},
+		{name: "by action", text: "/audit 2000 record", contains: []string{"page 1/1", "#record", "records.kicks"}, missing: []string{"#alias"}},
+		{name: "by action and since", text: "/audit 2000 set 1d", contains: []string{"#set", "Prosecutor"}},
+		{name: "in the future", text: "/audit 2000 2100-01-01", contains: []string{"No audit entries."}},
+		{name: "unknown action", text: "/audit 2000 rename|x", contains: []string{"Unknown action: \"rename|x\"; expected reg, unreg,"}},
+		{name: "invalid since", text: "/audit 2000 set yesterday", contains: []string{"Invalid since: \"yesterday\""}},
+		{name: "no ID", text: "/audit", contains: []string{MSG_ID_REQUIRED}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := newCommand(testOperatorID, testGroupID, tt.text, nil)
+
+			if tt.replyTo {
+				ctx = newCommand(testOperatorID, testGroupID, tt.text, testTarget)
+			}
+
+			if err := AuditHandler(ctx); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			for _, s := range tt.contains {
+				if !strings.Contains(ctx.last(), s) {
+					t.Errorf("expected reply to contain %q, got %q", s, ctx.last())
+				}
+			}
+
+			for _, s := range tt.missing {
+				if strings.Contains(ctx.last(), s) {
+					t.Errorf("expected reply not to contain %q, got %q", s, ctx.last())
+				}
+			}
+
+			if (ctx.lastMarkup() != nil) != tt.pages {
+				t.Errorf("expected navigation buttons: %t", tt.pages)
+			}
+		})
+	}
+
+	t.Run("next page", func(t *testing.T) {
+		ctx := newCallback(testOperatorID, BTN_AUDIT_PAGE, "2000||0|1")
+
+		if err := AuditPageBtnHandler(ctx); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if !strings.Contains(ctx.last(), "page 2/2") || !strings.Contains(ctx.last(), "#set") {
+			t.Errorf("expected the second page, got %q", ctx.last())
+		}
+	})
+}
@@ -0,0 +1,460 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	tele "github.com/Henry96Markle/telebot"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Fetches a document sent to the bot. Tests replace it, to do without Telegram.
+var Download = func(c tele.Context, file *tele.File) (io.ReadCloser, error) {
+	return c.Bot().File(file)
+}
+
+// Writes every given user into a gzip-compressed JSON archive.
+func WriteBackup(w io.Writer, users []User, schema int) error {
+	gz := gzip.NewWriter(w)
+
+	backup := Backup{
+		Format:  BACKUP_FORMAT,
+		Version: BACKUP_VERSION,
+		Schema:  schema,
+		Created: time.Now().UTC(),
+		Users:   users,
+	}
+
+	if err := json.NewEncoder(gz).Encode(backup); err != nil {
+		return err
+	}
+
+	return gz.Close()
+}
+
+// Reads and validates an archive written by WriteBackup. Users from an older schema are brought up
+// to date with the migrations, in memory.
+func ReadBackup(r io.Reader) (Backup, error) {
+	backup := Backup{}
+
+	gz, err := gzip.NewReader(r)
+
+	if err != nil {
+		return backup, errors.New("the file is not a compressed backup")
+	}
+
+	defer gz.Close()
+
+	if err = json.NewDecoder(io.LimitReader(gz, BACKUP_MAX_SIZE)).Decode(&backup); err != nil {
+		return backup, fmt.Errorf("the backup could not be read: %w", err)
+	}
+
+	latest := LatestSchemaVersion(Migrations)
+
+	switch {
+	case backup.Format != BACKUP_FORMAT:
+		return backup, errors.New("the file is not a backup")
+	case backup.Version > BACKUP_VERSION:
+		return backup, fmt.Errorf("the backup format version %d is newer than this bot's", backup.Version)
+	case backup.Schema > latest:
+		return backup, fmt.Errorf("the backup's schema version %d is newer than this bot's (%d)", backup.Schema, latest)
+	}
+
+	seen := map[int64]bool{}
+	seenObjects := map[string]bool{}
+
+	for i := range backup.Users {
+		u := &backup.Users[i]
+
+		if u.TelegramID == 0 {
+			return backup, fmt.Errorf("user #%d has no ID", i+1)
+		}
+
+		if seen[u.TelegramID] {
+			return backup, fmt.Errorf("ID %d appears more than once", u.TelegramID)
+		}
+
+		// Users from the memory store may have no document ID; they get one now.
+		if u.ID.IsZero() {
+			u.ID = primitive.NewObjectID()
+		} else if seenObjects[u.ID.Hex()] {
+			return backup, fmt.Errorf("user %d has a repeated document ID", u.TelegramID)
+		}
+
+		seen[u.TelegramID] = true
+		seenObjects[u.ID.Hex()] = true
+	}
+
+	pending := make([]Migration, 0, len(Migrations))
+
+	for _, m := range Migrations {
+		if m.Version > backup.Schema {
+			pending = append(pending, m)
+		}
+	}
+
+	sort.SliceStable(pending, func(i, j int) bool { return pending[i].Version < pending[j].Version })
+
+	for _, m := range pending {
+		for i := range backup.Users {
+			m.Apply(&backup.Users[i])
+		}
+	}
+
+	backup.Schema = latest
+
+	return backup, nil
+}
+
+// Reports whether two users hold the same data, versions aside.
+func SameUser(a, b User) bool {
+	a.Version, b.Version = 0, 0
+
+	raw_a, err_a := json.Marshal(a)
+	raw_b, err_b := json.Marshal(b)
+
+	return err_a == nil && err_b == nil && bytes.Equal(raw_a, raw_b)
+}
+
+// Works out what restoring the backup over the current users would do.
+//
+// Merging adds the users that are missing and adds back the records that existing users lack.
+// Replacing makes the users exactly those of the backup.
+func PlanRestore(backup Backup, current []User, mode string) (RestorePlan, error) {
+	plan := RestorePlan{}
+	existing := make(map[int64]User, len(current))
+
+	for _, u := range current {
+		existing[u.TelegramID] = u
+	}
+
+	for _, u := range backup.Users {
+		cur, ok := existing[u.TelegramID]
+		delete(existing, u.TelegramID)
+
+		if !ok {
+			plan.Add = append(plan.Add, u)
+			continue
+		}
+
+		switch mode {
+		case RESTORE_MERGE:
+			merged, err := cloneUser(cur)
+
+			if err != nil {
+				return plan, err
+			}
+
+			if merged.Records == nil {
+				merged.Records = map[string][]Record{}
+			}
+
+			if n := MergeRecords(merged.Records, u.Records); n > 0 {
+				plan.Records += n
+				plan.Replace = append(plan.Replace, merged)
+			} else {
+				plan.Unchanged++
+			}
+		case RESTORE_REPLACE:
+			// The stored document keeps its own identity and version.
+			u.ID, u.Version = cur.ID, cur.Version
+
+			if SameUser(cur, u) {
+				plan.Unchanged++
+			} else {
+				plan.Replace = append(plan.Replace, u)
+			}
+		default:
+			return plan, fmt.Errorf("unknown mode \"%s\"", mode)
+		}
+	}
+
+	if mode == RESTORE_REPLACE {
+		for _, u := range current {
+			if _, ok := existing[u.TelegramID]; ok {
+				plan.Remove = append(plan.Remove, u)
+			}
+		}
+	}
+
+	return plan, nil
+}
+
+// Describes a plan, for the owner to confirm or for the result of a restore.
+func RestorePlanToStr(plan RestorePlan) string {
+	plural := func(n int) string { return BoolToStr(n != 1, "s", "") }
+
+	lines := []string{
+		fmt.Sprintf("add %d user%s", len(plan.Add), plural(len(plan.Add))),
+		fmt.Sprintf("update %d user%s", len(plan.Replace), plural(len(plan.Replace))),
+	}
+
+	if plan.Records > 0 {
+		lines[1] += fmt.Sprintf(" (%d record%s added back)", plan.Records, plural(plan.Records))
+	}
+
+	if len(plan.Remove) > 0 {
+		lines = append(lines, fmt.Sprintf("remove %d user%s (moved to the trash)", len(plan.Remove), plural(len(plan.Remove))))
+	}
+
+	lines = append(lines, fmt.Sprintf("leave %d user%s unchanged", plan.Unchanged, plural(plan.Unchanged)))
+
+	return "\t- " + strings.Join(lines, "\n\t- ")
+}
+
+// Applies a plan, stopping at the first failure. Returns how many changes were made.
+func ApplyRestore(actor int64, plan RestorePlan) (int, error) {
+	done := 0
+
+	for _, u := range plan.Remove {
+		if _, err := TrashUser(actor, u); err != nil {
+			return done, fmt.Errorf("error moving ID %d to the trash: %w", u.TelegramID, err)
+		}
+
+		if _, err := Data.RemoveByID(u.TelegramID); err != nil {
+			return done, fmt.Errorf("error removing ID %d: %w", u.TelegramID, err)
+		}
+
+		AuditLog(actor, u.TelegramID, AUDIT_BACKUP, &u, nil)
+		done++
+	}
+
+	for _, u := range plan.Replace {
+		before, err := Data.FindByID(u.TelegramID)
+
+		if err != nil {
+			return done, fmt.Errorf("error reading ID %d: %w", u.TelegramID, err)
+		}
+
+		if err = Data.ReplaceByID(u.TelegramID, u); err != nil {
+			return done, fmt.Errorf("error replacing ID %d: %w", u.TelegramID, err)
+		}
+
+		AuditLog(actor, u.TelegramID, AUDIT_BACKUP, &before, &u)
+		done++
+	}
+
+	if len(plan.Add) > 0 {
+		if err := Data.Add(plan.Add...); err != nil {
+			return done, fmt.Errorf("error adding users: %w", err)
+		}
+
+		for _, u := range plan.Add {
+			AuditLog(actor, u.TelegramID, AUDIT_BACKUP, nil, &u)
+		}
+
+		done += len(plan.Add)
+	}
+
+	return done, nil
+}
+
+// Syntax:
+//
+//	- /backup
+func BackupHandler(c tele.Context) error {
+	if _, args_err := ParseArgs(c, CommandSpecs[CMD_BACKUP]); args_err != nil {
+		return ReplyArgsErr(c, CommandSpecs[CMD_BACKUP], args_err)
+	}
+
+	users, data_err := Data.GetAll()
+
+	if data_err != nil {
+		log.Printf(ERR_FMT_QUERY+"\n", data_err)
+		return c.Reply(MSG_COULD_NOT_PERFORM)
+	}
+
+	schema, schema_err := Data.SchemaVersion()
+
+	if schema_err != nil {
+		log.Printf("error reading schema version: %v\n", schema_err)
+		return c.Reply(MSG_COULD_NOT_PERFORM)
+	}
+
+	buf := bytes.Buffer{}
+
+	if err := WriteBackup(&buf, users, schema); err != nil {
+		log.Printf("error writing backup: %v\n", err)
+		return c.Reply(MSG_COULD_NOT_PERFORM)
+	}
+
+	doc := &tele.Document{
+		File:     tele.FromReader(&buf),
+		FileName: fmt.Sprintf("botone-backup-%s.json.gz", time.Now().UTC().Format("2006-01-02")),
+		Caption:  fmt.Sprintf("%d user%s, schema version %d.", len(users), BoolToStr(len(users) != 1, "s", ""), schema),
+	}
+
+	// logging
+
+	name := c.Message().Sender.FirstName + " " + c.Message().Sender.LastName
+
+	ChanLogf("#backup\n[<code>%d</code>] %shas taken a backup of %d user%s.",
+		c.Sender().ID,
+		BoolToStr(name != "", name+" ", ""),
+		len(users),
+		BoolToStr(len(users) != 1, "s", ""),
+	)
+
+	// returning
+
+	// The archive holds everything; it only ever goes to the owner's PM.
+	if c.Chat().ID == Config.OwnerTelegramID {
+		return c.Reply(doc)
+	}
+
+	if _, err := c.Bot().Send(tele.ChatID(Config.OwnerTelegramID), doc); err != nil {
+		log.Printf("error sending backup: %v\n", err)
+		return c.Reply(MSG_COULD_NOT_PERFORM)
+	}
+
+	return c.Reply("The backup was sent in PM.")
+}
+
+// Syntax:
+//
+//	- /restore [merge/replace] <reply-to-backup>
+func RestoreBackupHandler(c tele.Context) error {
+	if c.Sender().ID != Config.OwnerTelegramID {
+		return c.Reply("Only the owner can restore a backup.")
+	}
+
+	args, args_err := ParseArgs(c, RestoreBackupSpec)
+
+	if args_err != nil {
+		return ReplyArgsErr(c, RestoreBackupSpec, args_err)
+	}
+
+	mode := args.Str("mode")
+
+	if mode == "" {
+		mode = RESTORE_MERGE
+	}
+
+	r, download_err := Download(c, &c.Message().ReplyTo.Document.File)
+
+	if download_err != nil {
+		log.Printf("error downloading backup: %v\n", download_err)
+		return c.Reply(MSG_COULD_NOT_PERFORM)
+	}
+
+	defer r.Close()
+
+	backup, read_err := ReadBackup(r)
+
+	if read_err != nil {
+		return c.Reply("Invalid backup: " + read_err.Error() + ".")
+	}
+
+	current, data_err := Data.GetAll()
+
+	if data_err != nil {
+		log.Printf(ERR_FMT_QUERY+"\n", data_err)
+		return c.Reply(MSG_COULD_NOT_PERFORM)
+	}
+
+	plan, plan_err := PlanRestore(backup, current, mode)
+
+	if plan_err != nil {
+		log.Printf("error planning restore: %v\n", plan_err)
+		return c.Reply(MSG_COULD_NOT_PERFORM)
+	}
+
+	if len(plan.Add)+len(plan.Replace)+len(plan.Remove) == 0 {
+		return c.Reply("The backup matches the current users; there is nothing to restore.")
+	}
+
+	token, err := Results.Put(c.Sender().ID, PendingRestore{Backup: backup, Mode: mode}, RESULT_TTL)
+
+	if err != nil {
+		log.Printf("error keeping backup: %v\n", err)
+		return c.Reply(MSG_COULD_NOT_PERFORM)
+	}
+
+	confirmBtn := *ConfirmRestoreBtn
+	confirmBtn.Data = token
+
+	return c.Reply(
+		fmt.Sprintf(
+			"Backup of %s, with %d user%s.\n\nA <b>%s</b> restore would:\n\n%s\n\nProceed?",
+			backup.Created.UTC().Format("2006-01-02 15:04"),
+			len(backup.Users),
+			BoolToStr(len(backup.Users) != 1, "s", ""),
+			mode,
+			RestorePlanToStr(plan),
+		),
+		tele.ModeHTML,
+		&tele.ReplyMarkup{
+			InlineKeyboard: [][]tele.InlineButton{
+				{*CancelBtn.Inline(), *confirmBtn.Inline()},
+			},
+		},
+	)
+}
+
+func ConfirmRestoreBtnHandler(c tele.Context) error {
+	token := c.Callback().Data
+	result, ok := Results.Get(token)
+	pending, is_pending := result.Value.(PendingRestore)
+
+	if !ok || !is_pending {
+		return c.Edit("This restore has expired; reply to the backup again.")
+	}
+
+	if result.Owner != c.Sender().ID {
+		return c.Respond(&tele.CallbackResponse{Text: "Only the one who asked for this restore can confirm it."})
+	}
+
+	Results.Delete(token)
+
+	// The users are read again, since they may have changed since the confirmation was asked.
+
+	current, data_err := Data.GetAll()
+
+	if data_err != nil {
+		log.Printf(ERR_FMT_QUERY+"\n", data_err)
+		return c.Edit(MSG_COULD_NOT_PERFORM)
+	}
+
+	plan, plan_err := PlanRestore(pending.Backup, current, pending.Mode)
+
+	if plan_err != nil {
+		log.Printf("error planning restore: %v\n", plan_err)
+		return c.Edit(MSG_COULD_NOT_PERFORM)
+	}
+
+	done, err := ApplyRestore(c.Sender().ID, plan)
+
+	if err != nil {
+		log.Printf("error restoring backup: %v\n", err)
+
+		if errors.Is(err, ErrConflict) || errors.Is(err, ErrDuplicate) {
+			return c.Edit(fmt.Sprintf("%s\n\n%d change%s had been applied.", MSG_CONFLICT, done, BoolToStr(done != 1, "s", "")))
+		}
+
+		return c.Edit(fmt.Sprintf("The restore failed after %d change%s.", done, BoolToStr(done != 1, "s", "")))
+	}
+
+	// logging
+
+	name := c.Sender().FirstName + " " + c.Sender().LastName
+
+	ChanLogf("#restore\n[<code>%d</code>] %shas restored a backup of %s (%s):\n\n%s",
+		c.Sender().ID,
+		BoolToStr(name != "", name+" ", ""),
+		pending.Backup.Created.UTC().Format("2006-01-02 15:04"),
+		pending.Mode,
+		RestorePlanToStr(plan),
+	)
+
+	// returning
+
+	return c.Edit("Backup restored:\n\n" + RestorePlanToStr(plan))
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	tele "github.com/Henry96Markle/telebot"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Serves archive as the document any /restore replies to.
+func fakeDownload(t *testing.T, archive []byte) {
+	old := Download
+	Download = func(tele.Context, *tele.File) (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(archive)), nil
+	}
+
+	t.Cleanup(func() { Download = old })
+}
+
+func restoreCommand(text string, archive []byte, t *testing.T) *fakeContext {
+	fakeDownload(t, archive)
+
+	c := newCommand(testOwnerID, testOwnerID, text, &tele.User{ID: testOwnerID})
+	c.message.ReplyTo.Document = &tele.Document{FileName: "backup.json.gz"}
+
+	return c
+}
+
+func backupOf(t *testing.T, schema int, users ...User) []byte {
+	buf := bytes.Buffer{}
+
+	if err := WriteBackup(&buf, users, schema); err != nil {
+		t.Fatalf("error writing backup: %v", err)
+	}
+
+	return buf.Bytes()
+}
+
+func withObjectID(u User) User {
+	u.ID = primitive.NewObjectID()
+	return u
+}
+
+func TestBackupHandler(t *testing.T) {
+	setupTest(t, withObjectID(recordedTarget()))
+
+	c := newCommand(testOwnerID, testOwnerID, "/backup", nil)
+
+	if err := BackupHandler(c); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(c.documents) != 1 {
+		t.Fatalf("expected a document, got %v", c.replies)
+	}
+
+	backup, err := ReadBackup(c.documents[0].File.FileReader)
+
+	if err != nil {
+		t.Fatalf("error reading backup: %v", err)
+	}
+
+	// The owner, the team members and the target.
+	if len(backup.Users) != 5 || backup.Format != BACKUP_FORMAT {
+		t.Errorf("unexpected backup %+v", backup)
+	}
+}
+
+func TestReadBackup(t *testing.T) {
+	target := withObjectID(recordedTarget())
+
+	gzipped := func(v any) []byte {
+		buf := bytes.Buffer{}
+		gz := gzip.NewWriter(&buf)
+		json.NewEncoder(gz).Encode(v)
+		gz.Close()
+		return buf.Bytes()
+	}
+
+	tests := []struct {
+		name    string
+		archive []byte
+		err     string
+	}{
+		{"valid", backupOf(t, 0, target), ""},
+		{"not gzip", []byte("{}"), "not a compressed backup"},
+		{"wrong format", gzipped(Backup{Format: "other"}), "not a backup"},
+		{"newer format", gzipped(Backup{Format: BACKUP_FORMAT, Version: BACKUP_VERSION + 1}), "format version"},
+		{"newer schema", backupOf(t, LatestSchemaVersion(Migrations)+1, target), "schema version"},
+		{"duplicate ID", backupOf(t, 0, target, withObjectID(target)), "more than once"},
+		{"missing ID", backupOf(t, 0, withObjectID(User{})), "has no ID"},
+		{"repeated document ID", backupOf(t, 0, target, func() User { u := newTestUser(3000, 0); u.ID = target.ID; return u }()), "document ID"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backup, err := ReadBackup(bytes.NewReader(tt.archive))
+
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				// Older users are migrated on the way in.
+				if backup.Schema != LatestSchemaVersion(Migrations) || len(backup.Users[0].History) == 0 {
+					t.Errorf("expected the users to be migrated, got %+v", backup.Users[0])
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("expected error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestRestoreBackup(t *testing.T) {
+	stored := withObjectID(recordedTarget())
+
+	// The backup has lost a record, but knows of another user.
+	saved, _ := cloneUser(stored)
+	saved.Records["bans"] = saved.Records["bans"][:1]
+	saved.Records["warns"] = []Record{{ChatID: testGroupID, Notes: []string{"old"}, Date: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)}}
+
+	other := withObjectID(newTestUser(3000, 0))
+
+	tests := []struct {
+		name     string
+		mode     string
+		preview  string
+		result   func(t *testing.T)
+		noChange bool
+	}{
+		{
+			name:    "merge",
+			mode:    "",
+			preview: "add 1 user\n\t- update 1 user (1 record added back)\n\t- leave 0 users unchanged",
+			result: func(t *testing.T) {
+				u := mustFind(t, testTargetID)
+
+				if len(u.Records["bans"]) != 2 || len(u.Records["warns"]) != 2 {
+					t.Errorf("expected the records to be merged, got %v", u.Records)
+				}
+
+				mustFind(t, 3000)
+				mustFind(t, testOwnerID)
+			},
+		},
+		{
+			name:    "replace",
+			mode:    " replace",
+			preview: "add 1 user\n\t- update 1 user\n\t- remove 4 users (moved to the trash)\n\t- leave 0 users unchanged",
+			result: func(t *testing.T) {
+				u := mustFind(t, testTargetID)
+
+				if len(u.Records["bans"]) != 1 || len(u.Records["warns"]) != 1 {
+					t.Errorf("expected the records to be replaced, got %v", u.Records)
+				}
+
+				if _, err := Data.FindByID(testOperatorID); err == nil {
+					t.Error("expected the operator to be removed")
+				}
+
+				if trash, _ := Data.FindTrash(testOperatorID); len(trash) != 1 {
+					t.Errorf("expected the operator in the trash, got %v", trash)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTest(t, stored)
+
+			c := restoreCommand("/restore"+tt.mode, backupOf(t, LatestSchemaVersion(Migrations), saved, other), t)
+
+			if err := RestoreHandler(c); err != nil {
+				t.Fatal(err)
+			}
+
+			if !strings.Contains(c.last(), tt.preview) {
+				t.Fatalf("unexpected preview %q", c.last())
+			}
+
+			// Nothing happens before the confirmation.
+			if _, err := Data.FindByID(3000); err == nil {
+				t.Fatal("expected no change before confirming")
+			}
+
+			data := c.lastMarkup().InlineKeyboard[0][1].Data
+
+			cb := newCallback(testOwnerID, BTN_CONFIRM_RESTORE, data)
+
+			if err := ConfirmRestoreBtnHandler(cb); err != nil {
+				t.Fatal(err)
+			}
+
+			if !strings.HasPrefix(cb.last(), "Backup restored") {
+				t.Fatalf("unexpected result %q", cb.last())
+			}
+
+			tt.result(t)
+
+			if _, count, _ := Data.FindAudit(3000, AUDIT_BACKUP, time.Time{}, 0, 1); count != 1 {
+				t.Errorf("expected an audit entry, got %d", count)
+			}
+
+			// The confirmation can't be used twice.
+			again := newCallback(testOwnerID, BTN_CONFIRM_RESTORE, data)
+			ConfirmRestoreBtnHandler(again)
+
+			if !strings.Contains(again.last(), "expired") {
+				t.Errorf("expected the restore to be spent, got %q", again.last())
+			}
+		})
+	}
+}
+
+func TestRestoreBackupRejections(t *testing.T) {
+	stored := withObjectID(recordedTarget())
+
+	tests := []struct {
+		name   string
+		sender int64
+		text   string
+		backup []byte
+		want   string
+	}{
+		{"not the owner", testOperatorID, "/restore", backupOf(t, 0, stored), "Only the owner"},
+		{"unknown mode", testOwnerID, "/restore overwrite", backupOf(t, 0, stored), "Unknown mode"},
+		{"invalid backup", testOwnerID, "/restore", []byte("nope"), "Invalid backup"},
+		{"nothing to do", testOwnerID, "/restore", backupOf(t, LatestSchemaVersion(Migrations), stored), "nothing to restore"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTest(t, stored)
+
+			c := restoreCommand(tt.text, tt.backup, t)
+			c.message.Sender.ID = tt.sender
+
+			if err := RestoreHandler(c); err != nil {
+				t.Fatal(err)
+			}
+
+			if !strings.Contains(c.last(), tt.want) {
+				t.Errorf("expected %q, got %q", tt.want, c.last())
+			}
+		})
+	}
+}
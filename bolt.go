@@ -0,0 +1,453 @@
+package main
+
+import (
+	"encoding/binary"
+	"time"
+
+	"go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Opens (or creates) a BoltDB file at path and makes sure the buckets exist.
+func NewBoltDatabase(path string, bucket string, audit string, trash string, meta string) (*BoltDatabase, error) {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: 5 * time.Second})
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range []string{bucket, audit, trash, meta} {
+			if _, e := tx.CreateBucketIfNotExists([]byte(name)); e != nil {
+				return e
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltDatabase{
+		db:     db,
+		bucket: []byte(bucket),
+		audit:  []byte(audit),
+		trash:  []byte(trash),
+		meta:   []byte(meta),
+	}, nil
+}
+
+// Users are keyed by their tg_id, in big-endian order.
+func boltKey(id int64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(id))
+
+	return key
+}
+
+func (d BoltDatabase) Disconnect() error {
+	return d.db.Close()
+}
+
+func (d BoltDatabase) Filter(filter bson.D) (users []User, err error) {
+	users = make([]User, 0)
+
+	err = d.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(d.bucket).ForEach(func(_, v []byte) error {
+			user := User{}
+
+			if decode_err := bson.Unmarshal(v, &user); decode_err == nil && MatchFilter(user, filter) {
+				users = append(users, user)
+			}
+
+			return nil
+		})
+	})
+
+	return
+}
+
+func (d BoltDatabase) GetAll() ([]User, error) {
+	return d.Filter(bson.D{{}})
+}
+
+func (d BoltDatabase) FindByIdentity(field string, value string) (users []User, err error) {
+	users = make([]User, 0)
+
+	err = d.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(d.bucket).ForEach(func(_, v []byte) error {
+			user := User{}
+
+			if decode_err := bson.Unmarshal(v, &user); decode_err == nil && MatchIdentity(user, field, value) {
+				users = append(users, user)
+			}
+
+			return nil
+		})
+	})
+
+	return
+}
+
+func (d BoltDatabase) FindNotes(author int64, terms []string) (users []User, err error) {
+	users = make([]User, 0)
+
+	err = d.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(d.bucket).ForEach(func(_, v []byte) error {
+			user := User{}
+
+			if decode_err := bson.Unmarshal(v, &user); decode_err == nil && MatchNotes(user, author, terms) {
+				users = append(users, user)
+			}
+
+			return nil
+		})
+	})
+
+	return
+}
+
+func (d BoltDatabase) FindByID(id int64) (User, error) {
+	user := User{}
+
+	err := d.db.View(func(tx *bbolt.Tx) error {
+		v := tx.Bucket(d.bucket).Get(boltKey(id))
+
+		if v == nil {
+			return ErrNotFound
+		}
+
+		return bson.Unmarshal(v, &user)
+	})
+
+	if err != nil {
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+func (d BoltDatabase) Add(users ...User) error {
+	return d.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(d.bucket)
+
+		for _, u := range users {
+			if b.Get(boltKey(u.TelegramID)) != nil {
+				return ErrDuplicate
+			}
+
+			v, err := bson.Marshal(u)
+
+			if err != nil {
+				return err
+			}
+
+			if err = b.Put(boltKey(u.TelegramID), v); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (d BoltDatabase) RemoveByID(id int64) (int64, error) {
+	count := int64(0)
+
+	err := d.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(d.bucket)
+
+		if b.Get(boltKey(id)) == nil {
+			return nil
+		}
+
+		count = 1
+
+		return b.Delete(boltKey(id))
+	})
+
+	return count, err
+}
+
+func (d BoltDatabase) ReplaceByID(id int64, user User) error {
+	return d.update(id, func(u *User) error {
+		if u.Version != user.Version {
+			return ErrConflict
+		}
+
+		*u = user
+		return nil
+	})
+}
+
+func (d BoltDatabase) Aliases(pull bool, id int64, ids ...int64) error {
+	return d.update(id, func(u *User) error {
+		if pull {
+			u.AliasIDs = Undupe(u.AliasIDs, ids)
+		} else {
+			u.AliasIDs = append(u.AliasIDs, ids...)
+		}
+
+		return nil
+	})
+}
+
+func (d BoltDatabase) Names(pull bool, id int64, names ...string) error {
+	return d.update(id, func(u *User) error {
+		if pull {
+			u.Names = Undupe(u.Names, names)
+		} else {
+			u.Names = append(u.Names, names...)
+		}
+
+		return nil
+	})
+}
+
+func (d BoltDatabase) Usernames(pull bool, id int64, usernames ...string) error {
+	return d.update(id, func(u *User) error {
+		if pull {
+			u.Usernames = Undupe(u.Usernames, usernames)
+		} else {
+			u.Usernames = append(u.Usernames, usernames...)
+		}
+
+		return nil
+	})
+}
+
+func (d BoltDatabase) Record(id int64, category string, record Record, remove bool) error {
+	return d.update(id, func(u *User) error {
+		if u.Records == nil {
+			u.Records = map[string][]Record{}
+		}
+
+		if remove {
+			kept := make([]Record, 0, len(u.Records[category]))
+
+			for _, r := range u.Records[category] {
+				if !SameRecord(r, record) {
+					kept = append(kept, r)
+				}
+			}
+
+			u.Records[category] = kept
+		} else {
+			u.Records[category] = append(u.Records[category], record)
+		}
+
+		return nil
+	})
+}
+
+// Reads the user with the given tg_id, applies modify and writes it back in a single transaction.
+func (d BoltDatabase) TouchIdentity(id int64, kind string, value string, at time.Time) error {
+	return d.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(d.bucket)
+		v := b.Get(boltKey(id))
+
+		if v == nil {
+			return ErrNotFound
+		}
+
+		user := User{}
+
+		if err := bson.Unmarshal(v, &user); err != nil {
+			return err
+		}
+
+		if !touchIdentity(user.History, kind, value, at) {
+			return nil
+		}
+
+		encoded, err := bson.Marshal(user)
+
+		if err != nil {
+			return err
+		}
+
+		return b.Put(boltKey(id), encoded)
+	})
+}
+
+func (d BoltDatabase) update(id int64, modify func(*User) error) error {
+	return d.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(d.bucket)
+		v := b.Get(boltKey(id))
+
+		if v == nil {
+			return ErrNotFound
+		}
+
+		user := User{}
+
+		if err := bson.Unmarshal(v, &user); err != nil {
+			return err
+		}
+
+		if err := modify(&user); err != nil {
+			return err
+		}
+
+		user.Version++
+
+		encoded, err := bson.Marshal(user)
+
+		if err != nil {
+			return err
+		}
+
+		// The tg_id is unique, as Mongo's index keeps it.
+		if user.TelegramID != id && b.Get(boltKey(user.TelegramID)) != nil {
+			return ErrDuplicate
+		}
+
+		b.Delete(boltKey(id))
+
+		return b.Put(boltKey(user.TelegramID), encoded)
+	})
+}
+
+// Audit entries are keyed by their ObjectID, which starts with a timestamp; the bucket is
+// therefore ordered chronologically.
+func (d BoltDatabase) AddAudit(entry AuditEntry) error {
+	v, err := bson.Marshal(entry)
+
+	if err != nil {
+		return err
+	}
+
+	return d.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(d.audit).Put(entry.ID[:], v)
+	})
+}
+
+func (d BoltDatabase) FindAudit(target int64, action string, since time.Time, skip int64, limit int64) ([]AuditEntry, int64, error) {
+	entries := make([]AuditEntry, 0, limit)
+	count := int64(0)
+
+	err := d.db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(d.audit).Cursor()
+
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			entry := AuditEntry{}
+
+			if err := bson.Unmarshal(v, &entry); err != nil || !MatchAudit(entry, target, action, since) {
+				continue
+			}
+
+			if count >= skip && count < skip+limit {
+				entries = append(entries, entry)
+			}
+
+			count++
+		}
+
+		return nil
+	})
+
+	return entries, count, err
+}
+
+// Trash entries are keyed by their ObjectID, just like audit entries.
+func (d BoltDatabase) AddTrash(entry TrashEntry) error {
+	v, err := bson.Marshal(entry)
+
+	if err != nil {
+		return err
+	}
+
+	return d.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(d.trash).Put(entry.ID[:], v)
+	})
+}
+
+func (d BoltDatabase) FindTrash(id int64) ([]TrashEntry, error) {
+	entries := make([]TrashEntry, 0)
+
+	err := d.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(d.trash).ForEach(func(_, v []byte) error {
+			entry := TrashEntry{}
+
+			if err := bson.Unmarshal(v, &entry); err == nil && (id == 0 || entry.TelegramID == id) {
+				entries = append(entries, entry)
+			}
+
+			return nil
+		})
+	})
+
+	return entries, err
+}
+
+func (d BoltDatabase) RemoveTrash(ids ...primitive.ObjectID) error {
+	return d.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(d.trash)
+
+		for _, id := range ids {
+			if err := b.Delete(id[:]); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (d BoltDatabase) PurgeTrash(before time.Time) (int64, error) {
+	count := int64(0)
+
+	err := d.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(d.trash)
+		expired := make([][]byte, 0)
+
+		b.ForEach(func(k, v []byte) error {
+			entry := TrashEntry{}
+
+			if err := bson.Unmarshal(v, &entry); err == nil && entry.Expires.Before(before) {
+				expired = append(expired, append([]byte{}, k...))
+			}
+
+			return nil
+		})
+
+		for _, k := range expired {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+
+			count++
+		}
+
+		return nil
+	})
+
+	return count, err
+}
+
+func (d BoltDatabase) SchemaVersion() (version int, err error) {
+	err = d.db.View(func(tx *bbolt.Tx) error {
+		if v := tx.Bucket(d.meta).Get([]byte(META_SCHEMA_VERSION)); len(v) == 8 {
+			version = int(binary.BigEndian.Uint64(v))
+		}
+
+		return nil
+	})
+
+	return
+}
+
+func (d BoltDatabase) SetSchemaVersion(version int) error {
+	return d.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(d.meta).Put([]byte(META_SCHEMA_VERSION), boltKey(int64(version)))
+	})
+}
+
+// Users are keyed by their tg_id, which is therefore unique; there's nothing else to index.
+func (d BoltDatabase) EnsureIndexes() error {
+	return nil
+}
@@ -4,10 +4,10 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"os"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	tele "github.com/Henry96Markle/telebot"
 	"go.mongodb.org/mongo-driver/bson"
@@ -15,6 +15,10 @@ import (
 )
 
 func CreditsHandler(ctx tele.Context) error {
+	if _, args_err := ParseArgs(ctx, CommandSpecs[CMD_CREDITS]); args_err != nil {
+		return ReplyArgsErr(ctx, CommandSpecs[CMD_CREDITS], args_err)
+	}
+
 	return ctx.Reply(
 		fmt.Sprintf(
 			CREDITS,
@@ -29,7 +33,13 @@ func CreditsHandler(ctx tele.Context) error {
 //	- /help
 //	- /help <command>
 func HelpHandler(ctx tele.Context) error {
-	if len(ctx.Args()) == 0 {
+	args, args_err := ParseArgs(ctx, CommandSpecs[CMD_HELP])
+
+	if args_err != nil {
+		return ReplyArgsErr(ctx, CommandSpecs[CMD_HELP], args_err)
+	}
+
+	if !args.Has("command") {
 		if ctx.Chat().ID == ctx.Sender().ID {
 			return ctx.Reply(
 				HELP_MAIN,
@@ -42,21 +52,19 @@ func HelpHandler(ctx tele.Context) error {
 				},
 			})
 		}
+	} else if syntax, ok := CommandSyntax[strings.TrimPrefix(args.Str("command"), "/")]; ok {
+		return ctx.Reply(syntax)
 	} else {
-		syntax, ok := CommandSyntax[ctx.Args()[0]]
-
-		if ok {
-			return ctx.Reply(syntax)
-		} else {
-			return ctx.Reply("Unknown command.")
-		}
+		return ctx.Reply("Unknown command.")
 	}
 }
 
 // Syntax:
 //
-//	- /recall <ID/reply-to-message>
+//	- /recall <reply-to-message>
+//	- /recall <ID/@username>
 //	- /recall <username/name> <value>
+//	- /recall <name/username/description>
 func RecallHandler(ctx tele.Context) error {
 	var (
 		id    int64
@@ -66,44 +74,46 @@ func RecallHandler(ctx tele.Context) error {
 
 		users []User
 
-		parse_err error
-		data_err  error
-
-		length = len(ctx.Args())
+		data_err error
 	)
 
-	// Acquire id and/or field, depending on args length
+	// With no arguments, the replied-to message is recalled
+
+	if len(ctx.Args()) == 0 {
+		if IsForward(ctx.Message().ReplyTo) {
+			return IdentifyForward(ctx, ctx.Message().ReplyTo)
+		}
 
-	switch length {
-	case 0:
 		if ctx.Message().ReplyTo == nil || ctx.Message().ReplyTo.Sender == nil {
 			return ctx.Reply(MSG_ID_REQUIRED)
 		}
 
-		id = ctx.Message().ReplyTo.Sender.ID
-		field = "id"
-	case 1:
-		if id, parse_err = strconv.ParseInt(ctx.Args()[0], 0, 64); parse_err != nil {
-			return ctx.Reply(MSG_INVALID_ID)
+		id, field = ctx.Message().ReplyTo.Sender.ID, "id"
+	} else {
+		args, args_err := ParseArgs(ctx, CommandSpecs[CMD_RECALL])
+
+		if args_err != nil {
+			return ReplyArgsErr(ctx, CommandSpecs[CMD_RECALL], args_err)
 		}
 
-		field = "id"
-	case 2:
-		field, value = ctx.Args()[0], ctx.Args()[1]
-	default:
-		field = ctx.Args()[0]
+		field, value = args.Str("field"), args.Str("query")
 
-		if field == "name" {
-			value = strings.Join(ctx.Args()[1:], " ")
-		} else if field == "username" {
-			value = ctx.Args()[1]
+		// Anything that isn't an ID, or a username known to belong to someone, is searched for among
+		// every field.
+		if field == "" {
+			field = "any"
+
+			if !strings.Contains(value, " ") {
+				if target, err := ResolveUser(ctx, value); err == nil {
+					id, field = target.ID, "id"
+				}
+			}
 		}
 	}
 
 	// Query user(s), based on field
 
-	switch field {
-	case "id":
+	if field == "id" {
 		var user User
 		user, data_err = Data.FindByID(id)
 
@@ -112,20 +122,12 @@ func RecallHandler(ctx tele.Context) error {
 		} else {
 			users = []User{user}
 		}
-	case "name":
-		users, data_err = Data.Filter(bson.D{{Key: "names", Value: value}})
-
-		if data_err != nil {
-			log.Printf("error querying users by name: %v\n", data_err)
-		}
-	case "username":
-		users, data_err = Data.Filter(bson.D{{Key: "usernames", Value: strings.TrimLeft(value, "@")}})
+	} else {
+		users, data_err = RecallSearch(field, value)
 
 		if data_err != nil {
-			log.Printf("error querying users by name: %v\n", data_err)
+			log.Printf("error searching users by %s: %v\n", field, data_err)
 		}
-	default:
-		return ctx.Reply("Unknown field name: \"" + field + "\"")
 	}
 
 	if len(users) == 0 {
@@ -133,50 +135,14 @@ func RecallHandler(ctx tele.Context) error {
 		return ctx.Reply(MSG_NO_MATCH)
 	} else if len(users) == 1 {
 		// If there's exactly one match
-		d := DisplayUser(&users[0])
-
-		if len(d) > 4096 {
-			return ctx.Reply("The result's length exceeds the message size limit.", UploadResultBtnKeyboard)
-		}
-
-		deleteBtn := *DeleteEntryBtn
-		deleteBtn.Data = fmt.Sprintf("%d", id)
-
-		var keyboard *tele.ReplyMarkup
-
-		sender, _ := Data.FindByID(ctx.Sender().ID)
-
-		// If the sender has read/write permissions, and was in PM, and
-		// the queried user wasn't the owner or an operator, a delete buttons shows up.
-		if (sender.Permission >= 2) &&
-			(ctx.Chat().ID == ctx.Sender().ID) &&
-			(users[0].Permission < 3) &&
-			(users[0].TelegramID != Config.OwnerTelegramID) {
-
-			keyboard = &tele.ReplyMarkup{
-				InlineKeyboard: [][]tele.InlineButton{
-					{*deleteBtn.Inline()},
-				},
-			}
-		}
+		text, keyboard := ProfileMessage(ctx, users[0], 0, "")
 
-		return ctx.Reply(d, keyboard, tele.ModeHTML)
+		return ctx.Reply(text, keyboard, tele.ModeHTML)
 	} else {
 		// If there's more than one match
+		text, keyboard := MatchesMessage(users, field, value, 0)
 
-		str := make([]string, 0, len(users))
-
-		for _, u := range users {
-			str = append(
-				str,
-				fmt.Sprintf("[<code>%d</code>] %s", u.TelegramID, BoolToStr(len(u.Names) > 0, u.Names[len(u.Names)-1], "")),
-			)
-		}
-
-		return ctx.Reply(
-			fmt.Sprintf("<b>%d</b> users matched:\n\n\t- %s", len(users), strings.Join(str, "\n\t- ")),
-			tele.ModeHTML,
-		)
+		return ctx.Reply(text, keyboard, tele.ModeHTML)
 	}
 }
 
@@ -213,21 +179,20 @@ func ConfirmOperatorBtnHandler(c tele.Context) error {
 		return c.Edit("Invalid callback data.")
 	}
 
-	user, data_err := Data.FindByID(user_to_confirm)
-
-	if data_err != nil {
-		log.Printf("error querying user ID: %v\n", data_err)
-		return c.Edit("Could not perform this operation.")
-	}
-
-	user.Permission = 3
-	err := Data.ReplaceByID(user_to_confirm, user)
+	before, user, err := UpdateUser(user_to_confirm, func(u *User) error {
+		u.Permission = 3
+		return nil
+	})
 
-	if err != nil {
+	if errors.Is(err, ErrConflict) {
+		return c.Edit(MSG_CONFLICT)
+	} else if err != nil {
 		log.Printf("error updating user permission: %v\n", err)
 		return c.Edit("Could not perform this action.")
 	}
 
+	AuditLog(c.Sender().ID, user_to_confirm, AUDIT_OP_CONFIRM, &before, &user)
+
 	// logging
 
 	name := c.Message().Sender.FirstName + " " + c.Message().Sender.LastName
@@ -282,167 +247,87 @@ func RecallHelpBtnHandler(ctx tele.Context) error {
 	return ctx.Edit(s, BackToHelpKeyboard)
 }
 
-func UploadResultBtnHandler(ctx tele.Context) error {
-	err := os.WriteFile(BUFF_FILE_PATH, []byte(StringBuffer), 0664)
-
-	if err != nil {
-		return ctx.Respond(&tele.CallbackResponse{Text: "An error has occurred."})
-	}
-
-	year, month, day := time.Now().Date()
-
-	f := tele.Document{
-		File:     tele.FromDisk(BUFF_FILE_PATH),
-		FileName: fmt.Sprintf("Result-%4d-%s-%2d.txt", year, month.String(), day),
-	}
-
-	ctx.Delete()
-	_, err = ctx.Bot().Send(ctx.Chat(), &f)
-	return err
-}
-
 // Syntax:
-// 	- /alias <ID/reply-to-message> <add/remove> <name/ID/username> <value1>; <value2> ..
+// 	- /alias <ID/@username/mention/reply-to-message> <add/remove> <name/ID/username> <value1>; <value2> ..
 //
 // TODO:
 //	Must implement server-side duplicate checking.
 func AliasHandler(ctx tele.Context) error {
-	var (
-		length = len(ctx.Args())
-		remove = false
-		mode   = ""
-		values = make([]string, 0, 1)
-
-		id int64
-
-		b_err error
-		p_err error
-
-		fetch = func(index int) {
-			remove, b_err = StrToBool(ctx.Args()[index], "remove", "add")
-			mode = ctx.Args()[index+1]
-			values = strings.Split(strings.Join(ctx.Args()[index+2:], " "), ";")
-		}
-	)
-
-	if length < 3 {
-		return ctx.Reply("Insufficient arguments.")
-	} else if length == 3 {
-		// assuming that the id was given via message reply
-
-		if ctx.Message().ReplyTo == nil || ctx.Message().ReplyTo.Sender == nil {
-			return ctx.Reply("ID required.")
-		}
-
-		fetch(0)
+	args, args_err := ParseArgs(ctx, CommandSpecs[CMD_ALIAS])
 
-		id = ctx.Message().ReplyTo.Sender.ID
-	} else {
-		// Acquire ID first
-
-		if id, p_err = strconv.ParseInt(ctx.Args()[0], 0, 64); p_err == nil {
-			fetch(1)
-		} else if ctx.Message().ReplyTo != nil && ctx.Message().ReplyTo.Sender != nil {
-			fetch(0)
-
-			id = ctx.Message().ReplyTo.Sender.ID
-		} else {
-			return ctx.Reply("ID required.")
-		}
+	if args_err != nil {
+		return ReplyArgsErr(ctx, CommandSpecs[CMD_ALIAS], args_err)
 	}
 
-	// Check for errors
-
-	if b_err != nil {
-		log.Printf("error parsing string to boolean: %v\n", b_err)
-		return ctx.Reply("Unknown add/remove value.")
-	}
+	var (
+		id     = args.Target.ID
+		remove = args.Str("operation") == "remove"
+		mode   = args.Str("kind")
+		values = args.List("value")
+	)
 
-	if p_err != nil {
-		return ctx.Reply("Invalid ID.")
+	if len(values) == 0 {
+		return ctx.Reply(MSG_INSUFFICIENT_ARGS)
 	}
 
-	user, u_err := Data.FindByID(id)
-
-	if u_err != nil {
+	if _, u_err := Data.FindByID(id); u_err != nil {
 		log.Printf("error querying user by ID: %v\n", u_err)
 		return ctx.Reply("ID not found.")
 	}
 
-	if mode == "name" {
-
-		if !remove {
-			values = Undupe(values, user.Names)
-		}
+	kind := ""
 
-		err := Data.Names(remove, id, values...)
-
-		if err != nil {
-			log.Printf(
-				"error at %s%s %s request: %v\n",
-				mode, BoolToStr(len(values) > 1, "s", ""),
-				BoolToStr(remove, "pull", "push"),
-				err,
-			)
-
-			return ctx.Reply("Could not perform this action.")
-		}
+	if mode == "name" {
+		kind = IDENTITY_NAME
 	} else if mode == "username" {
+		kind = IDENTITY_USERNAME
 
-		usernames := make([]string, 0, len(values))
-
-		for _, v := range values {
-			usernames = append(usernames, strings.TrimLeft(v, "@"))
+		for i, v := range values {
+			values[i] = TrimUsername(v)
 		}
+	} else {
+		kind = IDENTITY_ALIAS
 
-		if !remove {
-			usernames = Undupe(usernames, user.Usernames)
-		}
+		values = Map(values, func(s string) (string, error) {
+			i, e := strconv.ParseInt(s, 0, 64)
 
-		err := Data.Usernames(remove, id, usernames...)
+			if e != nil {
+				log.Printf("error parsing string \"%s\": %v\n", s, e)
+				return "", e
+			}
 
-		if err != nil {
-			log.Printf(
-				"error at %s%s %s request: %v\n",
-				mode, BoolToStr(len(values) > 1, "s", ""),
-				BoolToStr(remove, "pull", "push"),
-				err,
-			)
+			// A user can't be its own alias.
+			if i == id && !remove {
+				return "", errors.New("alias ID is the user's own ID")
+			}
 
-			return ctx.Reply("Could not perform this action.")
-		}
-	} else if mode == "id" {
+			return strconv.FormatInt(i, 10), nil
+		})
+	}
 
-		var filtered_values []int64
-		mapped := Map(values, func(s string) (int64, error) {
-			i, e := strconv.ParseInt(s, 0, 64)
+	before, after, err := UpdateUser(id, func(u *User) error {
+		now := time.Now()
 
-			if e == nil {
-				return i, nil
+		for _, v := range values {
+			if remove {
+				ForgetIdentity(u, kind, v)
 			} else {
-				log.Printf("error parsing string \"%s\": %v\n", s, e)
-				return 0, e
+				NoteIdentity(u, kind, v, SOURCE_MANUAL, ctx.Sender().ID, now)
 			}
-		})
-
-		if !remove {
-			to_check := user.AliasIDs
-			to_check = append(to_check, user.TelegramID)
-			filtered_values = Undupe(mapped, to_check)
-		} else {
-			filtered_values = mapped
 		}
 
-		err := Data.Aliases(remove, id, filtered_values...)
+		return nil
+	})
 
-		if err != nil {
-			log.Printf("error sending %s request: %v\n", BoolToStr(remove, "pull", "push"), err)
-			return ctx.Reply("Could not perform this action.")
-		}
-	} else {
-		return ctx.Reply("Unknown mode value.")
+	if errors.Is(err, ErrConflict) {
+		return ctx.Reply(MSG_CONFLICT)
+	} else if err != nil {
+		log.Printf("error sending %s request: %v\n", BoolToStr(remove, "pull", "push"), err)
+		return ctx.Reply("Could not perform this action.")
 	}
 
+	AuditLog(ctx.Sender().ID, id, AUDIT_ALIAS, &before, &after)
+
 	// logging
 
 	name := ctx.Message().Sender.FirstName + " " + ctx.Message().Sender.LastName
@@ -464,50 +349,33 @@ func AliasHandler(ctx tele.Context) error {
 
 // Syntax:
 //
-//	- /record <ID/reply-to-message> <category> [note1; note2; note3; ...]
+//	- /record <ID/@username/mention/reply-to-message> <category> [note1; note2; note3; ...]
 func RecordHandler(ctx tele.Context) error {
-	var (
-		length = len(ctx.Args())
+	args, args_err := ParseArgs(ctx, CommandSpecs[CMD_RECORD])
 
-		parse_err error
+	if args_err != nil {
+		return ReplyArgsErr(ctx, CommandSpecs[CMD_RECORD], args_err)
+	}
 
-		category string
+	var (
+		id       = args.Target.ID
+		category = args.Str("category")
+		notes    = args.List("note")
 
-		notes  []string
 		record Record
-
-		id int64
 	)
 
-	if length < 1 {
-		return ctx.Reply("Insufficient arguments.")
-	} else if length == 1 {
-		if ctx.Message().ReplyTo == nil || ctx.Message().ReplyTo.Sender == nil {
-			return ctx.Reply("ID required.")
-		} else {
-			id = ctx.Message().ReplyTo.Sender.ID
-		}
-	} else {
-		if id, parse_err = strconv.ParseInt(ctx.Args()[0], 0, 64); parse_err != nil {
-			log.Printf("error when parsing ID: %v\n", parse_err)
-			return ctx.Reply("Invalid ID.")
-		} else {
-			category = ctx.Args()[1]
-
-			joined := strings.Join(ctx.Args()[2:], " ")
-
-			notes = strings.Split(joined, ";")
-
-			for i, s := range notes {
-				notes[i] = strings.Trim(s, " ")
-			}
-		}
+	record = Record{
+		ChatID:    ctx.Chat().ID,
+		Notes:     notes,
+		Date:      time.Now(),
+		Author:    ctx.Sender().ID,
+		MessageID: ctx.Message().ID,
 	}
 
-	record = Record{
-		ChatID: ctx.Chat().ID,
-		Notes:  notes,
-		Date:   time.Now(),
+	if ctx.Message().ReplyTo != nil {
+		record.ReplyTo = ctx.Message().ReplyTo.ID
+		record.Link = MessageLink(ctx.Chat(), record.ReplyTo)
 	}
 
 	f_user, f_err := Data.FindByID(id)
@@ -520,21 +388,29 @@ func RecordHandler(ctx tele.Context) error {
 		return ctx.Reply("You can't record an owner.")
 	}
 
-	_, ok := f_user.Records[category]
-
-	if ok {
-		f_user.Records[category] = append(f_user.Records[category], record)
-	} else {
-		f_user.Records[category] = []Record{record}
+	// The message may be deleted, so it's kept as it is now.
+	if Config.CaptureEvidence && ctx.Message().ReplyTo != nil {
+		record.Evidence = CaptureEvidence(ctx, ctx.Message().ReplyTo)
 	}
 
-	err := Data.ReplaceByID(id, f_user)
+	before, f_user, err := UpdateUser(id, func(u *User) error {
+		if u.Records == nil {
+			u.Records = map[string][]Record{}
+		}
 
-	if err != nil {
+		u.Records[category] = append(u.Records[category], record)
+		return nil
+	})
+
+	if errors.Is(err, ErrConflict) {
+		return ctx.Reply(MSG_CONFLICT)
+	} else if err != nil {
 		log.Printf("error replacing by ID: %v\n", err)
 		return ctx.Reply("Could not complete this action.")
 	}
 
+	AuditLog(ctx.Sender().ID, id, AUDIT_RECORD, &before, &f_user)
+
 	// logging
 
 	name := ctx.Message().Sender.FirstName + " " + ctx.Message().Sender.LastName
@@ -551,15 +427,35 @@ func RecordHandler(ctx tele.Context) error {
 	return ctx.Reply("Recorded.")
 }
 
+// Builds a user to register, registered by the given ID. If the Telegram user is known, their name and
+// username are noted.
+func NewUser(id int64, sender *tele.User, description string, by int64) User {
+	user := User{
+		ID:          primitive.NewObjectID(),
+		TelegramID:  id,
+		Names:       make([]string, 0, 1),
+		Usernames:   make([]string, 0, 1),
+		AliasIDs:    make([]int64, 0),
+		Description: description,
+		Records:     map[string][]Record{},
+	}
+
+	if sender != nil {
+		NoteIdentity(&user, IDENTITY_NAME, strings.TrimSpace(sender.FirstName+" "+sender.LastName), SOURCE_REGISTRATION, by, time.Now())
+		NoteIdentity(&user, IDENTITY_USERNAME, sender.Username, SOURCE_REGISTRATION, by, time.Now())
+	}
+
+	return user
+}
+
 // Syntax:
 //
-//	- /rec <ID/reply-to-message> [description]
+//	- /reg <ID/@username/mention/reply-to-message> [description]
 func RegHandler(ctx tele.Context) error {
 	var (
 		id int64
 
-		parse_err error
-		data_err  error
+		data_err error
 
 		sender *tele.User
 		user   User
@@ -567,57 +463,39 @@ func RegHandler(ctx tele.Context) error {
 		description = ""
 	)
 
-	if len(ctx.Args()) == 0 {
-		if ctx.Message().ReplyTo != nil && ctx.Message().ReplyTo.Sender != nil {
-			sender = ctx.Message().Sender
-			id = ctx.Message().ReplyTo.Sender.ID
-		} else {
-			return ctx.Reply("ID required.")
-		}
-	} else {
-		if id, parse_err = strconv.ParseInt(ctx.Args()[0], 0, 64); parse_err != nil {
-			return ctx.Reply("Invalid ID.")
-		}
+	args, args_err := ParseArgs(ctx, CommandSpecs[CMD_REG])
 
-		if len(ctx.Args()) >= 2 {
-			description = strings.Join(ctx.Args()[1:], " ")
-		}
+	if args_err != nil {
+		return ReplyArgsErr(ctx, CommandSpecs[CMD_REG], args_err)
 	}
 
+	id, sender = args.Target.ID, args.Target.User
+	description = args.Str("description")
+
 	_, data_err = Data.FindByID(id)
 
 	if data_err == nil {
 		return ctx.Reply("User is already registered.")
 	}
 
-	user = User{
-		ID:          primitive.NewObjectID(),
-		TelegramID:  id,
-		Names:       make([]string, 0, 1),
-		Usernames:   make([]string, 0, 1),
-		AliasIDs:    make([]int64, 0),
-		Description: description,
-		Records:     map[string][]Record{},
+	if sender != nil && sender.IsBot {
+		return ctx.Reply("The user is a bot; can't register bots.")
 	}
 
-	if sender != nil {
-		name, username, isBot := sender.FirstName+" "+sender.LastName, sender.Username, sender.IsBot
-
-		if isBot {
-			return ctx.Reply("The user is a bot; can't register bots.")
-		}
-
-		user.Names = append(user.Names, name)
-		user.Usernames = append(user.Usernames, username)
-	}
+	user = NewUser(id, sender, description, ctx.Sender().ID)
 
 	data_err = Data.Add(user)
 
-	if data_err != nil {
+	// Someone else has registered the user since the check above.
+	if errors.Is(data_err, ErrDuplicate) {
+		return ctx.Reply("User is already registered.")
+	} else if data_err != nil {
 		log.Printf("error registering user: %v", data_err)
 		return ctx.Reply("Could not perform this operation.")
 	}
 
+	AuditLog(ctx.Sender().ID, id, AUDIT_REG, nil, &user)
+
 	// logging
 
 	name := ctx.Message().Sender.FirstName + " " + ctx.Message().Sender.LastName
@@ -633,24 +511,18 @@ func RegHandler(ctx tele.Context) error {
 	return ctx.Reply("User registered.")
 }
 
+// Syntax:
+//
+//	- /unreg <ID/@username/mention/reply-to-message>
 func UnregHandler(ctx tele.Context) error {
-	var (
-		id int64
-
-		parse_err error
-	)
+	args, args_err := ParseArgs(ctx, CommandSpecs[CMD_UNREG])
 
-	if len(ctx.Args()) == 0 {
-		if ctx.Message().ReplyTo == nil || ctx.Message().ReplyTo.Sender == nil {
-			return ctx.Reply("ID required.")
-		}
-		id = ctx.Message().ReplyTo.Sender.ID
-	} else {
-		if id, parse_err = strconv.ParseInt(ctx.Args()[0], 0, 64); parse_err != nil {
-			return ctx.Reply("Invalid ID.")
-		}
+	if args_err != nil {
+		return ReplyArgsErr(ctx, CommandSpecs[CMD_UNREG], args_err)
 	}
 
+	id := args.Target.ID
+
 	// You can't remove the owner
 
 	if id == Config.OwnerTelegramID {
@@ -672,10 +544,21 @@ func UnregHandler(ctx tele.Context) error {
 		return ctx.Reply("You need to be the owner, to remove an operator.")
 	}
 
+	// The user is kept in the trash, until restored or expired
+
+	trash_id, trash_err := TrashUser(ctx.Sender().ID, u)
+
+	if trash_err != nil {
+		log.Printf("error moving user to the trash: %v\n", trash_err)
+		return ctx.Reply(MSG_COULD_NOT_PERFORM)
+	}
+
 	if c, e := Data.RemoveByID(id); e != nil || c == 0 {
 		log.Printf(ERR_FMT_DELETE+"\n", id)
+		Data.RemoveTrash(trash_id)
 		return ctx.Reply(MSG_ID_NOT_FOUND)
 	} else {
+		AuditLog(ctx.Sender().ID, id, AUDIT_UNREG, &u, nil)
 
 		// logging
 
@@ -703,6 +586,10 @@ func QueryHandler(ctx tele.Context) error {
 		data_err error
 	)
 
+	if strings.HasPrefix(strings.ToLower(ctx.Query().Text), NOTES_QUERY_PREFIX) {
+		return NotesQueryHandler(ctx, ctx.Query().Text[len(NOTES_QUERY_PREFIX):])
+	}
+
 	// Determine whether the strings is an ID, a name, or a username.
 
 	str, id, is_int = Parse(ctx.Query().Text)
@@ -731,12 +618,18 @@ func QueryHandler(ctx tele.Context) error {
 			users = append(users, user_names...)
 		}
 
-	} else {
+	} else if utf8.RuneCountInString(TrimUsername(strings.TrimSpace(str))) >= INLINE_MIN_QUERY {
+		// Inline queries come with every keystroke; the shortest ones match too much to be worth searching.
+		var results []SearchResult
+
 		if strings.HasPrefix(str, "@") {
-			str = strings.TrimLeft(str, "@")
-			users, data_err = Data.Filter(bson.D{{Key: "usernames", Value: str}})
+			results, data_err = SearchUsers(str, "username")
 		} else {
-			users, data_err = Data.Filter(bson.D{{Key: "names", Value: str}})
+			results, data_err = SearchUsers(str, "")
+		}
+
+		for _, r := range results {
+			users = append(users, r.User)
 		}
 	}
 
@@ -783,7 +676,7 @@ func UnregHelpBtnHandler(ctx tele.Context) error {
 //
 // Syntax:
 //
-//	- /set <ID/reply-to-message> <description>
+//	- /set <ID/@username/mention/reply-to-message> <description>
 func SetHandler(c tele.Context) error {
 	var (
 		id   int64
@@ -791,40 +684,17 @@ func SetHandler(c tele.Context) error {
 
 		desc string
 
-		parse_err error
-		data_err  error
+		data_err error
 	)
 
-	switch len(c.Args()) {
-	case 0:
-		return c.Reply(MSG_INSUFFICIENT_ARGS)
-	case 1:
-		// /set <reply-to-message> <description>
-		if c.Message().ReplyTo != nil && c.Message().ReplyTo.Sender != nil {
-			id = c.Message().ReplyTo.Sender.ID
-		} else {
-			return c.Reply(MSG_INSUFFICIENT_ARGS)
-		}
-
-		desc = c.Args()[0]
-	default:
-		// /set <ID> <description>
-		id, parse_err = strconv.ParseInt(c.Args()[0], 0, 64)
-
-		ind := 1
-
-		if parse_err != nil {
-			if c.Message().ReplyTo != nil && c.Message().ReplyTo.Sender != nil {
-				id = c.Message().ReplyTo.Sender.ID
-				ind = 0
-			} else {
-				return c.Reply("ID invalid or missing.")
-			}
-		}
+	args, args_err := ParseArgs(c, CommandSpecs[CMD_SET])
 
-		desc = strings.Join(c.Args()[ind:], " ")
+	if args_err != nil {
+		return ReplyArgsErr(c, CommandSpecs[CMD_SET], args_err)
 	}
 
+	id, desc = args.Target.ID, args.Str("description")
+
 	user, data_err = Data.FindByID(id)
 
 	if data_err != nil {
@@ -836,15 +706,20 @@ func SetHandler(c tele.Context) error {
 		return c.Reply("You can't change owner's data.")
 	}
 
-	user.Description = desc
-
-	err := Data.ReplaceByID(id, user)
+	before, user, err := UpdateUser(id, func(u *User) error {
+		u.Description = desc
+		return nil
+	})
 
-	if err != nil {
+	if errors.Is(err, ErrConflict) {
+		return c.Reply(MSG_CONFLICT)
+	} else if err != nil {
 		log.Printf(ERR_FMT_UPDATE+"\n", err)
 		return c.Reply(MSG_COULD_NOT_PERFORM)
 	}
 
+	AuditLog(c.Sender().ID, id, AUDIT_SET, &before, &user)
+
 	// logging
 
 	name := c.Message().Sender.FirstName + " " + c.Message().Sender.LastName
@@ -863,8 +738,8 @@ func SetHandler(c tele.Context) error {
 
 // Syntax:
 //
-//	- /perm <ID/reply-to-message>
-//	- /perm <ID/reply-to-message> set <permission-level>
+//	- /perm <ID/@username/mention/reply-to-message>
+//	- /perm <ID/@username/mention/reply-to-message> set <permission-level>
 func PermHandler(c tele.Context) error {
 	var (
 		id   int64
@@ -876,9 +751,7 @@ func PermHandler(c tele.Context) error {
 		set      = false
 		new_perm int
 
-		perm_parse_err error
-		parse_err      error
-		data_err       error
+		data_err error
 	)
 
 	if c.Message().Sender.ID == Config.OwnerTelegramID {
@@ -887,53 +760,13 @@ func PermHandler(c tele.Context) error {
 
 	// Acquire ID
 
-	switch len(c.Args()) {
-	case 0:
-		// /perm <reply-to-message>
-		if c.Message().ReplyTo != nil && c.Message().ReplyTo.Sender != nil {
-			id = c.Message().ReplyTo.Sender.ID
-		} else {
-			return c.Reply(MSG_ID_REQUIRED)
-		}
+	args, args_err := ParseArgs(c, CommandSpecs[CMD_PERM])
 
-	case 1:
-		// /perm <ID>
-		id, parse_err = strconv.ParseInt(c.Args()[0], 0, 64)
-
-	case 2:
-		// /perm <reply-to-message> set <permission-level>
-		if c.Message().ReplyTo != nil && c.Message().ReplyTo.Sender != nil {
-			id = c.Message().ReplyTo.Sender.ID
-		} else {
-			return c.Reply(MSG_ID_REQUIRED)
-		}
-
-		if c.Args()[0] == "set" {
-			set = true
-			new_perm, perm_parse_err = strconv.Atoi(c.Args()[1])
-		} else {
-			return c.Reply("Invalid operation: \"" + c.Args()[0] + "\".")
-		}
-
-	default:
-		// /perm <ID> set <permission-level>
-		id, parse_err = strconv.ParseInt(c.Args()[0], 0, 64)
-
-		if c.Args()[1] == "set" {
-			set = true
-			new_perm, perm_parse_err = strconv.Atoi(c.Args()[2])
-		} else {
-			return c.Reply("Invalid operation: \"" + c.Args()[1] + "\".")
-		}
+	if args_err != nil {
+		return ReplyArgsErr(c, CommandSpecs[CMD_PERM], args_err)
 	}
 
-	if parse_err != nil {
-		return c.Reply(MSG_INVALID_ID)
-	}
-
-	if perm_parse_err != nil {
-		return c.Reply("Invalid permission level.")
-	}
+	id, set, new_perm = args.Target.ID, args.Has("level"), args.Int("level")
 
 	u, data_err = Data.FindByID(id)
 
@@ -962,15 +795,20 @@ func PermHandler(c tele.Context) error {
 				return c.Reply("You must be the owner to grant others <b>operator</b> access.")
 			}
 		} else {
-			u.Permission = new_perm
-
-			err := Data.ReplaceByID(id, u)
+			before, after, err := UpdateUser(id, func(u *User) error {
+				u.Permission = new_perm
+				return nil
+			})
 
-			if err != nil {
+			if errors.Is(err, ErrConflict) {
+				return c.Reply(MSG_CONFLICT)
+			} else if err != nil {
 				log.Printf(ERR_FMT_UPDATE+"\n", err)
 				return c.Reply(MSG_COULD_NOT_PERFORM)
 			}
 
+			AuditLog(c.Sender().ID, id, AUDIT_PERM, &before, &after)
+
 			// logging
 
 			name := c.Message().Sender.FirstName + " " + c.Message().Sender.LastName
@@ -1070,21 +908,23 @@ func SetPermBtnHandler(c tele.Context) error {
 		return c.Edit("Error: unknown callback data value: \"" + c.Callback().Data + "\".")
 	}
 
-	user, data_err = Data.FindByID(id)
+	before, user, data_err := UpdateUser(id, func(u *User) error {
+		u.Permission = perm
+		return nil
+	})
 
-	if data_err != nil {
+	if errors.Is(data_err, ErrNotFound) {
 		log.Printf(ERR_FMT_QUERY+"\n", data_err)
 		return c.Edit(MSG_ID_NOT_FOUND)
 	}
 
-	user.Permission = perm
-
-	err := Data.ReplaceByID(id, user)
-
-	if err != nil {
-		log.Printf("error updating user permission: %v\n", err)
+	if errors.Is(data_err, ErrConflict) {
+		return c.Edit(MSG_CONFLICT)
+	} else if data_err != nil {
+		log.Printf("error updating user permission: %v\n", data_err)
 		return c.Edit(MSG_COULD_NOT_PERFORM)
 	} else {
+		AuditLog(c.Sender().ID, id, AUDIT_PERM, &before, &user)
 
 		// logging
 
@@ -1125,8 +965,27 @@ func DeleteEntryBtnHandler(c tele.Context) error {
 		return c.Edit("You can't remove the owner's registary.")
 	}
 
+	before, find_err := Data.FindByID(id)
+
+	if find_err != nil {
+		return c.Edit(MSG_ID_NOT_FOUND)
+	}
+
+	// The user is kept in the trash, until restored or expired
+
+	trash_id, trash_err := TrashUser(c.Sender().ID, before)
+
+	if trash_err != nil {
+		log.Printf("error moving user to the trash: %v\n", trash_err)
+		return c.Edit("Could not perform this action: Database error.")
+	}
+
 	count, err = Data.RemoveByID(id)
 
+	if err != nil || count == 0 {
+		Data.RemoveTrash(trash_id)
+	}
+
 	if err != nil {
 		log.Printf(ERR_FMT_DELETE+"\n", err)
 		return c.Edit("Could not perform this action: Database error.")
@@ -1135,6 +994,7 @@ func DeleteEntryBtnHandler(c tele.Context) error {
 	if count == 0 {
 		return c.Edit(MSG_ID_NOT_FOUND)
 	} else {
+		AuditLog(c.Sender().ID, id, AUDIT_UNREG, &before, nil)
 
 		// logging
 
@@ -1164,7 +1024,7 @@ func DelrecHelpBtnHandler(c tele.Context) error {
 
 // Syntax:
 //
-//	/delrec <ID/reply-to-message> <category> [note-index] ..
+//	/delrec <ID/@username/mention/reply-to-message> [category] [note-index]
 func DelrecHandler(c tele.Context) error {
 	var (
 		id   int64
@@ -1183,63 +1043,21 @@ func DelrecHandler(c tele.Context) error {
 		rec_to_delete        Record
 		rec_to_delete_exists = false
 
-		parse_err  error
-		parse_err2 error
-		data_err   error
+		data_err error
 	)
 
-	// Acquire ID & category & nore-index
+	// Acquire ID & category & note-index
 
-	switch len(c.Args()) {
-	// /delrec <reply-to-message>	-> delete all records of a user
-	case 0:
-		if c.Message().ReplyTo != nil && c.Message().ReplyTo.Sender != nil {
-			id = c.Message().ReplyTo.Sender.ID
-			goto SkipValidation
-		} else {
-			return c.Reply(MSG_ID_REQUIRED)
-		}
-	// /delrec <ID> 							-> delete all records from a user
-	// /delrec <reply-to-message> <category>	-> delete all records of a category from a user
-	case 1:
-		if c.Message().ReplyTo != nil && c.Message().ReplyTo.Sender != nil {
-			id = c.Message().ReplyTo.Sender.ID
-			category = c.Args()[0]
-		} else if id, parse_err = strconv.ParseInt(c.Args()[0], 0, 64); parse_err != nil {
-			return c.Reply(MSG_ID_REQUIRED)
-		}
+	args, args_err := ParseArgs(c, CommandSpecs[CMD_DELREC])
 
-	// /delrec <ID> <category>								-> delete all records from a user
-	// /delrec <reply-to-message> <category> [note-index]	-> delete a single record from a category from a user
-	case 2:
-		if id, parse_err = strconv.ParseInt(c.Args()[0], 0, 64); parse_err == nil {
-			category = c.Args()[1]
-		} else if c.Message().ReplyTo != nil && c.Message().ReplyTo.Sender != nil {
-			id = c.Message().ReplyTo.Sender.ID
-			category = c.Args()[0]
-			index = c.Args()[1]
-		}
-
-	// /delrec <ID> <category> [note-index]	-> delete a single record from a category from a user
-	default:
-		if id, parse_err = strconv.ParseInt(c.Args()[0], 0, 64); parse_err == nil {
-			category = c.Args()[1]
-			index = c.Args()[2]
-		} else if c.Message().ReplyTo != nil && c.Message().ReplyTo.Sender != nil {
-			id = c.Message().ReplyTo.Sender.ID
-			category = c.Args()[0]
-			index = c.Args()[1]
-		}
+	if args_err != nil {
+		return ReplyArgsErr(c, CommandSpecs[CMD_DELREC], args_err)
 	}
 
-	// Validate index
+	id, category, index = args.Target.ID, args.Str("category"), args.Str("index")
 
-	if index_int, parse_err2 = strconv.Atoi(index); index != "" && parse_err2 != nil {
-		return c.Reply("Invalid index value.")
-	} else {
-		// Turn the index into zero-based
-		index_int--
-	}
+	// Turn the index into zero-based
+	index_int = args.Int("index") - 1
 
 	// Check if user exists
 
@@ -1254,6 +1072,8 @@ func DelrecHandler(c tele.Context) error {
 		return c.Reply("You can't modify owner's records.")
 	}
 
+	before, _ := cloneUser(user)
+
 	// Validate category if given
 
 	if category != "" {
@@ -1272,44 +1092,17 @@ func DelrecHandler(c tele.Context) error {
 
 	// Start deleting
 
-SkipValidation:
-
-	switch len(c.Args()) {
-	case 0:
+	switch {
+	case category == "":
 		all_recs_to_delete_exists = true
 		user_display = DisplayUser(&user)
 
 		user.Records = map[string][]Record{}
-	case 1:
-		if category != "" {
-			cat_to_delete_exists = true
-			cat_to_delete = user.Records[category]
-
-			delete(user.Records, category)
-		} else {
-			all_recs_to_delete_exists = true
-			user_display = DisplayUser(&user)
-
-			user.Records = map[string][]Record{}
-		}
-	case 2:
-		if index != "" {
-			rec_to_delete_exists = true
-			rec_to_delete = user.Records[category][index_int]
-
-			new_records := make([]Record, 0, len(user.Records[category])-1)
-			for i, r := range user.Records[category] {
-				if i != index_int {
-					new_records = append(new_records, r)
-				}
-			}
-			user.Records[category] = new_records
-		} else {
-			cat_to_delete_exists = true
-			cat_to_delete = user.Records[category]
+	case index == "":
+		cat_to_delete_exists = true
+		cat_to_delete = user.Records[category]
 
-			delete(user.Records, category)
-		}
+		delete(user.Records, category)
 	default:
 		rec_to_delete_exists = true
 		rec_to_delete = user.Records[category][index_int]
@@ -1323,14 +1116,48 @@ SkipValidation:
 		user.Records[category] = new_records
 	}
 
+	// Keep the deleted records in the trash, until restored or expired
+
+	deleted := before.Records
+
+	if rec_to_delete_exists {
+		deleted = map[string][]Record{category: {rec_to_delete}}
+	} else if cat_to_delete_exists {
+		deleted = map[string][]Record{category: cat_to_delete}
+	}
+
+	var (
+		trash_id  primitive.ObjectID
+		trash_err error
+	)
+
+	if CountRecords(deleted) > 0 {
+		if trash_id, trash_err = TrashRecords(c.Sender().ID, id, deleted); trash_err != nil {
+			log.Printf("error moving records to the trash: %v\n", trash_err)
+			return c.Reply(MSG_COULD_NOT_PERFORM)
+		}
+	}
+
 	// Send to database
 
+	// Indexes refer to what the sender has seen, so a conflict isn't retried.
+
 	err := Data.ReplaceByID(id, user)
 	if err != nil {
+		if !trash_id.IsZero() {
+			Data.RemoveTrash(trash_id)
+		}
+
+		if errors.Is(err, ErrConflict) {
+			return c.Reply(MSG_CONFLICT)
+		}
+
 		log.Printf(ERR_FMT_UPDATE+"\n", err)
 		return c.Reply(MSG_COULD_NOT_PERFORM)
 	}
 
+	AuditLog(c.Sender().ID, id, AUDIT_DELREC, &before, &user)
+
 	// logging
 
 	name := c.Message().Sender.FirstName + " " + c.Message().Sender.LastName
@@ -0,0 +1,641 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	tele "github.com/Henry96Markle/telebot"
+)
+
+var (
+	testTarget = &tele.User{ID: testTargetID, FirstName: "Miles", LastName: "Edgeworth", Username: "miles"}
+	testBot    = &tele.User{ID: 3000, FirstName: "Spam", IsBot: true, Username: "spam_bot"}
+)
+
+// A registered user with two categories of records.
+func recordedTarget() User {
+	u := newTestUser(testTargetID, 0)
+	u.Names = []string{"Miles Edgeworth"}
+	u.Usernames = []string{"miles"}
+	u.Records = map[string][]Record{
+		"bans": {
+			{ChatID: testGroupID, Notes: []string{"first"}, Date: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)},
+			{ChatID: testGroupID, Notes: []string{"second"}, Date: time.Date(2022, 2, 1, 0, 0, 0, 0, time.UTC)},
+		},
+		"warns": {
+			{ChatID: testGroupID, Notes: []string{"third"}, Date: time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)},
+		},
+	}
+
+	return u
+}
+
+// A store that pretends not to have a user, as if it was added right after being looked up.
+type unseenStore struct {
+	*MemoryDatabase
+	unseen int64
+}
+
+func (d unseenStore) FindByID(id int64) (User, error) {
+	if id == d.unseen {
+		return User{}, ErrNotFound
+	}
+
+	return d.MemoryDatabase.FindByID(id)
+}
+
+func TestRegHandler(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		replyTo *tele.User
+		seeded  bool
+		reply   string
+		names   []string
+		desc    string
+		racing  bool
+	}{
+		{name: "by ID", text: "/reg 2000", reply: "User registered."},
+		{name: "by ID with description", text: "/reg 2000 My brother-in-law", reply: "User registered.", desc: "My brother-in-law"},
+		{name: "by reply", text: "/reg", replyTo: testTarget, reply: "User registered.", names: []string{"Miles Edgeworth"}},
+		{name: "no ID", text: "/reg", reply: MSG_ID_REQUIRED},
+		{name: "invalid ID", text: "/reg abc", reply: MSG_ID_REQUIRED},
+		{name: "already registered", text: "/reg 2000", seeded: true, reply: "User is already registered."},
+		{name: "bot", text: "/reg", replyTo: testBot, reply: "The user is a bot; can't register bots."},
+		{name: "registered in the meantime", text: "/reg 2000", seeded: true, racing: true, reply: "User is already registered."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.seeded {
+				setupTest(t, recordedTarget())
+			} else {
+				setupTest(t)
+			}
+
+			// The user isn't found by the check, but is there by the time it's added.
+			if tt.racing {
+				Data = unseenStore{Data.(*MemoryDatabase), testTargetID}
+			}
+
+			ctx := newCommand(testWriterID, testGroupID, tt.text, tt.replyTo)
+
+			if err := RegHandler(ctx); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if ctx.last() != tt.reply {
+				t.Fatalf("expected reply %q, got %q", tt.reply, ctx.last())
+			}
+
+			if tt.reply != "User registered." {
+				return
+			}
+
+			u := mustFind(t, testTargetID)
+
+			if u.Description != tt.desc {
+				t.Errorf("expected description %q, got %q", tt.desc, u.Description)
+			}
+
+			if tt.names != nil && (len(u.Names) != len(tt.names) || u.Names[0] != tt.names[0]) {
+				t.Errorf("expected names %v, got %v", tt.names, u.Names)
+			}
+
+			for _, e := range u.History {
+				if e.Source != SOURCE_REGISTRATION || e.AddedBy != testWriterID {
+					t.Errorf("unexpected history entry %v", e)
+				}
+			}
+		})
+	}
+}
+
+func TestUnregHandler(t *testing.T) {
+	tests := []struct {
+		name    string
+		sender  int64
+		text    string
+		replyTo *tele.User
+		reply   string
+		removed int64
+	}{
+		{name: "by ID", sender: testWriterID, text: "/unreg 2000", reply: "User removed.", removed: testTargetID},
+		{name: "by reply", sender: testWriterID, text: "/unreg", replyTo: testTarget, reply: "User removed.", removed: testTargetID},
+		{name: "no ID", sender: testWriterID, text: "/unreg", reply: MSG_ID_REQUIRED},
+		{name: "invalid ID", sender: testWriterID, text: "/unreg abc", reply: MSG_ID_REQUIRED},
+		{name: "not found", sender: testWriterID, text: "/unreg 4000", reply: MSG_ID_NOT_FOUND},
+		{name: "owner", sender: testOperatorID, text: "/unreg 1000", reply: "You can't remove the owner's ID."},
+		{name: "operator by non-owner", sender: testOperatorID, text: "/unreg 1001", reply: "You need to be the owner, to remove an operator."},
+		{name: "operator by owner", sender: testOwnerID, text: "/unreg 1001", reply: "User removed.", removed: testOperatorID},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTest(t, recordedTarget())
+
+			ctx := newCommand(tt.sender, testGroupID, tt.text, tt.replyTo)
+
+			if err := UnregHandler(ctx); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if ctx.last() != tt.reply {
+				t.Fatalf("expected reply %q, got %q", tt.reply, ctx.last())
+			}
+
+			if tt.removed != 0 {
+				if _, err := Data.FindByID(tt.removed); err == nil {
+					t.Errorf("expected ID %d to be removed", tt.removed)
+				}
+			}
+		})
+	}
+}
+
+func TestRecordHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		sender   int64
+		text     string
+		replyTo  *tele.User
+		reply    string
+		category string
+		notes    []string
+	}{
+		{name: "with notes", sender: testWriterID, text: "/record 2000 kicks spam links; flood", reply: "Recorded.", category: "kicks", notes: []string{"spam links", "flood"}},
+		{name: "existing category", sender: testWriterID, text: "/record 2000 bans again", reply: "Recorded.", category: "bans", notes: []string{"again"}},
+		{name: "by reply", sender: testWriterID, text: "/record kicks", replyTo: testTarget, reply: "Recorded."},
+		{name: "no arguments", sender: testWriterID, text: "/record", reply: MSG_ID_REQUIRED},
+		{name: "reply required", sender: testWriterID, text: "/record kicks", reply: MSG_ID_REQUIRED},
+		{name: "invalid ID", sender: testWriterID, text: "/record abc kicks", reply: MSG_ID_REQUIRED},
+		{name: "not found", sender: testWriterID, text: "/record 4000 kicks", reply: MSG_ID_NOT_FOUND},
+		{name: "owner by non-owner", sender: testOperatorID, text: "/record 1000 kicks", reply: "You can't record an owner."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTest(t, recordedTarget())
+
+			ctx := newCommand(tt.sender, testGroupID, tt.text, tt.replyTo)
+
+			if err := RecordHandler(ctx); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if ctx.last() != tt.reply {
+				t.Fatalf("expected reply %q, got %q", tt.reply, ctx.last())
+			}
+
+			if tt.category == "" {
+				return
+			}
+
+			recs := mustFind(t, testTargetID).Records[tt.category]
+
+			if len(recs) == 0 {
+				t.Fatalf("expected a record under %q", tt.category)
+			}
+
+			last := recs[len(recs)-1]
+
+			if strings.Join(last.Notes, "|") != strings.Join(tt.notes, "|") {
+				t.Errorf("expected notes %v, got %v", tt.notes, last.Notes)
+			}
+
+			if last.ChatID != testGroupID {
+				t.Errorf("expected chat ID %d, got %d", testGroupID, last.ChatID)
+			}
+
+			if last.Author != tt.sender {
+				t.Errorf("expected author %d, got %d", tt.sender, last.Author)
+			}
+		})
+	}
+}
+
+func TestRecordProvenance(t *testing.T) {
+	setupTest(t, recordedTarget())
+
+	ctx := newCommand(testWriterID, -1001234567890, "/record 2000 kicks flood", testTarget)
+	ctx.message.ID = 10
+	ctx.message.ReplyTo.ID = 9
+
+	if err := RecordHandler(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	r := mustFind(t, testTargetID).Records["kicks"][0]
+
+	if r.Author != testWriterID || r.MessageID != 10 || r.ReplyTo != 9 || r.Link != "https://t.me/c/1234567890/9" {
+		t.Errorf("unexpected provenance %+v", r)
+	}
+
+	if s := RecordToStr(r, ""); !strings.Contains(s, "By: <code>1002</code>") || !strings.Contains(s, `<a href="https://t.me/c/1234567890/9">`) {
+		t.Errorf("expected the provenance to be displayed, got %q", s)
+	}
+}
+
+func TestMessageLink(t *testing.T) {
+	tests := []struct {
+		chat *tele.Chat
+		id   int
+		want string
+	}{
+		{&tele.Chat{ID: -1001234567890}, 9, "https://t.me/c/1234567890/9"},
+		{&tele.Chat{ID: -1001234567890, Username: "courtroom"}, 9, "https://t.me/courtroom/9"},
+		{&tele.Chat{ID: testGroupID}, 9, ""},
+		{&tele.Chat{ID: testWriterID}, 9, ""},
+		{&tele.Chat{ID: -1001234567890}, 0, ""},
+		{nil, 9, ""},
+	}
+
+	for _, tt := range tests {
+		if got := MessageLink(tt.chat, tt.id); got != tt.want {
+			t.Errorf("MessageLink(%v, %d) = %q, want %q", tt.chat, tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestDelrecHandler(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		replyTo *tele.User
+		reply   string
+		// Expected number of records left in each category; a missing category must be gone.
+		left map[string]int
+	}{
+		{name: "all by ID", text: "/delrec 2000", reply: "All records removed.", left: map[string]int{}},
+		{name: "all by reply", text: "/delrec", replyTo: testTarget, reply: "All records removed.", left: map[string]int{}},
+		{name: "category by ID", text: "/delrec 2000 bans", reply: "\"bans\" category removed.", left: map[string]int{"warns": 1}},
+		{name: "category by reply", text: "/delrec bans", replyTo: testTarget, reply: "\"bans\" category removed.", left: map[string]int{"warns": 1}},
+		{name: "record by ID", text: "/delrec 2000 bans 1", reply: "Record removed.", left: map[string]int{"bans": 1, "warns": 1}},
+		{name: "record by reply", text: "/delrec bans 2", replyTo: testTarget, reply: "Record removed.", left: map[string]int{"bans": 1, "warns": 1}},
+		{name: "index out of bounds", text: "/delrec 2000 bans 3", reply: "Note index is out of bounds."},
+		{name: "zero index", text: "/delrec 2000 bans 0", reply: "Note index is out of bounds."},
+		{name: "invalid index", text: "/delrec 2000 bans x", reply: "Invalid index: \"x\" isn't a number.\n\nUsage: /delrec <user> [<category> [<index>]]"},
+		{name: "unknown category", text: "/delrec 2000 kicks", reply: "Category \"kicks\" does not exist."},
+		{name: "no ID", text: "/delrec", reply: MSG_ID_REQUIRED},
+		{name: "not found", text: "/delrec 4000", reply: MSG_ID_NOT_FOUND},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTest(t, recordedTarget())
+
+			ctx := newCommand(testWriterID, testGroupID, tt.text, tt.replyTo)
+
+			if err := DelrecHandler(ctx); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if ctx.last() != tt.reply {
+				t.Fatalf("expected reply %q, got %q", tt.reply, ctx.last())
+			}
+
+			if tt.left == nil {
+				return
+			}
+
+			u := mustFind(t, testTargetID)
+
+			if len(u.Records) != len(tt.left) {
+				t.Errorf("expected %d categories, got %d", len(tt.left), len(u.Records))
+			}
+
+			for category, count := range tt.left {
+				if len(u.Records[category]) != count {
+					t.Errorf("expected %d records in %q, got %d", count, category, len(u.Records[category]))
+				}
+			}
+		})
+	}
+
+	t.Run("removes the right record", func(t *testing.T) {
+		setupTest(t, recordedTarget())
+
+		DelrecHandler(newCommand(testWriterID, testGroupID, "/delrec 2000 bans 1", nil))
+
+		bans := mustFind(t, testTargetID).Records["bans"]
+
+		if len(bans) != 1 || bans[0].Notes[0] != "second" {
+			t.Errorf("expected only the second record to be left, got %v", bans)
+		}
+	})
+}
+
+func TestPermHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		sender   int64
+		chat     int64
+		text     string
+		replyTo  *tele.User
+		reply    string
+		keyboard bool
+		perm     int
+	}{
+		{name: "show by ID", sender: testOperatorID, chat: testGroupID, text: "/perm 2000", reply: "This user has <b>no</b> access."},
+		{name: "show by reply", sender: testOperatorID, chat: testGroupID, text: "/perm", replyTo: testTarget, reply: "This user has <b>no</b> access."},
+		{name: "show in PM", sender: testOperatorID, chat: testOperatorID, text: "/perm 2000", reply: "This user has <b>no</b> access.\n\nYou can edit the user's permission:", keyboard: true},
+		{name: "set by ID", sender: testOperatorID, chat: testGroupID, text: "/perm 2000 set 2", reply: "Permission set.", perm: 2},
+		{name: "set by reply", sender: testOperatorID, chat: testGroupID, text: "/perm set 1", replyTo: testTarget, reply: "Permission set.", perm: 1},
+		{name: "operator by operator", sender: testOperatorID, chat: testGroupID, text: "/perm 2000 set 3", reply: "You must be the owner to grant others <b>operator</b> access."},
+		{name: "operator by owner", sender: testOwnerID, chat: testGroupID, text: "/perm 2000 set 3", reply: "You're about to grant this user <b>operator</b> access. Are you sure?", keyboard: true},
+		{name: "owner access", sender: testOwnerID, chat: testGroupID, text: "/perm 2000 set 4", reply: "You can't grant <b>owner</b> access to other."},
+		{name: "owner's own", sender: testOwnerID, chat: testGroupID, text: "/perm 1000 set 1", reply: "You're the owner; you can't change your own permission level."},
+		{name: "set without the operation", sender: testOperatorID, chat: testGroupID, text: "/perm 2000 2", reply: "Permission set.", perm: 2},
+		{name: "unknown operation", sender: testOperatorID, chat: testGroupID, text: "/perm 2000 get 1", reply: "Unknown operation: \"get\"; expected set.\n\nUsage: /perm <user> [[set] <level>]"},
+		{name: "invalid ID", sender: testOperatorID, chat: testGroupID, text: "/perm abc", reply: MSG_ID_REQUIRED},
+		{name: "invalid level", sender: testOperatorID, chat: testGroupID, text: "/perm 2000 set x", reply: "Invalid level: \"x\" isn't a number.\n\nUsage: /perm <user> [[set] <level>]"},
+		{name: "no ID", sender: testOperatorID, chat: testGroupID, text: "/perm", reply: MSG_ID_REQUIRED},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTest(t, recordedTarget())
+
+			ctx := newCommand(tt.sender, tt.chat, tt.text, tt.replyTo)
+
+			if err := PermHandler(ctx); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if ctx.last() != tt.reply {
+				t.Fatalf("expected reply %q, got %q", tt.reply, ctx.last())
+			}
+
+			if (ctx.lastMarkup() != nil) != tt.keyboard {
+				t.Errorf("expected keyboard: %t", tt.keyboard)
+			}
+
+			if u := mustFind(t, testTargetID); u.Permission != tt.perm {
+				t.Errorf("expected permission %d, got %d", tt.perm, u.Permission)
+			}
+		})
+	}
+}
+
+func TestAliasHandler(t *testing.T) {
+	const aliasUsage = "/alias <user> <add/remove> <name/id/username> <value1; value2; ..>"
+
+	tests := []struct {
+		name      string
+		text      string
+		replyTo   *tele.User
+		reply     string
+		names     []string
+		usernames []string
+		aliases   []int64
+	}{
+		{name: "add names", text: "/alias 2000 add name Phoenix Wright;Larry", reply: "Aliases added.", names: []string{"Miles Edgeworth", "Phoenix Wright", "Larry"}},
+		{name: "add names with spaces", text: "/alias 2000 add name Phoenix ;  Larry ;", reply: "Aliases added.", names: []string{"Miles Edgeworth", "Phoenix", "Larry"}},
+		{name: "add duplicate name", text: "/alias 2000 add name Miles Edgeworth", reply: "Alias added.", names: []string{"Miles Edgeworth"}},
+		{name: "remove name", text: "/alias 2000 remove name Miles Edgeworth", reply: "Alias removed.", names: []string{}},
+		{name: "add username", text: "/alias 2000 add username @edgeworth", reply: "Alias added.", usernames: []string{"miles", "edgeworth"}},
+		{name: "add IDs", text: "/alias 2000 add id 3001;3002;2000", reply: "Aliases added.", aliases: []int64{3001, 3002}},
+		{name: "by reply", text: "/alias add name Phoenix", replyTo: testTarget, reply: "Alias added.", names: []string{"Miles Edgeworth", "Phoenix"}},
+		{name: "insufficient", text: "/alias 2000 add", reply: "Missing <name/id/username>.\n\nUsage: " + aliasUsage},
+		{name: "reply required", text: "/alias add name Phoenix", reply: MSG_ID_REQUIRED},
+		{name: "unknown operation", text: "/alias 2000 put name Phoenix", reply: "Unknown operation: \"put\"; expected add or remove.\n\nUsage: " + aliasUsage},
+		{name: "unknown mode", text: "/alias 2000 add nick Phoenix", reply: "Unknown kind: \"nick\"; expected name, id or username.\n\nUsage: " + aliasUsage},
+		{name: "not found", text: "/alias 4000 add name Phoenix", reply: "ID not found."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTest(t, recordedTarget())
+
+			ctx := newCommand(testWriterID, testGroupID, tt.text, tt.replyTo)
+
+			if err := AliasHandler(ctx); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if ctx.last() != tt.reply {
+				t.Fatalf("expected reply %q, got %q", tt.reply, ctx.last())
+			}
+
+			u := mustFind(t, testTargetID)
+
+			if tt.names != nil && strings.Join(u.Names, "|") != strings.Join(tt.names, "|") {
+				t.Errorf("expected names %v, got %v", tt.names, u.Names)
+			}
+
+			if tt.usernames != nil && strings.Join(u.Usernames, "|") != strings.Join(tt.usernames, "|") {
+				t.Errorf("expected usernames %v, got %v", tt.usernames, u.Usernames)
+			}
+
+			if tt.aliases != nil && strings.Join(IntToStrSlice(u.AliasIDs...), "|") != strings.Join(IntToStrSlice(tt.aliases...), "|") {
+				t.Errorf("expected alias IDs %v, got %v", tt.aliases, u.AliasIDs)
+			}
+		})
+	}
+}
+
+func TestSetHandler(t *testing.T) {
+	tests := []struct {
+		name    string
+		sender  int64
+		text    string
+		replyTo *tele.User
+		reply   string
+		desc    string
+	}{
+		{name: "by ID", sender: testWriterID, text: "/set 2000 My brother-in-law.", reply: "Description set.", desc: "My brother-in-law."},
+		{name: "by reply, one word", sender: testWriterID, text: "/set Prosecutor", replyTo: testTarget, reply: "Description set.", desc: "Prosecutor"},
+		{name: "by reply, many words", sender: testWriterID, text: "/set The prosecutor", replyTo: testTarget, reply: "Description set.", desc: "The prosecutor"},
+		{name: "no arguments", sender: testWriterID, text: "/set", reply: MSG_ID_REQUIRED},
+		{name: "reply required", sender: testWriterID, text: "/set Prosecutor", reply: MSG_ID_REQUIRED},
+		{name: "missing ID", sender: testWriterID, text: "/set The prosecutor", reply: MSG_ID_REQUIRED},
+		{name: "owner by non-owner", sender: testOperatorID, text: "/set 1000 Boss", reply: "You can't change owner's data."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTest(t, recordedTarget())
+
+			ctx := newCommand(tt.sender, testGroupID, tt.text, tt.replyTo)
+
+			if err := SetHandler(ctx); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if ctx.last() != tt.reply {
+				t.Fatalf("expected reply %q, got %q", tt.reply, ctx.last())
+			}
+
+			if u := mustFind(t, testTargetID); u.Description != tt.desc {
+				t.Errorf("expected description %q, got %q", tt.desc, u.Description)
+			}
+		})
+	}
+}
+
+func TestHelpHandler(t *testing.T) {
+	tests := []struct {
+		name  string
+		chat  int64
+		text  string
+		reply string
+	}{
+		{name: "main page", chat: testReaderID, text: "/help", reply: HELP_MAIN},
+		{name: "command", chat: testGroupID, text: "/help audit", reply: CommandSyntax[CMD_AUDIT]},
+		{name: "command with a slash", chat: testGroupID, text: "/help /recall", reply: CommandSyntax[CMD_RECALL]},
+		{name: "unknown command", chat: testGroupID, text: "/help nope", reply: "Unknown command."},
+		{name: "too many", chat: testGroupID, text: "/help audit now", reply: "Too many arguments: \"now\".\n\nUsage: /help [<command>]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTest(t)
+
+			ctx := newCommand(testReaderID, tt.chat, tt.text, nil)
+
+			if err := HelpHandler(ctx); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if ctx.last() != tt.reply {
+				t.Errorf("expected reply %q, got %q", tt.reply, ctx.last())
+			}
+		})
+	}
+}
+
+func TestRecallHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		sender   int64
+		chat     int64
+		text     string
+		replyTo  *tele.User
+		contains string
+		keyboard bool
+	}{
+		{name: "by ID", sender: testReaderID, chat: testGroupID, text: "/recall 2000", contains: "<b>ID:</b> <code>2000</code>"},
+		{name: "by reply", sender: testReaderID, chat: testGroupID, text: "/recall", replyTo: testTarget, contains: "<b>ID:</b> <code>2000</code>"},
+		{name: "by name", sender: testReaderID, chat: testGroupID, text: "/recall name Miles Edgeworth", contains: "<b>ID:</b> <code>2000</code>"},
+		{name: "by username", sender: testReaderID, chat: testGroupID, text: "/recall username @miles", contains: "<b>ID:</b> <code>2000</code>"},
+		{name: "delete button in PM", sender: testWriterID, chat: testWriterID, text: "/recall 2000", contains: "<b>ID:</b> <code>2000</code>", keyboard: true},
+		{name: "no delete button for readers", sender: testReaderID, chat: testReaderID, text: "/recall 2000", contains: "<b>ID:</b> <code>2000</code>"},
+		{name: "no match", sender: testReaderID, chat: testGroupID, text: "/recall name Phoenix", contains: MSG_NO_MATCH},
+		{name: "words of a name", sender: testReaderID, chat: testGroupID, text: "/recall miles edgeworth", contains: "<b>ID:</b> <code>2000</code>"},
+		{name: "lone field", sender: testReaderID, chat: testGroupID, text: "/recall name", contains: "Missing <query..>."},
+		{name: "partial name", sender: testReaderID, chat: testGroupID, text: "/recall name miles", contains: "<b>ID:</b> <code>2000</code>"},
+		{name: "typo", sender: testReaderID, chat: testGroupID, text: "/recall edgewoth", contains: "<b>ID:</b> <code>2000</code>"},
+		{name: "search no match", sender: testReaderID, chat: testGroupID, text: "/recall abc", contains: MSG_NO_MATCH},
+		{name: "no ID", sender: testReaderID, chat: testGroupID, text: "/recall", contains: MSG_ID_REQUIRED},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTest(t, recordedTarget())
+
+			ctx := newCommand(tt.sender, tt.chat, tt.text, tt.replyTo)
+
+			if err := RecallHandler(ctx); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !strings.Contains(ctx.last(), tt.contains) {
+				t.Fatalf("expected reply to contain %q, got %q", tt.contains, ctx.last())
+			}
+
+			if (ctx.lastMarkup() != nil) != tt.keyboard {
+				t.Errorf("expected keyboard: %t", tt.keyboard)
+			}
+		})
+	}
+
+	t.Run("many matches", func(t *testing.T) {
+		other := newTestUser(2001, 0)
+		other.Names = []string{"Miles Edgeworth"}
+
+		setupTest(t, recordedTarget(), other)
+
+		ctx := newCommand(testReaderID, testGroupID, "/recall name Miles Edgeworth", nil)
+		RecallHandler(ctx)
+
+		if !strings.HasPrefix(ctx.last(), "<b>2</b> users matched") {
+			t.Errorf("expected two matches, got %q", ctx.last())
+		}
+	})
+}
+
+func TestCallbackHandlers(t *testing.T) {
+	t.Run("set permission", func(t *testing.T) {
+		setupTest(t, recordedTarget())
+
+		ctx := newCallback(testOperatorID, BTN_SET_PERM, "2:2000")
+
+		if err := SetPermBtnHandler(ctx); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if ctx.last() != "Permission updated." || mustFind(t, testTargetID).Permission != 2 {
+			t.Errorf("expected permission 2, got reply %q", ctx.last())
+		}
+	})
+
+	t.Run("confirm operator", func(t *testing.T) {
+		setupTest(t, recordedTarget())
+
+		ctx := newCallback(testOwnerID, BTN_CONFIRM_OPERATOR, "2000")
+
+		if err := ConfirmOperatorBtnHandler(ctx); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if ctx.last() != "User is now an operator!" || mustFind(t, testTargetID).Permission != 3 {
+			t.Errorf("expected operator permission, got reply %q", ctx.last())
+		}
+	})
+
+	t.Run("delete entry", func(t *testing.T) {
+		setupTest(t, recordedTarget())
+
+		ctx := newCallback(testWriterID, BTN_DELETE_ENTRY, "2000")
+
+		if err := DeleteEntryBtnHandler(ctx); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if _, err := Data.FindByID(testTargetID); ctx.last() != "User unregistered." || err == nil {
+			t.Errorf("expected user to be removed, got reply %q", ctx.last())
+		}
+	})
+}
+
+func TestQueryHandler(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		count int
+	}{
+		{name: "by ID", text: "2000", count: 1},
+		{name: "by username", text: "@miles", count: 1},
+		{name: "by name", text: "Miles Edgeworth", count: 1},
+		{name: "no match", text: "Phoenix", count: 0},
+		{name: "by prefix", text: "mil", count: 1},
+		{name: "by username typo", text: "@mils", count: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTest(t, recordedTarget())
+
+			ctx := newQuery(testReaderID, tt.text)
+
+			if err := QueryHandler(ctx); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(ctx.answers) != 1 || len(ctx.answers[0].Results) != tt.count {
+				t.Errorf("expected %d results", tt.count)
+			}
+		})
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Loads the configuration and opens the store, for the subcommands that work on the data without
+// starting the bot. Tests replace it, to work on their own store.
+var connect = func() {
+	loadConfig(false)
+	openStore()
+}
+
+// Changes made from the shell are attributed to the owner, who is the one holding the configuration.
+func shellActor() int64 { return Config.OwnerTelegramID }
+
+// Syntax:
+//
+//	botone migrate [-dry-run]
+func MigrateCommand(args []string) error {
+	flags := flag.NewFlagSet(SUBCMD_MIGRATE, flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "only report how many users each migration would change")
+	flags.Parse(args)
+
+	connect()
+	defer Data.Disconnect()
+
+	migrate(*dryRun)
+
+	return nil
+}
+
+// Syntax:
+//
+//	botone export [-o <file>] <ID> [json/csv/html/md]
+func ExportCommand(args []string) error {
+	flags := flag.NewFlagSet(SUBCMD_EXPORT, flag.ExitOnError)
+	out := flags.String("o", "", "write the export to this file instead of the standard output")
+	flags.Parse(args)
+
+	if flags.NArg() < 1 || flags.NArg() > 2 {
+		return errors.New("usage: botone export [-o <file>] <ID> [json/csv/html/md]")
+	}
+
+	id, parse_err := strconv.ParseInt(flags.Arg(0), 0, 64)
+
+	if parse_err != nil {
+		return fmt.Errorf("invalid ID \"%s\"", flags.Arg(0))
+	}
+
+	format := EXPORT_JSON
+
+	if flags.NArg() == 2 {
+		format = strings.ToLower(flags.Arg(1))
+	}
+
+	connect()
+	defer Data.Disconnect()
+
+	user, data_err := Data.FindByID(id)
+
+	if data_err != nil {
+		return fmt.Errorf("ID %d: %w", id, data_err)
+	}
+
+	content, _, err := ExportUser(user, format)
+
+	if err != nil {
+		return err
+	}
+
+	if *out != "" {
+		return os.WriteFile(*out, content, 0o644)
+	}
+
+	_, err = Stdout.Write(content)
+
+	return err
+}
+
+// Syntax:
+//
+//	botone import [-yes] <file>
+func ImportCommand(args []string) error {
+	flags := flag.NewFlagSet(SUBCMD_IMPORT, flag.ExitOnError)
+	yes := flags.Bool("yes", false, "import the file, instead of only showing what importing it would do")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return errors.New("usage: botone import [-yes] <file>")
+	}
+
+	f, err := os.Open(flags.Arg(0))
+
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	users, err := ParseImport(f, f.Name())
+
+	if err != nil {
+		return fmt.Errorf("invalid file: %w", err)
+	}
+
+	connect()
+	defer Data.Disconnect()
+
+	if !*yes {
+		current, err := Data.GetAll()
+
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(Stdout, "%d user(s) read. The import would:\n\n%s\n\nRun again with -yes to import.\n",
+			len(users), StripHTML(ImportPlanToStr(PlanImport(users, current))))
+
+		return nil
+	}
+
+	plan, failed, err := ApplyImport(shellActor(), users)
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(Stdout, "Imported:\n\n%s\n", StripHTML(ImportPlanToStr(plan)))
+
+	if failed > 0 {
+		return fmt.Errorf("%d registered user(s) could not be updated", failed)
+	}
+
+	return nil
+}
+
+// Syntax:
+//
+//	botone user get <ID>
+func UserCommand(args []string) error {
+	if len(args) != 2 || args[0] != "get" {
+		return errors.New("usage: botone user get <ID>")
+	}
+
+	id, parse_err := strconv.ParseInt(args[1], 0, 64)
+
+	if parse_err != nil {
+		return fmt.Errorf("invalid ID \"%s\"", args[1])
+	}
+
+	connect()
+	defer Data.Disconnect()
+
+	user, data_err := Data.FindByID(id)
+
+	if data_err != nil {
+		return fmt.Errorf("ID %d: %w", id, data_err)
+	}
+
+	_, err := fmt.Fprintln(Stdout, StripHTML(DisplayUser(&user)))
+
+	return err
+}
+
+// Syntax:
+//
+//	botone perm get <ID>
+//	botone perm set <ID> <permission-level>
+func PermCommand(args []string) error {
+	usage := errors.New("usage: botone perm get <ID> | botone perm set <ID> <permission-level>")
+
+	if len(args) < 2 || !(args[0] == "get" && len(args) == 2 || args[0] == "set" && len(args) == 3) {
+		return usage
+	}
+
+	id, parse_err := strconv.ParseInt(args[1], 0, 64)
+
+	if parse_err != nil {
+		return fmt.Errorf("invalid ID \"%s\"", args[1])
+	}
+
+	level := 0
+
+	if args[0] == "set" {
+		var perm_parse_err error
+
+		if level, perm_parse_err = strconv.Atoi(args[2]); perm_parse_err != nil || level < 0 || level > 3 {
+			return fmt.Errorf("invalid permission level \"%s\"; it must be between 0 and 3", args[2])
+		}
+	}
+
+	connect()
+	defer Data.Disconnect()
+
+	if id == Config.OwnerTelegramID {
+		return errors.New("the owner's permission level can't be changed")
+	}
+
+	if args[0] == "get" {
+		user, data_err := Data.FindByID(id)
+
+		if data_err != nil {
+			return fmt.Errorf("ID %d: %w", id, data_err)
+		}
+
+		_, err := fmt.Fprintf(Stdout, "%d (%s)\n", user.Permission, PermissionNames[user.Permission])
+
+		return err
+	}
+
+	before, after, err := UpdateUser(id, func(u *User) error {
+		u.Permission = level
+		return nil
+	})
+
+	if err != nil {
+		return fmt.Errorf("ID %d: %w", id, err)
+	}
+
+	AuditLog(shellActor(), id, AUDIT_PERM, &before, &after)
+
+	_, err = fmt.Fprintf(Stdout, "%s -> %s\n", PermissionNames[before.Permission], PermissionNames[after.Permission])
+
+	return err
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Runs the subcommands against the test store, and returns what they write.
+func setupCLI(t *testing.T, users ...User) *bytes.Buffer {
+	setupTest(t, users...)
+
+	out := &bytes.Buffer{}
+	oldConnect, oldStdout := connect, Stdout
+
+	connect, Stdout = func() {}, out
+
+	t.Cleanup(func() { connect, Stdout = oldConnect, oldStdout })
+
+	return out
+}
+
+func TestSubcommands(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+		err  string
+	}{
+		{"user get", []string{"user", "get", "2000"}, "Miles Edgeworth", ""},
+		{"user get unknown", []string{"user", "get", "4000"}, "", "ID 4000"},
+		{"user usage", []string{"user", "2000"}, "", "usage"},
+		{"perm get", []string{"perm", "get", "1001"}, "3 (Operator)", ""},
+		{"perm set", []string{"perm", "set", "2000", "2"}, "None -> Read/Write", ""},
+		{"perm set owner level", []string{"perm", "set", "2000", "4"}, "", "between 0 and 3"},
+		{"perm set owner", []string{"perm", "set", "1000", "1"}, "", "owner's permission"},
+		{"perm usage", []string{"perm", "set", "2000"}, "", "usage"},
+		{"export", []string{"export", "2000", "csv"}, "2000,record,bans,first", ""},
+		{"export invalid ID", []string{"export", "abc"}, "", "invalid ID"},
+		{"export unknown format", []string{"export", "2000", "pdf"}, "", "pdf"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := setupCLI(t, recordedTarget())
+
+			err := Subcommands[tt.args[0]](tt.args[1:])
+
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("expected error containing %q, got %v", tt.err, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !strings.Contains(out.String(), tt.want) {
+				t.Errorf("expected output containing %q, got %q", tt.want, out.String())
+			}
+		})
+	}
+}
+
+func TestPermCommandAudit(t *testing.T) {
+	setupCLI(t, recordedTarget())
+
+	if err := PermCommand([]string{"set", "2000", "1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if mustFind(t, testTargetID).Permission != 1 {
+		t.Error("expected the permission level to be set")
+	}
+
+	entries, _, _ := Data.FindAudit(testTargetID, AUDIT_PERM, time.Time{}, 0, 1)
+
+	if len(entries) != 1 || entries[0].Actor != testOwnerID {
+		t.Errorf("expected an audit entry by the owner, got %v", entries)
+	}
+}
+
+func TestImportCommand(t *testing.T) {
+	out := setupCLI(t)
+
+	file := filepath.Join(t.TempDir(), "list.json")
+	raw, _ := json.Marshal([]User{{TelegramID: 4000, Names: []string{"Franziska"}}})
+
+	if err := os.WriteFile(file, raw, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ImportCommand([]string{file}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(out.String(), "register 1 new user") || !strings.Contains(out.String(), "-yes") {
+		t.Errorf("unexpected preview %q", out.String())
+	}
+
+	if _, err := Data.FindByID(4000); err == nil {
+		t.Fatal("expected the preview not to import anything")
+	}
+
+	if err := ImportCommand([]string{"-yes", file}); err != nil {
+		t.Fatal(err)
+	}
+
+	if u := mustFind(t, 4000); u.History[0].AddedBy != testOwnerID {
+		t.Errorf("expected the import to be attributed to the owner, got %v", u.History)
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	tele "github.com/Henry96Markle/telebot"
+)
+
+const (
+	testOwnerID    int64 = 1000
+	testOperatorID int64 = 1001
+	testWriterID   int64 = 1002
+	testReaderID   int64 = 1003
+	testTargetID   int64 = 2000
+	testGroupID    int64 = -100
+)
+
+// fakeContext is a tele.Context that never talks to Telegram. It records every reply, edit
+// and callback response, so that the handlers can be tested offline. Methods the handlers
+// don't use are left to the embedded (nil) interface and panic if called.
+type fakeContext struct {
+	tele.Context
+
+	message  *tele.Message
+	callback *tele.Callback
+	query    *tele.Query
+
+	replies   []string
+	edits     []string
+	responses []*tele.CallbackResponse
+	markups   []*tele.ReplyMarkup
+	answers   []*tele.QueryResponse
+	documents []*tele.Document
+	deleted   bool
+}
+
+// Builds a command message sent by sender in chat. If replyTo isn't nil, the command is a
+// reply to a message sent by that user.
+func newCommand(sender, chat int64, text string, replyTo *tele.User) *fakeContext {
+	_, payload, _ := strings.Cut(text, " ")
+
+	m := &tele.Message{
+		Sender:  &tele.User{ID: sender, FirstName: "Tester"},
+		Chat:    &tele.Chat{ID: chat},
+		Text:    text,
+		Payload: strings.TrimSpace(payload),
+	}
+
+	if replyTo != nil {
+		m.ReplyTo = &tele.Message{Sender: replyTo, Chat: m.Chat}
+	}
+
+	return &fakeContext{message: m}
+}
+
+// Builds a callback from a button pressed by sender in its PM.
+func newCallback(sender int64, unique, data string) *fakeContext {
+	u := &tele.User{ID: sender, FirstName: "Tester"}
+
+	return &fakeContext{
+		message: &tele.Message{
+			Sender: &tele.User{ID: 1, FirstName: "Botone", IsBot: true},
+			Chat:   &tele.Chat{ID: sender},
+		},
+		callback: &tele.Callback{
+			Sender: u,
+			Unique: unique,
+			Data:   data,
+		},
+	}
+}
+
+// Builds an inline query sent by sender.
+func newQuery(sender int64, text string) *fakeContext {
+	return &fakeContext{
+		query: &tele.Query{
+			Sender: &tele.User{ID: sender},
+			Text:   text,
+		},
+	}
+}
+
+func (c *fakeContext) Message() *tele.Message { return c.message }
+
+func (c *fakeContext) Callback() *tele.Callback { return c.callback }
+
+func (c *fakeContext) Query() *tele.Query { return c.query }
+
+func (c *fakeContext) Sender() *tele.User {
+	switch {
+	case c.callback != nil:
+		return c.callback.Sender
+	case c.query != nil:
+		return c.query.Sender
+	case c.message != nil:
+		return c.message.Sender
+	default:
+		return nil
+	}
+}
+
+func (c *fakeContext) Chat() *tele.Chat {
+	if c.message == nil {
+		return nil
+	}
+
+	return c.message.Chat
+}
+
+func (c *fakeContext) Text() string {
+	if c.message == nil {
+		return ""
+	}
+
+	return c.message.Text
+}
+
+// Splits the arguments the same way telebot does.
+func (c *fakeContext) Args() []string {
+	switch {
+	case c.callback != nil:
+		return strings.Split(c.callback.Data, "|")
+	case c.query != nil:
+		return strings.Split(c.query.Text, " ")
+	case c.message != nil && c.message.Payload != "":
+		return strings.Split(c.message.Payload, " ")
+	default:
+		return nil
+	}
+}
+
+func (c *fakeContext) Reply(what interface{}, opts ...interface{}) error {
+	if d, ok := what.(*tele.Document); ok {
+		c.documents = append(c.documents, d)
+	}
+
+	c.replies = append(c.replies, textOf(what))
+	c.markups = append(c.markups, markupOf(opts))
+
+	return nil
+}
+
+func (c *fakeContext) Send(what interface{}, opts ...interface{}) error {
+	return c.Reply(what, opts...)
+}
+
+func (c *fakeContext) Edit(what interface{}, opts ...interface{}) error {
+	c.edits = append(c.edits, textOf(what))
+	c.markups = append(c.markups, markupOf(opts))
+
+	return nil
+}
+
+func (c *fakeContext) Respond(resp ...*tele.CallbackResponse) error {
+	c.responses = append(c.responses, resp...)
+
+	return nil
+}
+
+func (c *fakeContext) Answer(resp *tele.QueryResponse) error {
+	c.answers = append(c.answers, resp)
+
+	return nil
+}
+
+func (c *fakeContext) Delete() error {
+	c.deleted = true
+
+	return nil
+}
+
+// Returns the last reply or edit, whichever was sent.
+func (c *fakeContext) last() string {
+	switch {
+	case len(c.edits) > 0:
+		return c.edits[len(c.edits)-1]
+	case len(c.replies) > 0:
+		return c.replies[len(c.replies)-1]
+	default:
+		return ""
+	}
+}
+
+// Returns the last keyboard sent along a reply or an edit.
+func (c *fakeContext) lastMarkup() *tele.ReplyMarkup {
+	if len(c.markups) == 0 {
+		return nil
+	}
+
+	return c.markups[len(c.markups)-1]
+}
+
+func textOf(what interface{}) string {
+	switch w := what.(type) {
+	case string:
+		return w
+	case *tele.Document:
+		return w.FileName
+	default:
+		return ""
+	}
+}
+
+func markupOf(opts []interface{}) *tele.ReplyMarkup {
+	for _, o := range opts {
+		if m, ok := o.(*tele.ReplyMarkup); ok {
+			return m
+		}
+	}
+
+	return nil
+}
+
+// Replaces the global configuration and store with test ones, seeded with the given users
+// besides the owner, an operator, a read/write user and a read-only user.
+func setupTest(t *testing.T, users ...User) *MemoryDatabase {
+	t.Helper()
+
+	oldConfig, oldData := Config, Data
+
+	t.Cleanup(func() {
+		Config, Data = oldConfig, oldData
+	})
+
+	d := NewMemoryDatabase()
+
+	staff := []User{
+		newTestUser(testOwnerID, 4),
+		newTestUser(testOperatorID, 3),
+		newTestUser(testWriterID, 2),
+		newTestUser(testReaderID, 1),
+	}
+
+	if err := d.Add(append(staff, users...)...); err != nil {
+		t.Fatalf("error seeding store: %v", err)
+	}
+
+	Config = &Configuration{OwnerTelegramID: testOwnerID}
+	Data = d
+
+	observedIdentities = NewIdentityCache(IDENTITY_CACHE_SIZE, IDENTITY_CACHE_TTL)
+
+	return d
+}
+
+func newTestUser(id int64, permission int) User {
+	return User{
+		TelegramID: id,
+		Names:      []string{},
+		Usernames:  []string{},
+		AliasIDs:   []int64{},
+		Permission: permission,
+		Records:    map[string][]Record{},
+	}
+}
+
+// Fails the test if the store doesn't hold a user with the given ID.
+func mustFind(t *testing.T, id int64) User {
+	t.Helper()
+
+	u, err := Data.FindByID(id)
+
+	if err != nil {
+		t.Fatalf("expected ID %d to be registered: %v", id, err)
+	}
+
+	return u
+}
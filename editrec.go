@@ -0,0 +1,213 @@
+package main
+
+import (
+	"errors"
+	"html"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tele "github.com/Henry96Markle/telebot"
+)
+
+// Splits a ";"-separated list of notes, leaving out the empty ones.
+func SplitNotes(s string) []string {
+	notes := make([]string, 0)
+
+	for _, n := range strings.Split(s, ";") {
+		if n = strings.TrimSpace(n); n != "" {
+			notes = append(notes, n)
+		}
+	}
+
+	return notes
+}
+
+// Applies an edit to the record at the given (zero-based) index of a category, keeping what the record
+// was like before in its edit history. Its date and chat ID never change. The errors are meant for the
+// sender.
+func EditRecord(u *User, category string, index int, op string, arg string, by int64, at time.Time) error {
+	records, exists := u.Records[category]
+
+	if !exists {
+		return errors.New("Category \"" + category + "\" does not exist.")
+	}
+
+	if index < 0 || index >= len(records) {
+		return errors.New("Record index is out of bounds.")
+	}
+
+	r := records[index]
+
+	edit := RecordEdit{
+		Date:     at,
+		By:       by,
+		Action:   op,
+		Category: category,
+		Notes:    append([]string{}, r.Notes...),
+	}
+
+	// Parses the 1-based index of a note of the record.
+	noteIndex := func(s string) (int, error) {
+		i, err := strconv.Atoi(s)
+
+		if err != nil || i < 1 || i > len(r.Notes) {
+			return 0, errors.New("Note index is out of bounds.")
+		}
+
+		return i - 1, nil
+	}
+
+	switch op {
+	case EDITREC_REPLACE:
+		index, text, _ := strings.Cut(arg, " ")
+
+		i, err := noteIndex(index)
+
+		if err != nil {
+			return err
+		}
+
+		if text = strings.TrimSpace(text); text == "" {
+			return errors.New("The new note is empty.")
+		}
+
+		r.Notes = append([]string{}, r.Notes...)
+		r.Notes[i] = text
+	case EDITREC_APPEND:
+		notes := SplitNotes(arg)
+
+		if len(notes) == 0 {
+			return errors.New("No notes to append.")
+		}
+
+		r.Notes = append(append([]string{}, r.Notes...), notes...)
+	case EDITREC_REMOVE:
+		i, err := noteIndex(strings.TrimSpace(arg))
+
+		if err != nil {
+			return err
+		}
+
+		if len(r.Notes) == 1 {
+			return errors.New("That's the record's only note; use /delrec to delete the record.")
+		}
+
+		r.Notes = append(append([]string{}, r.Notes[:i]...), r.Notes[i+1:]...)
+	case EDITREC_MOVE:
+		to := strings.TrimSpace(arg)
+
+		if to == "" || strings.ContainsAny(to, " \t\n") {
+			return errors.New("Give a single category to move the record to.")
+		}
+
+		if to == category {
+			return errors.New("The record is already in \"" + category + "\".")
+		}
+	default:
+		return errors.New("Invalid operation: \"" + op + "\".")
+	}
+
+	r.Edits = append(append([]RecordEdit{}, r.Edits...), edit)
+
+	if op != EDITREC_MOVE {
+		records[index] = r
+		return nil
+	}
+
+	to := strings.TrimSpace(arg)
+
+	u.Records[category] = append(append([]Record{}, records[:index]...), records[index+1:]...)
+
+	if len(u.Records[category]) == 0 {
+		delete(u.Records, category)
+	}
+
+	u.Records[to] = append(u.Records[to], r)
+
+	sort.SliceStable(u.Records[to], func(i, j int) bool {
+		return u.Records[to][i].Date.Before(u.Records[to][j].Date)
+	})
+
+	return nil
+}
+
+// Syntax:
+//
+//	- /editrec <ID/@username/mention/reply-to-message> <category> <index> replace <note-index> <note>
+//	- /editrec <ID/@username/mention/reply-to-message> <category> <index> append <note1; note2; ..>
+//	- /editrec <ID/@username/mention/reply-to-message> <category> <index> remove <note-index>
+//	- /editrec <ID/@username/mention/reply-to-message> <category> <index> move <new-category>
+func EditrecHandler(c tele.Context) error {
+	// Acquire ID
+
+	args, args_err := ParseArgs(c, CommandSpecs[CMD_EDITREC])
+
+	if args_err != nil {
+		return ReplyArgsErr(c, CommandSpecs[CMD_EDITREC], args_err)
+	}
+
+	var (
+		id       = args.Target.ID
+		category = args.Str("category")
+		index    = args.Int("index")
+		op       = args.Str("operation")
+		value    = args.Str("value")
+	)
+
+	user, data_err := Data.FindByID(id)
+
+	if data_err != nil {
+		log.Printf(ERR_FMT_QUERY+"\n", data_err)
+		return c.Reply(MSG_ID_NOT_FOUND)
+	}
+
+	if (id == Config.OwnerTelegramID || user.Permission >= 4) && c.Sender().ID != Config.OwnerTelegramID {
+		return c.Reply("You can't modify owner's records.")
+	}
+
+	before, _ := cloneUser(user)
+
+	if err := EditRecord(&user, category, index-1, op, value, c.Sender().ID, time.Now()); err != nil {
+		return c.Reply(err.Error())
+	}
+
+	// Indexes refer to what the sender has seen, so a conflict isn't retried.
+
+	if err := Data.ReplaceByID(id, user); errors.Is(err, ErrConflict) {
+		return c.Reply(MSG_CONFLICT)
+	} else if err != nil {
+		log.Printf(ERR_FMT_UPDATE+"\n", err)
+		return c.Reply(MSG_COULD_NOT_PERFORM)
+	}
+
+	AuditLog(c.Sender().ID, id, AUDIT_EDITREC, &before, &user)
+
+	// logging
+
+	name := c.Message().Sender.FirstName + " " + c.Message().Sender.LastName
+
+	ChanLogf("#editrec\n[<code>%d</code>] %shas edited record %d of <b>%s</b> of ID <code>%d</code> (%s).",
+		c.Sender().ID,
+		BoolToStr(name != "", name+" ", ""),
+		index,
+		html.EscapeString(category),
+		id,
+		op,
+	)
+
+	// returning
+
+	switch op {
+	case EDITREC_REPLACE:
+		return c.Reply("Note replaced.")
+	case EDITREC_APPEND:
+		return c.Reply("Notes appended.")
+	case EDITREC_REMOVE:
+		return c.Reply("Note removed.")
+	default:
+		return c.Reply("Record moved to <b>"+html.EscapeString(value)+"</b>.", tele.ModeHTML)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	tele "github.com/Henry96Markle/telebot"
+)
+
+func TestEditrecHandler(t *testing.T) {
+	target := &tele.User{ID: testTargetID, FirstName: "Miles"}
+
+	tests := []struct {
+		name    string
+		text    string
+		replyTo *tele.User
+		want    string
+		check   func(t *testing.T, u User)
+	}{
+		{
+			name: "replace",
+			text: "/editrec 2000 bans 2 replace 1 second, fixed",
+			want: "Note replaced.",
+			check: func(t *testing.T, u User) {
+				if !reflect.DeepEqual(u.Records["bans"][1].Notes, []string{"second, fixed"}) {
+					t.Errorf("unexpected notes %v", u.Records["bans"][1].Notes)
+				}
+			},
+		},
+		{
+			name:    "append as reply",
+			text:    "/editrec bans 1 append more; and more",
+			replyTo: target,
+			want:    "Notes appended.",
+			check: func(t *testing.T, u User) {
+				if !reflect.DeepEqual(u.Records["bans"][0].Notes, []string{"first", "more", "and more"}) {
+					t.Errorf("unexpected notes %v", u.Records["bans"][0].Notes)
+				}
+			},
+		},
+		{
+			name: "move",
+			text: "/editrec 2000 warns 1 move bans",
+			want: "Record moved to <b>bans</b>.",
+			check: func(t *testing.T, u User) {
+				if _, ok := u.Records["warns"]; ok || len(u.Records["bans"]) != 3 {
+					t.Fatalf("expected the record to be moved, got %v", u.Records)
+				}
+
+				moved := u.Records["bans"][2]
+
+				if moved.Notes[0] != "third" || !moved.Date.Equal(time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)) || moved.ChatID != testGroupID {
+					t.Errorf("expected the record to keep its date and chat, got %+v", moved)
+				}
+
+				if len(moved.Edits) != 1 || moved.Edits[0].Category != "warns" || moved.Edits[0].By != testWriterID {
+					t.Errorf("unexpected edit history %+v", moved.Edits)
+				}
+			},
+		},
+		{
+			name: "move to a category with markup",
+			text: "/editrec 2000 warns 1 move <b>bans</b>",
+			want: "Record moved to <b>&lt;b&gt;bans&lt;/b&gt;</b>.",
+			check: func(t *testing.T, u User) {
+				if len(u.Records["<b>bans</b>"]) != 1 {
+					t.Errorf("expected the category to be kept as it was written, got %v", u.Records)
+				}
+			},
+		},
+		{name: "remove only note", text: "/editrec 2000 bans 1 remove 1", want: "/delrec"},
+		{name: "note out of bounds", text: "/editrec 2000 bans 1 replace 2 x", want: "Note index is out of bounds."},
+		{name: "record out of bounds", text: "/editrec 2000 bans 3 append x", want: "Record index is out of bounds."},
+		{name: "unknown category", text: "/editrec 2000 kicks 1 append x", want: "does not exist"},
+		{name: "same category", text: "/editrec 2000 bans 1 move bans", want: "already in"},
+		{name: "unknown operation", text: "/editrec 2000 bans 1 rename x", want: "Unknown operation: \"rename\"; expected replace, append, remove or move."},
+		{name: "invalid index", text: "/editrec 2000 bans one append x", want: "Invalid index: \"one\" isn't a number."},
+		{name: "insufficient", text: "/editrec 2000 bans", want: "Missing <index>."},
+		{name: "no ID", text: "/editrec bans 1 append x", want: MSG_ID_REQUIRED},
+		{name: "owner", text: "/editrec 1000 bans 1 append x", want: "owner's records"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			owner := newTestUser(testOwnerID, 4)
+			owner.Records["bans"] = []Record{{Notes: []string{"x"}}}
+
+			setupTest(t, recordedTarget())
+			Data.ReplaceByID(testOwnerID, owner)
+
+			c := newCommand(testWriterID, testGroupID, tt.text, tt.replyTo)
+
+			if err := EditrecHandler(c); err != nil {
+				t.Fatal(err)
+			}
+
+			if !strings.Contains(c.last(), tt.want) {
+				t.Fatalf("expected %q, got %q", tt.want, c.last())
+			}
+
+			u := mustFind(t, testTargetID)
+
+			if tt.check == nil {
+				if !reflect.DeepEqual(u.Records, recordedTarget().Records) {
+					t.Errorf("expected no change, got %v", u.Records)
+				}
+
+				return
+			}
+
+			tt.check(t, u)
+
+			if _, count, _ := Data.FindAudit(testTargetID, AUDIT_EDITREC, time.Time{}, 0, 1); count != 1 {
+				t.Errorf("expected an audit entry, got %d", count)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	tele "github.com/Henry96Markle/telebot"
+)
+
+// Forwards a message. Tests replace it, to do without Telegram.
+var ForwardMessage = func(c tele.Context, to tele.Recipient, msg tele.Editable) (*tele.Message, error) {
+	return c.Bot().Forward(to, msg)
+}
+
+// Takes a snapshot of a message: its text, caption and entities. Its media, if any, is forwarded to the
+// evidence chat, if there is one; if forwarding fails, the snapshot is kept without it.
+func CaptureEvidence(c tele.Context, msg *tele.Message) *Evidence {
+	e := &Evidence{
+		Date:    msg.Time(),
+		Text:    msg.Text,
+		Caption: msg.Caption,
+	}
+
+	if msg.Sender != nil {
+		e.SenderID = msg.Sender.ID
+	}
+
+	for _, entity := range append(append(tele.Entities{}, msg.Entities...), msg.CaptionEntities...) {
+		ee := EvidenceEntity{
+			Type:   string(entity.Type),
+			Offset: entity.Offset,
+			Length: entity.Length,
+			URL:    entity.URL,
+		}
+
+		if entity.User != nil {
+			ee.UserID = entity.User.ID
+		}
+
+		e.Entities = append(e.Entities, ee)
+	}
+
+	if media := msg.Media(); media != nil {
+		e.MediaType = media.MediaType()
+
+		if Config.EvidenceChatID != 0 {
+			forwarded, err := ForwardMessage(c, tele.ChatID(Config.EvidenceChatID), msg)
+
+			if err != nil {
+				log.Printf("error forwarding evidence: %v\n", err)
+			} else {
+				e.ChatID, e.MessageID = Config.EvidenceChatID, forwarded.ID
+			}
+		}
+	}
+
+	return e
+}
+
+// Parses evidence into a formatted string.
+func EvidenceToStr(e Evidence, limit int) string {
+	lines := []string{fmt.Sprintf("Sent by <code>%d</code>, on %v", e.SenderID, e.Date.UTC().Format("2006-01-02 15:04"))}
+
+	if e.MediaType != "" {
+		lines = append(lines, fmt.Sprintf("Media: %s%s", e.MediaType, BoolToStr(e.MessageID == 0, " (not kept)", "")))
+	}
+
+	// The text and caption share what's left of the limit, ellipses included; they're cut before they're
+	// escaped, so that no entity is cut in half.
+	room := limit - utf8.RuneCountInString(strings.Join(lines, "\n\n")+"\n\nText:\n…\n\nCaption:\n…")
+
+	if e.Text != "" && e.Caption != "" {
+		room /= 2
+	}
+
+	if room < 0 {
+		room = 0
+	}
+
+	if e.Text != "" {
+		lines = append(lines, "Text:\n"+html.EscapeString(TruncateStr(e.Text, room)))
+	}
+
+	if e.Caption != "" {
+		lines = append(lines, "Caption:\n"+html.EscapeString(TruncateStr(e.Caption, room)))
+	}
+
+	return strings.Join(lines, "\n\n")
+}
+
+// Returns a row of buttons for each pair of the user's records that have evidence, the latest first,
+// up to EVIDENCE_BUTTONS_LIMIT buttons. Records are named by their date, in milliseconds, as that's how
+// precisely the stores keep it; unlike their index, it doesn't change when other records are deleted.
+func EvidenceButtons(user User) [][]tele.InlineButton {
+	var (
+		buttons = make([]tele.InlineButton, 0)
+		rows    = make([][]tele.InlineButton, 0)
+	)
+
+	for _, category := range SortedCategories(user.Records) {
+		for i := len(user.Records[category]) - 1; i >= 0; i-- {
+			data := fmt.Sprintf("%d|%d|%s", user.TelegramID, user.Records[category][i].Date.UnixMilli(), category)
+
+			if user.Records[category][i].Evidence == nil || !FitsCallbackData(BTN_EVIDENCE, data) {
+				continue
+			}
+
+			buttons = append(buttons, tele.InlineButton{
+				Unique: BTN_EVIDENCE,
+				Text:   fmt.Sprintf("Evidence: %s #%d", category, i+1),
+				Data:   data,
+			})
+		}
+	}
+
+	if len(buttons) > EVIDENCE_BUTTONS_LIMIT {
+		buttons = buttons[:EVIDENCE_BUTTONS_LIMIT]
+	}
+
+	for i := 0; i < len(buttons); i += 2 {
+		if i+1 < len(buttons) {
+			rows = append(rows, buttons[i:i+2])
+		} else {
+			rows = append(rows, buttons[i:])
+		}
+	}
+
+	return rows
+}
+
+func EvidenceBtnHandler(c tele.Context) error {
+	if len(c.Args()) < 3 {
+		return c.Respond(&tele.CallbackResponse{Text: "Invalid callback data."})
+	}
+
+	id, parse_err1 := strconv.ParseInt(c.Args()[0], 0, 64)
+	date, parse_err2 := strconv.ParseInt(c.Args()[1], 0, 64)
+	category := strings.Join(c.Args()[2:], "|")
+
+	if parse_err1 != nil || parse_err2 != nil {
+		return c.Respond(&tele.CallbackResponse{Text: "Invalid callback data."})
+	}
+
+	user, data_err := Data.FindByID(id)
+
+	if data_err != nil {
+		log.Printf(ERR_FMT_QUERY+"\n", data_err)
+		return c.Respond(&tele.CallbackResponse{Text: MSG_ID_NOT_FOUND})
+	}
+
+	index := -1
+
+	for i, r := range user.Records[category] {
+		if r.Date.UnixMilli() == date && r.Evidence != nil {
+			index = i
+			break
+		}
+	}
+
+	if index < 0 {
+		return c.Respond(&tele.CallbackResponse{Text: "The record has been deleted since; recall the user again."})
+	}
+
+	e := user.Records[category][index].Evidence
+
+	c.Respond()
+
+	title := fmt.Sprintf("Evidence for <b>%s</b> #%d of <code>%d</code>:\n\n", html.EscapeString(category), index+1, id)
+
+	if err := c.Send(title+EvidenceToStr(*e, MESSAGE_LENGTH_LIMIT-utf8.RuneCountInString(title)), tele.ModeHTML); err != nil {
+		return err
+	}
+
+	if e.MessageID == 0 {
+		return nil
+	}
+
+	if _, err := ForwardMessage(c, c.Chat(), &tele.Message{ID: e.MessageID, Chat: &tele.Chat{ID: e.ChatID}}); err != nil {
+		log.Printf("error forwarding evidence: %v\n", err)
+		return c.Send("The media could not be forwarded; it may have been deleted from the evidence chat.")
+	}
+
+	return nil
+}
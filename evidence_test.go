@@ -0,0 +1,203 @@
+package main
+
+import (
+	"errors"
+	"html"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	tele "github.com/Henry96Markle/telebot"
+)
+
+// Records the messages forwarded, and answers with the given ID, or fails if it's 0.
+func fakeForward(t *testing.T, id int) *[]tele.Recipient {
+	forwarded := []tele.Recipient{}
+	old := ForwardMessage
+
+	ForwardMessage = func(_ tele.Context, to tele.Recipient, _ tele.Editable) (*tele.Message, error) {
+		forwarded = append(forwarded, to)
+
+		if id == 0 {
+			return nil, errors.New("forbidden")
+		}
+
+		return &tele.Message{ID: id}, nil
+	}
+
+	t.Cleanup(func() { ForwardMessage = old })
+
+	return &forwarded
+}
+
+func TestRecordEvidence(t *testing.T) {
+	photo := &tele.Photo{File: tele.File{FileID: "photo"}}
+
+	tests := []struct {
+		name      string
+		capture   bool
+		chat      int64
+		photo     *tele.Photo
+		forwardID int
+		want      *Evidence
+	}{
+		{name: "off", capture: false},
+		{name: "text", capture: true, want: &Evidence{SenderID: testTargetID, Text: "buy cheap links", Entities: []EvidenceEntity{{Type: "url", Offset: 10, Length: 5}}}},
+		{name: "media without chat", capture: true, photo: photo, want: &Evidence{SenderID: testTargetID, Text: "buy cheap links", Entities: []EvidenceEntity{{Type: "url", Offset: 10, Length: 5}}, MediaType: "photo"}},
+		{name: "media", capture: true, chat: -100999, photo: photo, forwardID: 77, want: &Evidence{SenderID: testTargetID, Text: "buy cheap links", Entities: []EvidenceEntity{{Type: "url", Offset: 10, Length: 5}}, MediaType: "photo", ChatID: -100999, MessageID: 77}},
+		{name: "forward fails", capture: true, chat: -100999, photo: photo, want: &Evidence{SenderID: testTargetID, Text: "buy cheap links", Entities: []EvidenceEntity{{Type: "url", Offset: 10, Length: 5}}, MediaType: "photo"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTest(t, recordedTarget())
+			Config.CaptureEvidence, Config.EvidenceChatID = tt.capture, tt.chat
+			fakeForward(t, tt.forwardID)
+
+			ctx := newCommand(testWriterID, testGroupID, "/record 2000 kicks spam", testTarget)
+			ctx.message.ReplyTo.Text = "buy cheap links"
+			ctx.message.ReplyTo.Entities = tele.Entities{{Type: tele.EntityURL, Offset: 10, Length: 5}}
+			ctx.message.ReplyTo.Photo = tt.photo
+
+			if err := RecordHandler(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			got := mustFind(t, testTargetID).Records["kicks"][0].Evidence
+
+			if tt.want == nil {
+				if got != nil {
+					t.Errorf("expected no evidence, got %+v", got)
+				}
+
+				return
+			}
+
+			if got == nil {
+				t.Fatal("expected evidence")
+			}
+
+			got.Date = tt.want.Date
+
+			if !SameRecord(Record{Evidence: got}, Record{Evidence: tt.want}) {
+				t.Errorf("expected evidence %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+
+	t.Run("refused", func(t *testing.T) {
+		setupTest(t, recordedTarget())
+		Config.CaptureEvidence, Config.EvidenceChatID = true, -100999
+		forwarded := fakeForward(t, 77)
+
+		ctx := newCommand(testWriterID, testGroupID, "/record 1000 kicks spam", testTarget)
+		ctx.message.ReplyTo.Photo = photo
+
+		if err := RecordHandler(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(*forwarded) != 0 {
+			t.Errorf("expected nothing to be captured for a refused record, got %d forwarded message(s)", len(*forwarded))
+		}
+	})
+}
+
+func TestEvidenceBtnHandler(t *testing.T) {
+	target := recordedTarget()
+	target.Records["bans"][1].Evidence = &Evidence{SenderID: testTargetID, Text: "<spam>", MediaType: "photo", ChatID: -100999, MessageID: 77}
+	target.Records["warns"][0].Evidence = &Evidence{SenderID: testTargetID, Text: "rude"}
+
+	t.Run("buttons", func(t *testing.T) {
+		setupTest(t, target)
+
+		rows := EvidenceButtons(target)
+
+		if len(rows) != 1 || len(rows[0]) != 2 || rows[0][0].Data != "2000|1643673600000|bans" || rows[0][1].Text != "Evidence: warns #1" {
+			t.Errorf("unexpected buttons %+v", rows)
+		}
+
+		_, keyboard := ProfileMessage(newCommand(testReaderID, testGroupID, "/recall 2000", nil), target, 0, "")
+
+		if keyboard == nil || keyboard.InlineKeyboard[0][0].Unique != BTN_EVIDENCE {
+			t.Errorf("expected the profile to offer the evidence, got %+v", keyboard)
+		}
+	})
+
+	tests := []struct {
+		name      string
+		data      string
+		want      string
+		forwarded int
+	}{
+		{name: "with media", data: "2000|1643673600000|bans", want: "Text:\n&lt;spam&gt;", forwarded: 1},
+		{name: "text only", data: "2000|1646092800000|warns", want: "Text:\nrude"},
+		{name: "no evidence", data: "2000|1640995200000|bans", want: "has been deleted"},
+		{name: "deleted", data: "2000|1|bans", want: "has been deleted"},
+		{name: "unknown user", data: "4000|1643673600000|bans", want: MSG_ID_NOT_FOUND},
+		{name: "invalid", data: "x|1643673600000|bans", want: "Invalid callback data."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTest(t, target)
+			forwarded := fakeForward(t, 1)
+
+			c := newCallback(testReaderID, BTN_EVIDENCE, tt.data)
+
+			if err := EvidenceBtnHandler(c); err != nil {
+				t.Fatal(err)
+			}
+
+			got := c.last()
+
+			if len(c.replies) == 0 && len(c.responses) > 0 {
+				got = c.responses[len(c.responses)-1].Text
+			}
+
+			if !strings.Contains(got, tt.want) {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+
+			if len(*forwarded) != tt.forwarded {
+				t.Errorf("expected %d forwarded message(s), got %d", tt.forwarded, len(*forwarded))
+			}
+		})
+	}
+	t.Run("after a deletion", func(t *testing.T) {
+		setupTest(t, target)
+		fakeForward(t, 1)
+
+		data := EvidenceButtons(target)[0][0].Data
+		Data.Record(testTargetID, "bans", target.Records["bans"][0], true)
+
+		c := newCallback(testReaderID, BTN_EVIDENCE, data)
+
+		if err := EvidenceBtnHandler(c); err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.Contains(c.last(), "Text:\n&lt;spam&gt;") {
+			t.Errorf("expected the button to still show its record, got %q", c.last())
+		}
+	})
+
+	t.Run("long text", func(t *testing.T) {
+		long := recordedTarget()
+		long.Records["warns"][0].Evidence = &Evidence{SenderID: testTargetID, Text: strings.Repeat("<", 5000), Caption: strings.Repeat("a", 5000)}
+
+		setupTest(t, long)
+
+		c := newCallback(testReaderID, BTN_EVIDENCE, "2000|1646092800000|warns")
+
+		if err := EvidenceBtnHandler(c); err != nil {
+			t.Fatal(err)
+		}
+
+		text := html.UnescapeString(c.last())
+
+		if n := utf8.RuneCountInString(text); n > MESSAGE_LENGTH_LIMIT || !strings.Contains(text, "<…\n\nCaption:\naaa") {
+			t.Errorf("expected the text and caption to be cut down, got %d runes", n)
+		}
+	})
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tele "github.com/Henry96Markle/telebot"
+)
+
+var exportHTML = template.Must(template.New("user").Funcs(template.FuncMap{
+	"date":       func(t time.Time) string { return t.UTC().Format("2006-01-02 15:04") },
+	"categories": SortedCategories,
+	"permission": func(p int) string { return PermissionNames[p] },
+	"current":    LastOf[string],
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.TelegramID}}</title>
+</head>
+<body>
+<h1>{{or (current .Names) .TelegramID}}</h1>
+<ul>
+<li><b>ID:</b> <code>{{.TelegramID}}</code></li>
+<li><b>Permission level:</b> {{permission .Permission}}</li>
+{{- if .Names}}
+<li><b>Names:</b> {{range $i, $n := .Names}}{{if $i}}, {{end}}{{$n}}{{end}}</li>
+{{- end}}
+{{- if .Usernames}}
+<li><b>Usernames:</b> {{range $i, $n := .Usernames}}{{if $i}}, {{end}}@{{$n}}{{end}}</li>
+{{- end}}
+{{- if .AliasIDs}}
+<li><b>Alias IDs:</b> {{range $i, $n := .AliasIDs}}{{if $i}}, {{end}}<code>{{$n}}</code>{{end}}</li>
+{{- end}}
+</ul>
+{{- with .Description}}
+<p>{{.}}</p>
+{{- end}}
+{{- if .History}}
+<h2>Identity timeline</h2>
+<table>
+<tr><th>Kind</th><th>Value</th><th>First seen</th><th>Last seen</th><th>Source</th></tr>
+{{- range .History}}
+<tr><td>{{.Kind}}</td><td>{{.Value}}</td><td>{{date .FirstSeen}}</td><td>{{date .LastSeen}}</td><td>{{.Source}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- $records := .Records}}
+{{- range categories .Records}}
+<h2>{{.}}</h2>
+<table>
+<tr><th>Date</th><th>Chat ID</th><th>Author</th><th>Notes</th></tr>
+{{- range index $records .}}
+<tr><td>{{date .Date}}</td><td><code>{{.ChatID}}</code>{{with .Link}} (<a href="{{.}}">message</a>){{end}}</td><td>{{with .Author}}<code>{{.}}</code>{{end}}</td><td>{{range $i, $n := .Notes}}{{if $i}}<br>{{end}}{{$n}}{{end}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+</body>
+</html>
+`))
+
+// Renders a user in one of the export formats, and returns the file's content and extension.
+func ExportUser(user User, format string) ([]byte, string, error) {
+	switch format {
+	case EXPORT_JSON:
+		b, err := json.MarshalIndent(user, "", "  ")
+		return b, "json", err
+	case EXPORT_CSV:
+		return exportCSV(user), "csv", nil
+	case EXPORT_HTML:
+		buf := &bytes.Buffer{}
+		err := exportHTML.Execute(buf, user)
+		return buf.Bytes(), "html", err
+	case EXPORT_MARKDOWN:
+		return exportMarkdown(user), "md", nil
+	default:
+		return nil, "", fmt.Errorf("unknown export format \"%s\"", format)
+	}
+}
+
+// One row for each field of the user, and one for each record, under the EXPORT_CSV_HEADER columns.
+func exportCSV(user User) []byte {
+	var (
+		buf = &bytes.Buffer{}
+		w   = csv.NewWriter(buf)
+		id  = strconv.FormatInt(user.TelegramID, 10)
+
+		// Writes a row, leaving the columns that don't apply to it empty.
+		write = func(fields ...string) {
+			w.Write(append(fields, make([]string, len(EXPORT_CSV_HEADER)-len(fields))...))
+		}
+
+		// Zero IDs are left empty, as records written before authorship was kept have them.
+		optional = func(n int64) string { return BoolToStr(n != 0, strconv.FormatInt(n, 10), "") }
+	)
+
+	w.Write(EXPORT_CSV_HEADER)
+
+	for _, n := range user.Names {
+		write(id, "name", "", n)
+	}
+
+	for _, n := range user.Usernames {
+		write(id, "username", "", n)
+	}
+
+	for _, a := range user.AliasIDs {
+		write(id, "alias_id", "", strconv.FormatInt(a, 10))
+	}
+
+	if user.Description != "" {
+		write(id, "description", "", user.Description)
+	}
+
+	write(id, "permission_level", "", strconv.Itoa(user.Permission))
+
+	for _, category := range SortedCategories(user.Records) {
+		for _, r := range user.Records[category] {
+			write(
+				id,
+				"record",
+				category,
+				JoinNotes(r.Notes),
+				r.Date.UTC().Format(time.RFC3339),
+				strconv.FormatInt(r.ChatID, 10),
+				optional(r.Author),
+				optional(int64(r.MessageID)),
+				optional(int64(r.ReplyTo)),
+				r.Link,
+			)
+		}
+	}
+
+	w.Flush()
+
+	return buf.Bytes()
+}
+
+// Joins notes with "; ", the way /record takes them, escaping the ";" and "\" within them with a
+// "\", so that SplitEscapedNotes gives them back as they were.
+func JoinNotes(notes []string) string {
+	escaped := make([]string, 0, len(notes))
+
+	for _, n := range notes {
+		escaped = append(escaped, strings.NewReplacer(`\`, `\\`, `;`, `\;`).Replace(n))
+	}
+
+	return strings.Join(escaped, "; ")
+}
+
+// Escapes the characters Markdown would otherwise interpret.
+func escapeMarkdown(s string) string {
+	return strings.NewReplacer(
+		`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`, "<", `\<`, ">", `\>`, "#", `\#`, "|", `\|`,
+	).Replace(s)
+}
+
+func exportMarkdown(user User) []byte {
+	var (
+		b = &strings.Builder{}
+
+		list = func(title string, values []string) {
+			if len(values) == 0 {
+				return
+			}
+
+			fmt.Fprintf(b, "\n## %s\n\n", title)
+
+			for _, v := range values {
+				fmt.Fprintf(b, "- %s\n", v)
+			}
+		}
+
+		escaped = func(values []string) []string {
+			result := make([]string, 0, len(values))
+
+			for _, v := range values {
+				result = append(result, escapeMarkdown(v))
+			}
+
+			return result
+		}
+	)
+
+	fmt.Fprintf(b, "# %s\n\n", escapeMarkdown(BoolToStr(len(user.Names) > 0, LastOf(user.Names), strconv.FormatInt(user.TelegramID, 10))))
+	fmt.Fprintf(b, "- **ID:** `%d`\n- **Permission level:** %s\n", user.TelegramID, PermissionNames[user.Permission])
+
+	if user.Description != "" {
+		fmt.Fprintf(b, "\n%s\n", escapeMarkdown(user.Description))
+	}
+
+	list("Names", escaped(user.Names))
+	list("Usernames", escaped(user.Usernames))
+	list("Alias IDs", Map(user.AliasIDs, func(a int64) (string, error) { return fmt.Sprintf("`%d`", a), nil }))
+
+	if len(user.History) > 0 {
+		b.WriteString("\n## Identity timeline\n\n| Kind | Value | First seen | Last seen | Source |\n| --- | --- | --- | --- | --- |\n")
+
+		for _, e := range user.History {
+			fmt.Fprintf(b, "| %s | %s | %s | %s | %s |\n",
+				e.Kind, escapeMarkdown(e.Value), e.FirstSeen.UTC().Format("2006-01-02"), e.LastSeen.UTC().Format("2006-01-02"), e.Source)
+		}
+	}
+
+	for _, category := range SortedCategories(user.Records) {
+		fmt.Fprintf(b, "\n## Records: %s\n\n", escapeMarkdown(category))
+
+		for _, r := range user.Records[category] {
+			fmt.Fprintf(b, "- **%s**, chat `%d`", r.Date.UTC().Format("2006-01-02 15:04"), r.ChatID)
+
+			if r.Author != 0 {
+				fmt.Fprintf(b, ", by `%d`", r.Author)
+			}
+
+			if r.Link != "" {
+				fmt.Fprintf(b, ", [message](%s)", r.Link)
+			}
+
+			for _, n := range r.Notes {
+				fmt.Fprintf(b, "\n  - %s", escapeMarkdown(n))
+			}
+
+			b.WriteString("\n")
+		}
+	}
+
+	return []byte(b.String())
+}
+
+// Syntax:
+//
+//	- /export <ID/@username/mention/reply-to-message> [json/csv/html/md]
+func ExportHandler(c tele.Context) error {
+	args, args_err := ParseArgs(c, CommandSpecs[CMD_EXPORT])
+
+	if args_err != nil {
+		return ReplyArgsErr(c, CommandSpecs[CMD_EXPORT], args_err)
+	}
+
+	var (
+		id     = args.Target.ID
+		format = BoolToStr(args.Has("format"), args.Str("format"), EXPORT_JSON)
+	)
+
+	user, data_err := Data.FindByID(id)
+
+	if data_err != nil {
+		log.Printf(ERR_FMT_QUERY+"\n", data_err)
+		return c.Reply(MSG_ID_NOT_FOUND)
+	}
+
+	content, ext, err := ExportUser(user, format)
+
+	if err != nil {
+		return c.Reply(fmt.Sprintf("Unknown format \"%s\"; use one of: %s.", format, strings.Join(EXPORT_FORMATS, ", ")))
+	}
+
+	return c.Reply(&tele.Document{
+		File:     tele.FromReader(bytes.NewReader(content)),
+		FileName: fmt.Sprintf("user-%d.%s", id, ext),
+	})
+}
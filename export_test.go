@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func exportedTarget() User {
+	u := recordedTarget()
+	u.ID = primitive.NewObjectID()
+	u.Description = "Prosecutor <b>& rival</b>"
+	u.AliasIDs = []int64{3001}
+	u.Records["warns"][0].Author = testWriterID
+	u.Records["warns"][0].MessageID = 10
+	u.Records["warns"][0].ReplyTo = 9
+	u.Records["warns"][0].Link = "https://t.me/c/100/9"
+	u.Records["bans"][1].Notes = []string{"second; or third", `C:\logs\`}
+
+	NoteIdentity(&u, IDENTITY_NAME, "Edgey", SOURCE_MANUAL, testWriterID, time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC))
+
+	return u
+}
+
+func TestExportUser(t *testing.T) {
+	u := exportedTarget()
+
+	t.Run("json", func(t *testing.T) {
+		b, ext, err := ExportUser(u, EXPORT_JSON)
+
+		if err != nil || ext != "json" {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		back := User{}
+
+		if err = json.Unmarshal(b, &back); err != nil {
+			t.Fatalf("error decoding export: %v", err)
+		}
+
+		if !reflect.DeepEqual(u, back) {
+			t.Errorf("expected the export to round-trip, got %v", back)
+		}
+	})
+
+	t.Run("csv", func(t *testing.T) {
+		b, _, _ := ExportUser(u, EXPORT_CSV)
+		rows, err := csv.NewReader(strings.NewReader(string(b))).ReadAll()
+
+		if err != nil {
+			t.Fatalf("error reading export: %v", err)
+		}
+
+		// Header, 2 names, a username, an alias, the description, the permission and 3 records.
+		if len(rows) != 10 || !reflect.DeepEqual(rows[0], EXPORT_CSV_HEADER) {
+			t.Fatalf("unexpected rows %v", rows)
+		}
+
+		if r := rows[7]; strings.Join(r, ",") != "2000,record,bans,first,2022-01-01T00:00:00Z,-100,,,," {
+			t.Errorf("unexpected record row %v", r)
+		}
+
+		// The separator and the escape within notes are escaped.
+		if r := rows[8]; r[3] != `second\; or third; C:\\logs\\` {
+			t.Errorf("unexpected notes %q", r[3])
+		}
+
+		if r := rows[9]; strings.Join(r, ",") != "2000,record,warns,third,2022-03-01T00:00:00Z,-100,1002,10,9,https://t.me/c/100/9" {
+			t.Errorf("unexpected record row %v", r)
+		}
+
+		// The export imports back.
+		back, err := ParseImport(strings.NewReader(string(b)), "user.csv")
+
+		if err != nil || !reflect.DeepEqual(back[0].Records, u.Records) {
+			t.Errorf("expected the records to round-trip, got %v (%v)", back, err)
+		}
+	})
+
+	t.Run("html", func(t *testing.T) {
+		b, _, _ := ExportUser(u, EXPORT_HTML)
+		s := string(b)
+
+		if !strings.Contains(s, "<h1>Edgey</h1>") || !strings.Contains(s, "Prosecutor &lt;b&gt;&amp; rival&lt;/b&gt;") || !strings.Contains(s, "<h2>warns</h2>") ||
+			!strings.Contains(s, `(<a href="https://t.me/c/100/9">message</a>)</td><td><code>1002</code>`) {
+			t.Errorf("unexpected export %s", s)
+		}
+	})
+
+	t.Run("markdown", func(t *testing.T) {
+		b, _, _ := ExportUser(u, EXPORT_MARKDOWN)
+		s := string(b)
+
+		if !strings.HasPrefix(s, "# Edgey\n") || !strings.Contains(s, "## Records: bans") || !strings.Contains(s, `Prosecutor \<b\>& rival\</b\>`) ||
+			!strings.Contains(s, "chat `-100`, by `1002`, [message](https://t.me/c/100/9)") {
+			t.Errorf("unexpected export %s", s)
+		}
+	})
+
+	if _, _, err := ExportUser(u, "pdf"); err == nil {
+		t.Errorf("expected an unknown format to fail")
+	}
+}
+
+func TestExportHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		file     string
+		contains string
+	}{
+		{name: "default", text: "/export 2000", file: "user-2000.json", contains: `"tg_id": 2000`},
+		{name: "markdown", text: "/export 2000 MD", file: "user-2000.md", contains: "# Miles Edgeworth"},
+		{name: "username", text: "/export @miles csv", file: "user-2000.csv", contains: "2000,username,,miles"},
+		{name: "unknown format", text: "/export 2000 pdf", contains: "Unknown format: \"pdf\"; expected json, csv, html or md.\n\nUsage: /export <user> [<json/csv/html/md>]"},
+		{name: "not found", text: "/export 4000", contains: MSG_ID_NOT_FOUND},
+		{name: "invalid", text: "/export abc", contains: MSG_ID_REQUIRED},
+		{name: "insufficient", text: "/export", contains: MSG_ID_REQUIRED},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTest(t, recordedTarget())
+
+			ctx := newCommand(testReaderID, testGroupID, tt.text, nil)
+
+			if err := ExportHandler(ctx); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if tt.file == "" {
+				if ctx.last() != tt.contains {
+					t.Errorf("expected reply %q, got %q", tt.contains, ctx.last())
+				}
+
+				return
+			}
+
+			if len(ctx.documents) != 1 || ctx.documents[0].FileName != tt.file {
+				t.Fatalf("expected %s to be sent", tt.file)
+			}
+
+			if b, _ := io.ReadAll(ctx.documents[0].File.FileReader); !strings.Contains(string(b), tt.contains) {
+				t.Errorf("expected the file to contain %q, got %s", tt.contains, b)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"strconv"
+	"strings"
+
+	tele "github.com/Henry96Markle/telebot"
+)
+
+// Reports whether a message is a forward. Unlike msg.IsForwarded, it includes forwards from senders who
+// hide their account, which only carry the original date and name.
+func IsForward(msg *tele.Message) bool {
+	return msg != nil && (msg.IsForwarded() || msg.OriginalUnixtime != 0)
+}
+
+// Returns who originally sent a forwarded message, or nil if they can't be identified. Then, name is
+// whatever the forward tells of its origin: the name of a sender who hides their account, or the
+// title of a channel.
+func ForwardOrigin(msg *tele.Message) (origin *tele.User, name string) {
+	switch {
+	case msg.OriginalSender != nil:
+		return msg.OriginalSender, ""
+	case msg.OriginalChat != nil:
+		return nil, msg.OriginalChat.Title
+	default:
+		return nil, msg.OriginalSenderName
+	}
+}
+
+// Replies with the profile of whoever originally sent a forwarded message. Unknown senders are offered
+// to be registered or recorded; senders who hide their account are searched for by name instead.
+func IdentifyForward(c tele.Context, msg *tele.Message) error {
+	origin, name := ForwardOrigin(msg)
+
+	if origin == nil {
+		if msg.OriginalChat != nil {
+			return c.Reply(fmt.Sprintf("The message was forwarded from a channel (%s), not a user.", html.EscapeString(name)), tele.ModeHTML)
+		}
+
+		if name == "" {
+			return c.Reply("The original sender can't be identified.")
+		}
+
+		users, err := RecallSearch("name", name)
+
+		if err != nil {
+			log.Printf("error searching users by name: %v\n", err)
+		}
+
+		note := fmt.Sprintf("The original sender hides their account; searched for their name, \"%s\", instead.", html.EscapeString(name))
+
+		switch len(users) {
+		case 0:
+			return c.Reply(note+"\n\n"+MSG_NO_MATCH, tele.ModeHTML)
+		case 1:
+			text, keyboard := ProfileMessage(c, users[0], 0, "")
+			return c.Reply(note+"\n\n"+text, keyboard, tele.ModeHTML)
+		default:
+			text, keyboard := MatchesMessage(users, "name", name, 0)
+			return c.Reply(note+"\n\n"+text, keyboard, tele.ModeHTML)
+		}
+	}
+
+	user, err := FindByAnyID(origin.ID)
+
+	if err == nil {
+		text, keyboard := ProfileMessage(c, user, 0, "")
+		return c.Reply(text, keyboard, tele.ModeHTML)
+	}
+
+	if !errors.Is(err, ErrNotFound) {
+		log.Printf(ERR_FMT_QUERY+"\n", err)
+		return c.Reply(MSG_COULD_NOT_PERFORM)
+	}
+
+	text := fmt.Sprintf("[<code>%d</code>] %s%sisn't registered.",
+		origin.ID,
+		html.EscapeString(strings.TrimSpace(origin.FirstName+" "+origin.LastName)+" "),
+		BoolToStr(origin.Username != "", "@"+origin.Username+" ", ""),
+	)
+
+	if origin.IsBot {
+		return c.Reply(text+" It's a bot; bots can't be registered.", tele.ModeHTML)
+	}
+
+	return c.Reply(text, QuickActionsKeyboard(origin.ID, true), tele.ModeHTML)
+}
+
+// Builds the buttons offered for an unknown user: one to register them, if they aren't yet, and one to
+// record them.
+func QuickActionsKeyboard(id int64, register bool) *tele.ReplyMarkup {
+	row := make([]tele.InlineButton, 0, 2)
+
+	if register {
+		regBtn := *QuickRegBtn
+		regBtn.Data = strconv.FormatInt(id, 10)
+
+		row = append(row, *regBtn.Inline())
+	}
+
+	recordBtn := *QuickRecordBtn
+	recordBtn.Data = strconv.FormatInt(id, 10)
+
+	return &tele.ReplyMarkup{InlineKeyboard: [][]tele.InlineButton{append(row, *recordBtn.Inline())}}
+}
+
+// Identifies the original sender of messages forwarded to the bot in PM.
+func ForwardedHandler(c tele.Context) error {
+	if !IsForward(c.Message()) || c.Chat().ID != c.Sender().ID {
+		return nil
+	}
+
+	return IdentifyForward(c, c.Message())
+}
+
+func QuickRegBtnHandler(c tele.Context) error {
+	id, parse_err := strconv.ParseInt(c.Callback().Data, 0, 64)
+
+	if parse_err != nil {
+		return c.Edit("Invalid callback data.")
+	}
+
+	// The names come from the forward the buttons were offered for, if it's still there.
+	var sender *tele.User
+
+	if fwd := c.Message().ReplyTo; fwd != nil {
+		if origin, _ := ForwardOrigin(fwd); origin != nil && origin.ID == id {
+			sender = origin
+		}
+	}
+
+	user := NewUser(id, sender, "", c.Sender().ID)
+
+	if err := Data.Add(user); errors.Is(err, ErrDuplicate) {
+		return c.Edit("User is already registered.", QuickActionsKeyboard(id, false))
+	} else if err != nil {
+		log.Printf("error registering user: %v", err)
+		return c.Edit(MSG_COULD_NOT_PERFORM)
+	}
+
+	AuditLog(c.Sender().ID, id, AUDIT_REG, nil, &user)
+
+	// logging
+
+	name := c.Sender().FirstName + " " + c.Sender().LastName
+
+	ChanLogf("#reg\n[<code>%d</code>] %shas registered ID <code>%d</code>.",
+		c.Sender().ID,
+		BoolToStr(name != "", name+" ", ""),
+		id,
+	)
+
+	// returning
+
+	return c.Edit(fmt.Sprintf("[<code>%d</code>] User registered.", id), QuickActionsKeyboard(id, false), tele.ModeHTML)
+}
+
+func QuickRecordBtnHandler(c tele.Context) error {
+	id, parse_err := strconv.ParseInt(c.Callback().Data, 0, 64)
+
+	if parse_err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: "Invalid callback data."})
+	}
+
+	c.Respond()
+
+	return c.Send(fmt.Sprintf("To record them, send:\n\n<code>/record %d &lt;category&gt; &lt;note1; note2; ..&gt;</code>", id), tele.ModeHTML)
+}
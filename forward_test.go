@@ -0,0 +1,140 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	tele "github.com/Henry96Markle/telebot"
+)
+
+// Builds a message forwarded to the bot in the PM of sender.
+func newForward(sender int64, origin *tele.User, chat *tele.Chat, name string) *fakeContext {
+	return &fakeContext{
+		message: &tele.Message{
+			Sender:             &tele.User{ID: sender, FirstName: "Tester"},
+			Chat:               &tele.Chat{ID: sender},
+			Text:               "buy cheap links",
+			OriginalUnixtime:   1,
+			OriginalSender:     origin,
+			OriginalChat:       chat,
+			OriginalSenderName: name,
+		},
+	}
+}
+
+func TestForwardedHandler(t *testing.T) {
+	stranger := &tele.User{ID: 2500, FirstName: "Phoenix", Username: "phoenix"}
+	alias := &tele.User{ID: 2600, FirstName: "Miles"}
+
+	tests := []struct {
+		name     string
+		origin   *tele.User
+		chat     *tele.Chat
+		hidden   string
+		contains string
+		buttons  []string
+	}{
+		{name: "registered", origin: testTarget, contains: "<b>ID:</b> <code>2000</code>"},
+		{name: "alias", origin: alias, contains: "<b>ID:</b> <code>2000</code>"},
+		{name: "unknown", origin: stranger, contains: "[<code>2500</code>] Phoenix @phoenix isn't registered.", buttons: []string{BTN_QUICK_REG, BTN_QUICK_RECORD}},
+		{name: "bot", origin: testBot, contains: "bots can't be registered"},
+		{name: "hidden match", hidden: "Miles Edgeworth", contains: "<b>ID:</b> <code>2000</code>"},
+		{name: "hidden no match", hidden: "Phoenix Wright", contains: MSG_NO_MATCH},
+		{name: "channel", chat: &tele.Chat{ID: -1005, Type: tele.ChatChannel, Title: "News"}, contains: "forwarded from a channel (News)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := recordedTarget()
+			target.AliasIDs = []int64{alias.ID}
+
+			setupTest(t, target)
+
+			ctx := newForward(testReaderID, tt.origin, tt.chat, tt.hidden)
+
+			if err := ForwardedHandler(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			if !strings.Contains(ctx.last(), tt.contains) {
+				t.Fatalf("expected reply to contain %q, got %q", tt.contains, ctx.last())
+			}
+
+			if tt.buttons == nil {
+				return
+			}
+
+			markup := ctx.lastMarkup()
+
+			if markup == nil || len(markup.InlineKeyboard) != 1 || len(markup.InlineKeyboard[0]) != len(tt.buttons) {
+				t.Fatalf("expected buttons %v, got %+v", tt.buttons, markup)
+			}
+
+			for i, btn := range markup.InlineKeyboard[0] {
+				if btn.Unique != tt.buttons[i] || btn.Data != "2500" {
+					t.Errorf("expected %s button for 2500, got %s %q", tt.buttons[i], btn.Unique, btn.Data)
+				}
+			}
+		})
+	}
+
+	t.Run("not forwarded", func(t *testing.T) {
+		setupTest(t)
+
+		ctx := newCommand(testReaderID, testReaderID, "hello", nil)
+
+		if err := ForwardedHandler(ctx); err != nil || len(ctx.replies) != 0 {
+			t.Errorf("expected no reply, got %q", ctx.replies)
+		}
+	})
+
+	t.Run("recall by reply", func(t *testing.T) {
+		setupTest(t, recordedTarget())
+
+		ctx := newCommand(testReaderID, testGroupID, "/recall", &tele.User{ID: testWriterID})
+		ctx.message.ReplyTo.OriginalUnixtime = 1
+		ctx.message.ReplyTo.OriginalSender = testTarget
+
+		if err := RecallHandler(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.Contains(ctx.last(), "<b>ID:</b> <code>2000</code>") {
+			t.Errorf("expected the original sender, got %q", ctx.last())
+		}
+	})
+}
+
+func TestQuickRegBtnHandler(t *testing.T) {
+	stranger := &tele.User{ID: 2500, FirstName: "Phoenix", LastName: "Wright", Username: "phoenix"}
+
+	setupTest(t)
+
+	ctx := newCallback(testWriterID, BTN_QUICK_REG, "2500")
+	ctx.message.ReplyTo = &tele.Message{OriginalUnixtime: 1, OriginalSender: stranger}
+
+	if err := QuickRegBtnHandler(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if ctx.last() != "[<code>2500</code>] User registered." {
+		t.Fatalf("unexpected reply %q", ctx.last())
+	}
+
+	user := mustFind(t, 2500)
+
+	if len(user.Names) != 1 || user.Names[0] != "Phoenix Wright" || len(user.Usernames) != 1 || user.Usernames[0] != "phoenix" {
+		t.Errorf("expected the forward's names, got %v %v", user.Names, user.Usernames)
+	}
+
+	if markup := ctx.lastMarkup(); markup == nil || len(markup.InlineKeyboard[0]) != 1 || markup.InlineKeyboard[0][0].Unique != BTN_QUICK_RECORD {
+		t.Errorf("expected only the record button, got %+v", markup)
+	}
+
+	ctx = newCallback(testWriterID, BTN_QUICK_REG, "2500")
+	QuickRegBtnHandler(ctx)
+
+	if ctx.last() != "User is already registered." {
+		t.Errorf("expected duplicate to be refused, got %q", ctx.last())
+	}
+}
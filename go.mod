@@ -4,6 +4,7 @@ go 1.18
 
 require (
 	github.com/joho/godotenv v1.4.0
+	go.etcd.io/bbolt v1.3.6
 	go.mongodb.org/mongo-driver v1.10.0
 )
 
@@ -19,5 +20,6 @@ require (
 	github.com/youmark/pkcs8 v0.0.0-20181117223130-1be2e3e5546d // indirect
 	golang.org/x/crypto v0.0.0-20220622213112-05595931fe9d // indirect
 	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c // indirect
+	golang.org/x/sys v0.0.0-20220114195835-da31bd327af9 // indirect
 	golang.org/x/text v0.3.7 // indirect
 )
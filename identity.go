@@ -0,0 +1,431 @@
+package main
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tele "github.com/Henry96Markle/telebot"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var (
+	// The last identity observed for each sender, so that the database is only queried when it changes.
+	observedIdentities = NewIdentityCache(IDENTITY_CACHE_SIZE, IDENTITY_CACHE_TTL)
+
+	// The senders waiting for the tracker to look them up; nil until it's started.
+	identityQueue chan *tele.User
+)
+
+func NewIdentityCache(size int, ttl time.Duration) *IdentityCache {
+	return &IdentityCache{
+		mutex:   &sync.Mutex{},
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: map[int64]*list.Element{},
+	}
+}
+
+// Reports whether the sender was last observed with the given identity, recently enough.
+func (c *IdentityCache) Seen(id int64, key string, now time.Time) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	el, ok := c.entries[id]
+
+	if !ok {
+		return false
+	}
+
+	entry := el.Value.(*observedIdentity)
+
+	if now.Sub(entry.at) > c.ttl {
+		c.order.Remove(el)
+		delete(c.entries, id)
+
+		return false
+	}
+
+	return entry.key == key
+}
+
+// Remembers the identity a sender was observed with, dropping the least recently observed sender if
+// the cache is full.
+func (c *IdentityCache) Put(id int64, key string, now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if el, ok := c.entries[id]; ok {
+		el.Value = &observedIdentity{id: id, key: key, at: now}
+		c.order.MoveToFront(el)
+
+		return
+	}
+
+	c.entries[id] = c.order.PushFront(&observedIdentity{id: id, key: key, at: now})
+
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*observedIdentity).id)
+	}
+}
+
+func (c *IdentityCache) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.order.Len()
+}
+
+// Starts the goroutine that looks up the senders queued by TrackIdentities, so that the poller never
+// waits on the database. The returned function stops it, once the queued senders are done.
+func StartIdentityTracker() (stop func()) {
+	queue := make(chan *tele.User, IDENTITY_QUEUE_SIZE)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		for sender := range queue {
+			ObserveIdentity(sender)
+		}
+	}()
+
+	identityQueue = queue
+
+	return func() {
+		identityQueue = nil
+		close(queue)
+		<-done
+	}
+}
+
+// A poller filter that watches the messages of the groups the bot is in, and queues their senders for
+// the tracker, which keeps the names and usernames of registered users up to date. It never filters
+// anything out.
+func TrackIdentities(u *tele.Update) bool {
+	if u.Message == nil || u.Message.Chat == nil || u.Message.Sender == nil || u.Message.Sender.IsBot ||
+		(u.Message.Chat.Type != tele.ChatGroup && u.Message.Chat.Type != tele.ChatSuperGroup) {
+		return true
+	}
+
+	sender := u.Message.Sender
+
+	if observedIdentities.Seen(sender.ID, identityKey(sender), time.Now()) {
+		return true
+	}
+
+	select {
+	case identityQueue <- sender:
+	default:
+		// The tracker is behind, or not started; the sender will be observed again.
+	}
+
+	return true
+}
+
+// The name and username of a sender, as they're remembered by the cache.
+func identityKey(sender *tele.User) string {
+	return strings.TrimSpace(sender.FirstName+" "+sender.LastName) + "\n" + sender.Username
+}
+
+// Appends the sender's name and username to the user they're registered as (directly or as an alias),
+// if they differ from the latest stored ones.
+func ObserveIdentity(sender *tele.User) {
+	if sender.IsBot {
+		return
+	}
+
+	var (
+		name     = strings.TrimSpace(sender.FirstName + " " + sender.LastName)
+		username = sender.Username
+		key      = identityKey(sender)
+	)
+
+	if observedIdentities.Seen(sender.ID, key, time.Now()) {
+		return
+	}
+
+	user, err := Data.FindByID(sender.ID)
+
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			log.Printf(ERR_FMT_QUERY+"\n", err)
+			return
+		}
+
+		users, filter_err := Data.Filter(bson.D{{Key: "alias_ids", Value: sender.ID}})
+
+		if filter_err != nil {
+			log.Printf(ERR_FMT_QUERY+"\n", filter_err)
+			return
+		}
+
+		if len(users) == 0 {
+			observedIdentities.Put(sender.ID, key, time.Now())
+			return
+		}
+
+		user = users[0]
+	}
+
+	id := user.TelegramID
+
+	// Only a new name or username is written through UpdateUser, which bumps the version; being seen
+	// again only moves last_seen forward, at most once in IDENTITY_CACHE_TTL, without conflicting with
+	// the edits in progress.
+	if (name == "" || name == LastOf(user.Names)) && (username == "" || username == LastOf(user.Usernames)) {
+		now := time.Now()
+
+		for _, e := range user.History {
+			if (e.Kind == IDENTITY_NAME && e.Value == name) || (e.Kind == IDENTITY_USERNAME && e.Value == username) {
+				if now.Sub(e.LastSeen) < IDENTITY_CACHE_TTL {
+					continue
+				}
+
+				if err := Data.TouchIdentity(id, e.Kind, e.Value, now); err != nil {
+					log.Printf(ERR_FMT_UPDATE+"\n", err)
+					return
+				}
+			}
+		}
+
+		observedIdentities.Put(sender.ID, key, now)
+
+		return
+	}
+
+	before, after, err := UpdateUser(id, func(u *User) error {
+		now := time.Now()
+
+		NoteIdentity(u, IDENTITY_NAME, name, SOURCE_OBSERVED, 0, now)
+		NoteIdentity(u, IDENTITY_USERNAME, username, SOURCE_OBSERVED, 0, now)
+
+		return nil
+	})
+
+	if err != nil {
+		log.Printf(ERR_FMT_UPDATE+"\n", err)
+		return
+	}
+
+	observedIdentities.Put(sender.ID, key, time.Now())
+
+	AuditLog(0, id, AUDIT_NAMECHANGE, &before, &after)
+
+	// logging
+
+	identity := func(u User) string {
+		return strings.TrimSpace(LastOf(u.Names) + BoolToStr(LastOf(u.Usernames) != "", " (@"+LastOf(u.Usernames)+")", ""))
+	}
+
+	ChanLogf("#namechange\n[<code>%d</code>] %s has been observed as %s; registered as ID <code>%d</code>.",
+		sender.ID,
+		BoolToStr(identity(before) != "", identity(before), "An unnamed user"),
+		identity(after),
+		id,
+	)
+}
+
+// Notes that a user is known by an identity at the given time: its history entry is created or brought
+// up to date, and the value becomes the current one in Names, Usernames or AliasIDs.
+func NoteIdentity(u *User, kind string, value string, source string, by int64, at time.Time) {
+	if value == "" {
+		return
+	}
+
+	switch kind {
+	case IDENTITY_NAME:
+		u.Names = append(Undupe(u.Names, []string{value}), value)
+	case IDENTITY_USERNAME:
+		u.Usernames = append(Undupe(u.Usernames, []string{value}), value)
+	case IDENTITY_ALIAS:
+		id, err := strconv.ParseInt(value, 0, 64)
+
+		if err != nil {
+			return
+		}
+
+		u.AliasIDs = append(Undupe(u.AliasIDs, []int64{id}), id)
+	}
+
+	MergeHistory(u, IdentityEntry{
+		Kind:      kind,
+		Value:     value,
+		FirstSeen: at,
+		LastSeen:  at,
+		Source:    source,
+		AddedBy:   by,
+	})
+}
+
+// Removes an identity that was attributed to a user by mistake, history included.
+func ForgetIdentity(u *User, kind string, value string) {
+	switch kind {
+	case IDENTITY_NAME:
+		u.Names = Undupe(u.Names, []string{value})
+	case IDENTITY_USERNAME:
+		u.Usernames = Undupe(u.Usernames, []string{value})
+	case IDENTITY_ALIAS:
+		if id, err := strconv.ParseInt(value, 0, 64); err == nil {
+			u.AliasIDs = Undupe(u.AliasIDs, []int64{id})
+		}
+	}
+
+	history := make([]IdentityEntry, 0, len(u.History))
+
+	for _, e := range u.History {
+		if e.Kind != kind || e.Value != value {
+			history = append(history, e)
+		}
+	}
+
+	u.History = history
+}
+
+// Adds history entries to a user. An entry the user already has is widened to cover both periods,
+// and keeps its original source.
+func MergeHistory(u *User, entries ...IdentityEntry) {
+outer:
+	for _, entry := range entries {
+		for i := range u.History {
+			e := &u.History[i]
+
+			if e.Kind != entry.Kind || e.Value != entry.Value {
+				continue
+			}
+
+			if entry.FirstSeen.Before(e.FirstSeen) {
+				e.FirstSeen = entry.FirstSeen
+			}
+
+			if entry.LastSeen.After(e.LastSeen) {
+				e.LastSeen = entry.LastSeen
+			}
+
+			continue outer
+		}
+
+		u.History = append(u.History, entry)
+	}
+}
+
+// Adds a history entry for every name, username and alias ID of a user that doesn't have one yet,
+// as documents written before the history existed. Their dates are unknown; the date the user was
+// registered is used instead, or that of their earliest record if the document ID doesn't tell it.
+// Otherwise, the dates are left unset. Reports whether anything was added.
+func MigrateHistory(u *User) bool {
+	var (
+		count = len(u.History)
+		at    = RegistrationDate(*u)
+
+		add = func(kind string, value string) {
+			for _, e := range u.History {
+				if e.Kind == kind && e.Value == value {
+					return
+				}
+			}
+
+			u.History = append(u.History, IdentityEntry{
+				Kind:      kind,
+				Value:     value,
+				FirstSeen: at,
+				LastSeen:  at,
+				Source:    SOURCE_MIGRATION,
+			})
+		}
+	)
+
+	for _, n := range u.Names {
+		add(IDENTITY_NAME, n)
+	}
+
+	for _, n := range u.Usernames {
+		add(IDENTITY_USERNAME, n)
+	}
+
+	for _, id := range u.AliasIDs {
+		add(IDENTITY_ALIAS, strconv.FormatInt(id, 10))
+	}
+
+	return len(u.History) != count
+}
+
+// Tells when a user was registered, as well as it's known: the time their document ID was made, or that of
+// their earliest record, for stores that don't make IDs. It's zero if neither is known.
+func RegistrationDate(u User) time.Time {
+	if !u.ID.IsZero() {
+		return u.ID.Timestamp()
+	}
+
+	var earliest time.Time
+
+	for _, records := range u.Records {
+		for _, r := range records {
+			if earliest.IsZero() || r.Date.Before(earliest) {
+				earliest = r.Date
+			}
+		}
+	}
+
+	return earliest
+}
+
+// Formats a date of the identity history, which may be unknown.
+func HistoryDate(t time.Time) string {
+	if t.IsZero() {
+		return "unknown date"
+	}
+
+	return t.UTC().Format("2006-01-02")
+}
+
+// Renders the identity history of a user in chronological order.
+func IdentityTimeline(u User) string {
+	history := append([]IdentityEntry{}, u.History...)
+
+	sort.SliceStable(history, func(i, j int) bool {
+		if !history[i].FirstSeen.Equal(history[j].FirstSeen) {
+			return history[i].FirstSeen.Before(history[j].FirstSeen)
+		}
+
+		return history[i].LastSeen.Before(history[j].LastSeen)
+	})
+
+	lines := make([]string, 0, len(history))
+
+	for _, e := range history {
+		var (
+			first = HistoryDate(e.FirstSeen)
+			last  = HistoryDate(e.LastSeen)
+
+			value = html.EscapeString(e.Value)
+		)
+
+		if e.Kind != IDENTITY_NAME {
+			value = "<code>" + value + "</code>"
+		}
+
+		lines = append(lines, fmt.Sprintf(
+			"%s%s: %s %s (%s%s)",
+			first,
+			BoolToStr(first != last, " → "+last, ""),
+			strings.ReplaceAll(e.Kind, "_", " "),
+			value,
+			e.Source,
+			BoolToStr(e.AddedBy != 0, fmt.Sprintf(", by <code>%d</code>", e.AddedBy), ""),
+		))
+	}
+
+	return strings.Join(lines, "\n\t- ")
+}
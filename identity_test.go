@@ -0,0 +1,209 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	tele "github.com/Henry96Markle/telebot"
+)
+
+func TestTrackIdentities(t *testing.T) {
+	target := recordedTarget()
+	target.AliasIDs = []int64{3001}
+
+	message := func(sender *tele.User, chat tele.ChatType) *tele.Update {
+		return &tele.Update{Message: &tele.Message{Sender: sender, Chat: &tele.Chat{ID: testGroupID, Type: chat}}}
+	}
+
+	tests := []struct {
+		name      string
+		sender    *tele.User
+		chat      tele.ChatType
+		names     []string
+		usernames []string
+	}{
+		{
+			name:      "unchanged",
+			sender:    &tele.User{ID: testTargetID, FirstName: "Miles", LastName: "Edgeworth", Username: "miles"},
+			chat:      tele.ChatSuperGroup,
+			names:     []string{"Miles Edgeworth"},
+			usernames: []string{"miles"},
+		},
+		{
+			name:      "new name",
+			sender:    &tele.User{ID: testTargetID, FirstName: "Edgey", Username: "miles"},
+			chat:      tele.ChatGroup,
+			names:     []string{"Miles Edgeworth", "Edgey"},
+			usernames: []string{"miles"},
+		},
+		{
+			name:      "alias",
+			sender:    &tele.User{ID: 3001, FirstName: "Miles", LastName: "Edgeworth", Username: "edgeworth"},
+			chat:      tele.ChatSuperGroup,
+			names:     []string{"Miles Edgeworth"},
+			usernames: []string{"miles", "edgeworth"},
+		},
+		{
+			name:      "private chat",
+			sender:    &tele.User{ID: testTargetID, FirstName: "Edgey"},
+			chat:      tele.ChatPrivate,
+			names:     []string{"Miles Edgeworth"},
+			usernames: []string{"miles"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTest(t, target)
+			stop := StartIdentityTracker()
+
+			if !TrackIdentities(message(tt.sender, tt.chat)) {
+				t.Fatalf("expected the update to go through")
+			}
+
+			stop()
+
+			u := mustFind(t, testTargetID)
+
+			if strings.Join(u.Names, "|") != strings.Join(tt.names, "|") {
+				t.Errorf("expected names %v, got %v", tt.names, u.Names)
+			}
+
+			if strings.Join(u.Usernames, "|") != strings.Join(tt.usernames, "|") {
+				t.Errorf("expected usernames %v, got %v", tt.usernames, u.Usernames)
+			}
+		})
+	}
+
+	t.Run("reused name", func(t *testing.T) {
+		setupTest(t, target)
+
+		ObserveIdentity(&tele.User{ID: testTargetID, FirstName: "Edgey", Username: "miles"})
+		ObserveIdentity(&tele.User{ID: testTargetID, FirstName: "Miles", LastName: "Edgeworth", Username: "miles"})
+
+		if u := mustFind(t, testTargetID); strings.Join(u.Names, "|") != "Edgey|Miles Edgeworth" {
+			t.Errorf("expected the name to be moved to the end, got %v", u.Names)
+		}
+
+		if entries, count, _ := Data.FindAudit(testTargetID, AUDIT_NAMECHANGE, time.Time{}, 0, 10); count != 2 || entries[0].Actor != 0 {
+			t.Errorf("expected 2 audit entries by the bot, got %d %+v", count, entries)
+		}
+	})
+
+	t.Run("seen again", func(t *testing.T) {
+		seen := target
+		long_ago := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+		seen.History = []IdentityEntry{
+			{Kind: IDENTITY_NAME, Value: "Miles Edgeworth", FirstSeen: long_ago, LastSeen: long_ago},
+			{Kind: IDENTITY_USERNAME, Value: "miles", FirstSeen: long_ago, LastSeen: long_ago},
+		}
+
+		setupTest(t, seen)
+
+		before := mustFind(t, testTargetID)
+
+		ObserveIdentity(&tele.User{ID: testTargetID, FirstName: "Miles", LastName: "Edgeworth", Username: "miles"})
+
+		after := mustFind(t, testTargetID)
+
+		if after.Version != before.Version {
+			t.Errorf("expected the version to stay %d, got %d", before.Version, after.Version)
+		}
+
+		for _, e := range after.History {
+			if !e.LastSeen.After(long_ago) {
+				t.Errorf("expected %s %q to be seen again, got %v", e.Kind, e.Value, e.LastSeen)
+			}
+		}
+
+		if _, count, _ := Data.FindAudit(testTargetID, AUDIT_NAMECHANGE, time.Time{}, 0, 10); count != 0 {
+			t.Errorf("expected no audit entries, got %d", count)
+		}
+	})
+}
+
+func TestMigrateHistoryUnknownDate(t *testing.T) {
+	bare := newTestUser(4000, 0)
+	bare.Names = []string{"Larry"}
+
+	MigrateHistory(&bare)
+
+	if !bare.History[0].FirstSeen.IsZero() || !strings.HasPrefix(IdentityTimeline(bare), "unknown date: name Larry") {
+		t.Errorf("expected an unknown date, got %q", IdentityTimeline(bare))
+	}
+}
+
+func TestIdentityCache(t *testing.T) {
+	var (
+		now   = time.Now()
+		cache = NewIdentityCache(2, time.Hour)
+	)
+
+	cache.Put(1, "a", now)
+	cache.Put(2, "b", now)
+
+	if !cache.Seen(1, "a", now) || cache.Seen(2, "c", now) {
+		t.Fatalf("expected only the stored identities to be seen")
+	}
+
+	// 2 is the least recently observed now.
+	cache.Put(1, "a", now)
+	cache.Put(3, "c", now)
+
+	if cache.Len() != 2 || cache.Seen(2, "b", now) || !cache.Seen(1, "a", now) {
+		t.Errorf("expected the least recently observed sender to be dropped")
+	}
+
+	if cache.Seen(3, "c", now.Add(2*time.Hour)) || cache.Len() != 1 {
+		t.Errorf("expected an expired identity to be dropped")
+	}
+}
+
+func TestIdentityHistory(t *testing.T) {
+	var (
+		jan = time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+		mar = time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)
+
+		u = recordedTarget()
+	)
+
+	u.AliasIDs = []int64{3001}
+
+	if !MigrateHistory(&u) || len(u.History) != 3 || MigrateHistory(&u) {
+		t.Fatalf("expected one entry per identity, added once, got %v", u.History)
+	}
+
+	for _, e := range u.History {
+		if e.Source != SOURCE_MIGRATION || !e.FirstSeen.Equal(jan) {
+			t.Errorf("unexpected migrated entry %v", e)
+		}
+	}
+
+	NoteIdentity(&u, IDENTITY_NAME, "Edgey", SOURCE_MANUAL, testWriterID, mar)
+	NoteIdentity(&u, IDENTITY_NAME, "Edgey", SOURCE_OBSERVED, 0, jan)
+
+	if LastOf(u.Names) != "Edgey" || len(u.History) != 4 {
+		t.Fatalf("expected a new current name, got %v", u.Names)
+	}
+
+	if e := LastOf(u.History); !e.FirstSeen.Equal(jan) || !e.LastSeen.Equal(mar) || e.Source != SOURCE_MANUAL || e.AddedBy != testWriterID {
+		t.Errorf("expected the entry to cover both sightings, got %v", e)
+	}
+
+	ForgetIdentity(&u, IDENTITY_ALIAS, "3001")
+
+	if len(u.AliasIDs) != 0 || len(u.History) != 3 {
+		t.Errorf("expected the alias to be forgotten, got %v and %v", u.AliasIDs, u.History)
+	}
+
+	timeline := IdentityTimeline(u)
+
+	if !strings.HasSuffix(timeline, "\n\t- 2022-01-01 → 2022-03-01: name Edgey (manual, by <code>1002</code>)") {
+		t.Errorf("unexpected timeline %q", timeline)
+	}
+
+	if !strings.Contains(DisplayUser(&u), "Identity timeline:") {
+		t.Errorf("expected the profile to show the timeline")
+	}
+}
@@ -0,0 +1,544 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	tele "github.com/Henry96Markle/telebot"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Adds what src knows of a user to dst: identities dst lacks, a description if it has none, and
+// the records it lacks. Permission levels are never imported. Returns how many records were added.
+func ImportInto(dst *User, src User, by int64, at time.Time) int {
+	for _, n := range Undupe(src.Names, dst.Names) {
+		NoteIdentity(dst, IDENTITY_NAME, n, SOURCE_IMPORT, by, at)
+	}
+
+	for _, n := range Undupe(src.Usernames, dst.Usernames) {
+		NoteIdentity(dst, IDENTITY_USERNAME, n, SOURCE_IMPORT, by, at)
+	}
+
+	for _, a := range Undupe(src.AliasIDs, dst.AliasIDs) {
+		NoteIdentity(dst, IDENTITY_ALIAS, strconv.FormatInt(a, 10), SOURCE_IMPORT, by, at)
+	}
+
+	if dst.Description == "" {
+		dst.Description = src.Description
+	}
+
+	if dst.Records == nil {
+		dst.Records = map[string][]Record{}
+	}
+
+	return MergeRecords(dst.Records, src.Records)
+}
+
+// Parses an import file, in the CSV or JSON layout of /export, telling them apart by the file name.
+// The users are checked, and those appearing more than once are combined.
+func ParseImport(r io.Reader, fileName string) ([]User, error) {
+	var (
+		users []User
+		err   error
+	)
+
+	switch strings.ToLower(path.Ext(fileName)) {
+	case ".csv":
+		users, err = parseImportCSV(r)
+	case ".json":
+		users, err = parseImportJSON(r)
+	default:
+		return nil, errors.New("only .csv and .json files can be imported")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	combined := make([]User, 0, len(users))
+	index := map[int64]int{}
+
+	for _, u := range users {
+		if u.TelegramID == 0 {
+			return nil, errors.New("a user has no ID")
+		}
+
+		for _, a := range u.AliasIDs {
+			if a == 0 || a == u.TelegramID {
+				return nil, fmt.Errorf("ID %d has an invalid alias ID %d", u.TelegramID, a)
+			}
+		}
+
+		for category, records := range u.Records {
+			if strings.TrimSpace(category) == "" {
+				return nil, fmt.Errorf("ID %d has records without a category", u.TelegramID)
+			}
+
+			for i := range records {
+				if len(records[i].Notes) == 0 {
+					return nil, fmt.Errorf("ID %d has a \"%s\" record without notes", u.TelegramID, category)
+				}
+
+				if records[i].Date.IsZero() {
+					records[i].Date = time.Now()
+				}
+			}
+		}
+
+		if i, ok := index[u.TelegramID]; ok {
+			ImportInto(&combined[i], u, 0, time.Now())
+			continue
+		}
+
+		index[u.TelegramID] = len(combined)
+		combined = append(combined, User{
+			TelegramID:  u.TelegramID,
+			Names:       u.Names,
+			Usernames:   Map(u.Usernames, func(s string) (string, error) { return TrimUsername(s), nil }),
+			AliasIDs:    u.AliasIDs,
+			Description: u.Description,
+			Records:     u.Records,
+		})
+	}
+
+	if len(combined) == 0 {
+		return nil, errors.New("the file has no users")
+	}
+
+	return combined, nil
+}
+
+// Reads one user, or a list of them, as /export writes them.
+func parseImportJSON(r io.Reader) ([]User, error) {
+	raw, err := io.ReadAll(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	users := []User{}
+
+	if err = json.Unmarshal(raw, &users); err == nil {
+		return users, nil
+	}
+
+	user := User{}
+
+	if err = json.Unmarshal(raw, &user); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	return []User{user}, nil
+}
+
+// Reads the rows /export writes, for any number of users. Record notes are joined as JoinNotes does. The
+// provenance columns of records may be left out, as files exported before they existed do.
+func parseImportCSV(r io.Reader) ([]User, error) {
+	// Every row must have as many fields as the header.
+	reader := csv.NewReader(r)
+
+	header, err := reader.Read()
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV: %w", err)
+	}
+
+	if len(header) < EXPORT_CSV_REQUIRED || len(header) > len(EXPORT_CSV_HEADER) ||
+		strings.Join(header, ",") != strings.Join(EXPORT_CSV_HEADER[:len(header)], ",") {
+		return nil, fmt.Errorf("the header must be \"%s\"", strings.Join(EXPORT_CSV_HEADER, ","))
+	}
+
+	// Parses a column that may be missing or empty.
+	optional := func(row []string, column int, parse func(string) error) error {
+		if column >= len(row) || row[column] == "" {
+			return nil
+		}
+
+		return parse(row[column])
+	}
+
+	users := []User{}
+	index := map[int64]int{}
+
+	for line := 2; ; line++ {
+		row, err := reader.Read()
+
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %w", err)
+		}
+
+		id, parse_err := strconv.ParseInt(row[0], 0, 64)
+
+		if parse_err != nil || id == 0 {
+			return nil, fmt.Errorf("line %d: invalid ID \"%s\"", line, row[0])
+		}
+
+		i, ok := index[id]
+
+		if !ok {
+			i = len(users)
+			index[id] = i
+			users = append(users, User{TelegramID: id, Records: map[string][]Record{}})
+		}
+
+		u := &users[i]
+		field, category, value := row[1], strings.TrimSpace(row[2]), strings.TrimSpace(row[3])
+
+		switch field {
+		case "name":
+			u.Names = append(u.Names, value)
+		case "username":
+			u.Usernames = append(u.Usernames, value)
+		case "alias_id":
+			alias, parse_err := strconv.ParseInt(value, 0, 64)
+
+			if parse_err != nil {
+				return nil, fmt.Errorf("line %d: invalid alias ID \"%s\"", line, value)
+			}
+
+			u.AliasIDs = append(u.AliasIDs, alias)
+		case "description":
+			u.Description = value
+		case "permission_level":
+			// Permission levels are only ever set with /perm.
+		case "record":
+			r := Record{Notes: SplitEscapedNotes(value)}
+
+			if row[4] != "" {
+				if r.Date, parse_err = time.Parse(time.RFC3339, row[4]); parse_err != nil {
+					if r.Date, parse_err = time.Parse("2006-01-02", row[4]); parse_err != nil {
+						return nil, fmt.Errorf("line %d: invalid date \"%s\"", line, row[4])
+					}
+				}
+			}
+
+			if row[5] != "" {
+				if r.ChatID, parse_err = strconv.ParseInt(row[5], 0, 64); parse_err != nil {
+					return nil, fmt.Errorf("line %d: invalid chat ID \"%s\"", line, row[5])
+				}
+			}
+
+			if optional(row, 6, func(s string) (err error) { r.Author, err = strconv.ParseInt(s, 0, 64); return }) != nil ||
+				optional(row, 7, func(s string) (err error) { r.MessageID, err = strconv.Atoi(s); return }) != nil ||
+				optional(row, 8, func(s string) (err error) { r.ReplyTo, err = strconv.Atoi(s); return }) != nil {
+				return nil, fmt.Errorf("line %d: invalid author or message ID", line)
+			}
+
+			if len(row) > 9 {
+				r.Link = row[9]
+			}
+
+			if category == "" || len(r.Notes) == 0 {
+				return nil, fmt.Errorf("line %d: a record needs a category and notes", line)
+			}
+
+			u.Records[category] = append(u.Records[category], r)
+		default:
+			return nil, fmt.Errorf("line %d: unknown field \"%s\"", line, field)
+		}
+	}
+
+	return users, nil
+}
+
+// Splits notes joined by JoinNotes. A "\" keeps the ";" or "\" after it from being read as such; any
+// other one is kept as it is, as files written before the escaping may have them.
+func SplitEscapedNotes(s string) []string {
+	var (
+		notes = make([]string, 0)
+		note  = strings.Builder{}
+		runes = []rune(s)
+
+		flush = func() {
+			if n := strings.TrimSpace(note.String()); n != "" {
+				notes = append(notes, n)
+			}
+
+			note.Reset()
+		}
+	)
+
+	for i := 0; i < len(runes); i++ {
+		switch {
+		case runes[i] == '\\' && i+1 < len(runes) && (runes[i+1] == ';' || runes[i+1] == '\\'):
+			i++
+			note.WriteRune(runes[i])
+		case runes[i] == ';':
+			flush()
+		default:
+			note.WriteRune(runes[i])
+		}
+	}
+
+	flush()
+
+	return notes
+}
+
+// Sorts the imported users into those to register and those already registered, which only get what
+// they lack. Users whose IDs clash with another user's ID or alias IDs are left out as conflicts.
+func PlanImport(imported []User, current []User) ImportPlan {
+	plan := ImportPlan{}
+	owners := map[int64]int64{}
+	registered := map[int64]User{}
+
+	for _, u := range current {
+		registered[u.TelegramID] = u
+		owners[u.TelegramID] = u.TelegramID
+
+		for _, a := range u.AliasIDs {
+			owners[a] = u.TelegramID
+		}
+	}
+
+	for _, u := range imported {
+		conflict := ""
+
+		if owner, ok := owners[u.TelegramID]; ok && owner != u.TelegramID {
+			conflict = fmt.Sprintf("<code>%d</code> is an alias ID of <code>%d</code>", u.TelegramID, owner)
+		}
+
+		for _, a := range u.AliasIDs {
+			if owner, ok := owners[a]; ok && owner != u.TelegramID && conflict == "" {
+				conflict = fmt.Sprintf("alias ID <code>%d</code> of <code>%d</code> belongs to <code>%d</code>", a, u.TelegramID, owner)
+			}
+		}
+
+		if conflict != "" {
+			plan.Conflicts = append(plan.Conflicts, conflict)
+			continue
+		}
+
+		if cur, ok := registered[u.TelegramID]; ok {
+			merged, _ := cloneUser(cur)
+
+			if n := ImportInto(&merged, u, 0, time.Now()); n > 0 || !SameUser(cur, merged) {
+				plan.Update = append(plan.Update, u)
+				plan.Records += n
+			} else {
+				plan.Unchanged++
+			}
+		} else {
+			plan.Add = append(plan.Add, u)
+		}
+
+		owners[u.TelegramID] = u.TelegramID
+
+		for _, a := range u.AliasIDs {
+			owners[a] = u.TelegramID
+		}
+	}
+
+	return plan
+}
+
+// Imports the users on behalf of actor, against the users stored now. The new users are added in one
+// batch; the registered ones are updated one by one, and those that fail are only counted.
+func ApplyImport(actor int64, users []User) (plan ImportPlan, failed int, err error) {
+	current, err := Data.GetAll()
+
+	if err != nil {
+		return plan, 0, err
+	}
+
+	plan = PlanImport(users, current)
+
+	var (
+		now = time.Now()
+		add = make([]User, 0, len(plan.Add))
+	)
+
+	for _, u := range plan.Add {
+		user := User{
+			ID:         primitive.NewObjectID(),
+			TelegramID: u.TelegramID,
+			Names:      make([]string, 0, len(u.Names)),
+			Usernames:  make([]string, 0, len(u.Usernames)),
+			AliasIDs:   make([]int64, 0, len(u.AliasIDs)),
+			Records:    map[string][]Record{},
+		}
+
+		ImportInto(&user, u, actor, now)
+		add = append(add, user)
+	}
+
+	// The new users go in one batch, so that a failure leaves none of them behind.
+	if len(add) > 0 {
+		if err = Data.Add(add...); err != nil {
+			return plan, 0, err
+		}
+	}
+
+	for i := range add {
+		AuditLog(actor, add[i].TelegramID, AUDIT_IMPORT, nil, &add[i])
+	}
+
+	for _, u := range plan.Update {
+		before, after, err := UpdateUser(u.TelegramID, func(dst *User) error {
+			ImportInto(dst, u, actor, now)
+			return nil
+		})
+
+		if err != nil {
+			log.Printf(ERR_FMT_UPDATE+"\n", err)
+			failed++
+			continue
+		}
+
+		AuditLog(actor, u.TelegramID, AUDIT_IMPORT, &before, &after)
+	}
+
+	return plan, failed, nil
+}
+
+// Describes a plan, for the sender to confirm or for the result of an import.
+func ImportPlanToStr(plan ImportPlan) string {
+	plural := func(n int) string { return BoolToStr(n != 1, "s", "") }
+
+	lines := []string{
+		fmt.Sprintf("register %d new user%s", len(plan.Add), plural(len(plan.Add))),
+		fmt.Sprintf("update %d registered user%s, with %d record%s", len(plan.Update), plural(len(plan.Update)), plan.Records, plural(plan.Records)),
+		fmt.Sprintf("leave %d registered user%s unchanged", plan.Unchanged, plural(plan.Unchanged)),
+	}
+
+	if len(plan.Conflicts) > 0 {
+		shown := plan.Conflicts
+
+		if len(shown) > IMPORT_CONFLICTS_SHOWN {
+			shown = shown[:IMPORT_CONFLICTS_SHOWN]
+		}
+
+		lines = append(lines, fmt.Sprintf("skip %d conflicting user%s:\n\t\t- %s%s",
+			len(plan.Conflicts),
+			plural(len(plan.Conflicts)),
+			strings.Join(shown, "\n\t\t- "),
+			BoolToStr(len(plan.Conflicts) > len(shown), fmt.Sprintf("\n\t\t..and %d more.", len(plan.Conflicts)-len(shown)), ""),
+		))
+	}
+
+	return "\t- " + strings.Join(lines, "\n\t- ")
+}
+
+// Syntax:
+//
+//	- /import <reply-to-file>
+func ImportHandler(c tele.Context) error {
+	if _, args_err := ParseArgs(c, CommandSpecs[CMD_IMPORT]); args_err != nil {
+		return ReplyArgsErr(c, CommandSpecs[CMD_IMPORT], args_err)
+	}
+
+	if c.Message().ReplyTo == nil || c.Message().ReplyTo.Document == nil {
+		return c.Reply("Reply to a CSV or JSON file to import it.")
+	}
+
+	doc := c.Message().ReplyTo.Document
+
+	if doc.FileSize > IMPORT_MAX_SIZE {
+		return c.Reply(fmt.Sprintf("The file is too large; the limit is %d MB.", IMPORT_MAX_SIZE>>20))
+	}
+
+	r, download_err := Download(c, &doc.File)
+
+	if download_err != nil {
+		log.Printf("error downloading import: %v\n", download_err)
+		return c.Reply(MSG_COULD_NOT_PERFORM)
+	}
+
+	defer r.Close()
+
+	users, parse_err := ParseImport(io.LimitReader(r, IMPORT_MAX_SIZE), doc.FileName)
+
+	if parse_err != nil {
+		return c.Reply("Invalid file: " + parse_err.Error() + ".")
+	}
+
+	current, data_err := Data.GetAll()
+
+	if data_err != nil {
+		log.Printf(ERR_FMT_QUERY+"\n", data_err)
+		return c.Reply(MSG_COULD_NOT_PERFORM)
+	}
+
+	plan := PlanImport(users, current)
+	summary := fmt.Sprintf("%d user%s read. The import would:\n\n%s", len(users), BoolToStr(len(users) != 1, "s", ""), ImportPlanToStr(plan))
+
+	if len(plan.Add)+len(plan.Update) == 0 {
+		return c.Reply(summary+"\n\nThere is nothing to import.", tele.ModeHTML)
+	}
+
+	token, err := Results.Put(c.Sender().ID, PendingImport{Users: users}, RESULT_TTL)
+
+	if err != nil {
+		log.Printf("error keeping import: %v\n", err)
+		return c.Reply(MSG_COULD_NOT_PERFORM)
+	}
+
+	confirmBtn := *ConfirmImportBtn
+	confirmBtn.Data = token
+
+	return c.Reply(
+		summary+"\n\nProceed?",
+		tele.ModeHTML,
+		&tele.ReplyMarkup{
+			InlineKeyboard: [][]tele.InlineButton{
+				{*CancelBtn.Inline(), *confirmBtn.Inline()},
+			},
+		},
+	)
+}
+
+func ConfirmImportBtnHandler(c tele.Context) error {
+	token := c.Callback().Data
+	result, ok := Results.Get(token)
+	pending, is_pending := result.Value.(PendingImport)
+
+	if !ok || !is_pending {
+		return c.Edit("This import has expired; reply to the file again.")
+	}
+
+	if result.Owner != c.Sender().ID {
+		return c.Respond(&tele.CallbackResponse{Text: "Only the one who asked for this import can confirm it."})
+	}
+
+	Results.Delete(token)
+
+	// The users are read again, since they may have changed since the confirmation was asked.
+
+	plan, failed, err := ApplyImport(c.Sender().ID, pending.Users)
+
+	if errors.Is(err, ErrDuplicate) {
+		return c.Edit(MSG_CONFLICT)
+	} else if err != nil {
+		log.Printf("error importing users: %v\n", err)
+		return c.Edit(MSG_COULD_NOT_PERFORM)
+	}
+
+	// logging
+
+	name := c.Sender().FirstName + " " + c.Sender().LastName
+
+	ChanLogf("#import\n[<code>%d</code>] %shas imported a file:\n\n%s",
+		c.Sender().ID,
+		BoolToStr(name != "", name+" ", ""),
+		ImportPlanToStr(plan),
+	)
+
+	// returning
+
+	if failed > 0 {
+		return c.Edit(fmt.Sprintf("Imported, but %d registered user%s could not be updated:\n\n%s",
+			failed, BoolToStr(failed != 1, "s", ""), ImportPlanToStr(plan)), tele.ModeHTML)
+	}
+
+	return c.Edit("Imported:\n\n"+ImportPlanToStr(plan), tele.ModeHTML)
+}
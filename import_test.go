@@ -0,0 +1,200 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	tele "github.com/Henry96Markle/telebot"
+)
+
+const importHeader = "tg_id,field,category,value,date,chat_id\n"
+
+func importCommand(t *testing.T, sender int64, fileName string, content string) *fakeContext {
+	fakeDownload(t, []byte(content))
+
+	c := newCommand(sender, sender, "/import", &tele.User{ID: sender})
+	c.message.ReplyTo.Document = &tele.Document{FileName: fileName, File: tele.File{FileSize: len(content)}}
+
+	return c
+}
+
+func TestParseImport(t *testing.T) {
+	tests := []struct {
+		name     string
+		fileName string
+		content  string
+		users    int
+		err      string
+	}{
+		{
+			name:     "csv",
+			fileName: "list.CSV",
+			content: importHeader +
+				"2000,name,,Miles Edgeworth,,\n" +
+				"2000,username,,@miles,,\n" +
+				"2000,permission_level,,4,,\n" +
+				"2000,record,bans,spam; links,2022-01-01,-100\n" +
+				"3000,record,bans,flood,,\n",
+			users: 2,
+		},
+		{"bad header", "list.csv", "id,what\n1,2\n", 0, "header"},
+		{"bad ID", "list.csv", importHeader + "abc,name,,x,,\n", 0, "line 2: invalid ID"},
+		{"bad date", "list.csv", importHeader + "2000,record,bans,x,yesterday,\n", 0, "line 2: invalid date"},
+		{"no notes", "list.csv", importHeader + "2000,record,bans, ; ,,\n", 0, "category and notes"},
+		{"unknown field", "list.csv", importHeader + "2000,nickname,,x,,\n", 0, "unknown field"},
+		{"empty", "list.csv", importHeader, 0, "no users"},
+		{"json list", "list.json", `[{"tg_id": 2000}, {"tg_id": 3000}, {"tg_id": 2000, "names": ["Edgey"]}]`, 2, ""},
+		{"json user", "user-2000.json", `{"tg_id": 2000, "names": ["Edgey"]}`, 1, ""},
+		{"json alias of itself", "list.json", `{"tg_id": 2000, "alias_ids": [2000]}`, 0, "invalid alias ID"},
+		{"json without ID", "list.json", `{"names": ["Edgey"]}`, 0, "no ID"},
+		{"unknown type", "list.txt", "2000", 0, "only .csv and .json"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users, err := ParseImport(strings.NewReader(tt.content), tt.fileName)
+
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("expected error containing %q, got %v", tt.err, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(users) != tt.users {
+				t.Errorf("expected %d users, got %v", tt.users, users)
+			}
+		})
+	}
+}
+
+func TestImportHandler(t *testing.T) {
+	target := recordedTarget()
+	target.AliasIDs = []int64{3001}
+
+	content := importHeader +
+		"2000,record,bans,first,2022-01-01T00:00:00Z,-100\n" +
+		"2000,record,bans,new,2022-05-01T00:00:00Z,-100\n" +
+		"4000,name,,Franziska,,\n" +
+		"4000,permission_level,,4,,\n" +
+		"4000,record,warns,whip,,\n" +
+		"3001,record,bans,x,,\n" +
+		"5000,alias_id,,2000,,\n"
+
+	setupTest(t, target)
+
+	c := importCommand(t, testOperatorID, "list.csv", content)
+
+	if err := ImportHandler(c); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"4 users read",
+		"register 1 new user\n",
+		"update 1 registered user, with 1 record\n",
+		"skip 2 conflicting users",
+		"<code>3001</code> is an alias ID of <code>2000</code>",
+		"alias ID <code>2000</code> of <code>5000</code> belongs to <code>2000</code>",
+	} {
+		if !strings.Contains(c.last(), want) {
+			t.Errorf("expected the preview to contain %q, got %q", want, c.last())
+		}
+	}
+
+	// Nothing happens before the confirmation.
+	if _, err := Data.FindByID(4000); err == nil {
+		t.Fatal("expected no change before confirming")
+	}
+
+	data := c.lastMarkup().InlineKeyboard[0][1].Data
+
+	other := newCallback(testWriterID, BTN_CONFIRM_IMPORT, data)
+	ConfirmImportBtnHandler(other)
+
+	if len(other.responses) != 1 {
+		t.Error("expected only the sender to be able to confirm")
+	}
+
+	cb := newCallback(testOperatorID, BTN_CONFIRM_IMPORT, data)
+
+	if err := ConfirmImportBtnHandler(cb); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(cb.last(), "Imported") {
+		t.Fatalf("unexpected result %q", cb.last())
+	}
+
+	added := mustFind(t, 4000)
+
+	if added.Permission != 0 || LastOf(added.Names) != "Franziska" || len(added.Records["warns"]) != 1 {
+		t.Errorf("unexpected imported user %+v", added)
+	}
+
+	if len(added.History) != 1 || added.History[0].Source != SOURCE_IMPORT || added.History[0].AddedBy != testOperatorID {
+		t.Errorf("expected the name to be noted as imported, got %v", added.History)
+	}
+
+	if bans := mustFind(t, testTargetID).Records["bans"]; len(bans) != 3 {
+		t.Errorf("expected one record to be added, got %v", bans)
+	}
+
+	for _, id := range []int64{3001, 5000} {
+		if _, err := Data.FindByID(id); err == nil {
+			t.Errorf("expected conflicting ID %d to be skipped", id)
+		}
+	}
+
+	for _, id := range []int64{4000, testTargetID} {
+		if _, count, _ := Data.FindAudit(id, AUDIT_IMPORT, time.Time{}, 0, 1); count != 1 {
+			t.Errorf("expected an audit entry for %d, got %d", id, count)
+		}
+	}
+}
+
+func TestImportHandlerRejections(t *testing.T) {
+	setupTest(t, recordedTarget())
+
+	tests := []struct {
+		name     string
+		fileName string
+		content  string
+		want     string
+	}{
+		{"invalid file", "list.csv", "nope", "Invalid file"},
+		{"nothing new", "list.csv", importHeader + "2000,record,bans,first,2022-01-01T00:00:00Z,-100\n", "nothing to import"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := importCommand(t, testOperatorID, tt.fileName, tt.content)
+
+			if err := ImportHandler(c); err != nil {
+				t.Fatal(err)
+			}
+
+			if !strings.Contains(c.last(), tt.want) {
+				t.Errorf("expected %q, got %q", tt.want, c.last())
+			}
+		})
+	}
+
+	t.Run("no file", func(t *testing.T) {
+		c := newCommand(testOperatorID, testOperatorID, "/import", nil)
+
+		if err := ImportHandler(c); err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.Contains(c.last(), "Reply to a CSV or JSON file") {
+			t.Errorf("unexpected reply %q", c.last())
+		}
+	})
+}
@@ -16,15 +16,9 @@ import (
 	"github.com/joho/godotenv"
 )
 
-func init() {
-	TermSig = make(chan os.Signal, 1)
-	signal.Notify(TermSig, syscall.SIGINT, syscall.SIGTERM)
-
-	println("Initializing..")
-
-	flag.BoolVar(&Polling, "poll", false, "set the bot to polling mode")
-	flag.Parse()
-
+// Reads the configuration from the environment and returns the port to listen on. The bot's token
+// and logging settings are only required when serving.
+func loadConfig(serving bool) string {
 	// Get configuration
 
 	env_err := godotenv.Load("config.env")
@@ -39,12 +33,27 @@ func init() {
 	logging_to_channel_env, ok4 := os.LookupEnv("LOGGING_TO_CHAT")
 	log_channel_id_env, ok5 := os.LookupEnv("LOG_CHAT_ID")
 	port_env, ok6 := os.LookupEnv("PORT")
+	storage_env, ok7 := os.LookupEnv("STORAGE_BACKEND")
+	bolt_path_env, ok8 := os.LookupEnv("BOLT_PATH")
+	trash_retention_env, ok9 := os.LookupEnv("TRASH_RETENTION")
+	track_identities_env, ok10 := os.LookupEnv("TRACK_IDENTITIES")
+	capture_evidence_env, ok11 := os.LookupEnv("CAPTURE_EVIDENCE")
+	evidence_chat_id_env, ok12 := os.LookupEnv("EVIDENCE_CHAT_ID")
 
 	if !ok6 {
 		port_env = "80"
 	}
 
-	if !(ok1 && ok2 && ok3 && ok4) {
+	if !ok7 {
+		storage_env = STORAGE_MONGO
+	}
+
+	if !ok8 {
+		bolt_path_env = BOLT_DEFAULT_PATH
+	}
+
+	// The connection string is only needed by the MongoDB backend.
+	if !(ok1 && (ok2 || !serving) && (ok3 || storage_env != STORAGE_MONGO) && (ok4 || !serving)) {
 		log.Fatalf("FATAL: unable to acquire evironment variable(s): "+
 			"OWNER: %t, TOKEN: %t, CONNECTION_STRING: %t, LOGGING_TO_CHAT: %t\n", ok1, ok2, ok3, ok4)
 	}
@@ -55,20 +64,75 @@ func init() {
 
 	owner_id, owner_err := strconv.ParseInt(owner_env, 0, 64)
 
-	chan_id, chan_err := strconv.ParseInt(log_channel_id_env, 0, 64)
-
 	if owner_err != nil {
 		log.Fatalf("FATAL: error parsing owner ID: %v\n", owner_err)
 	}
 
-	if chan_err != nil {
-		log.Fatalf("FATAL: error parsing log channel ID: %v\n", chan_err)
+	var (
+		chan_id int64
+		doLog   bool
+	)
+
+	if ok5 {
+		var chan_err error
+
+		if chan_id, chan_err = strconv.ParseInt(log_channel_id_env, 0, 64); chan_err != nil {
+			log.Fatalf("FATAL: error parsing log channel ID: %v\n", chan_err)
+		}
+	}
+
+	if ok4 {
+		var bool_err error
+
+		if doLog, bool_err = strconv.ParseBool(logging_to_channel_env); bool_err != nil {
+			log.Fatalf("FATAL: failed to parse bool: %v\n", bool_err)
+		}
+	}
+
+	retention := TRASH_DEFAULT_RETENTION
+
+	if ok9 {
+		r, r_err := strconv.Atoi(trash_retention_env)
+
+		if r_err != nil || r <= 0 {
+			log.Fatalf("FATAL: invalid trash retention \"%s\"; it must be a positive number of days\n", trash_retention_env)
+		}
+
+		retention = r
+	}
+
+	tracking := false
+
+	if ok10 {
+		t, t_err := strconv.ParseBool(track_identities_env)
+
+		if t_err != nil {
+			log.Fatalf("FATAL: failed to parse TRACK_IDENTITIES: %v\n", t_err)
+		}
+
+		tracking = t
 	}
 
-	doLog, bool_err := strconv.ParseBool(os.Getenv("LOGGING_TO_CHAT"))
+	capturing := false
 
-	if bool_err != nil {
-		log.Fatalf("FATAL: failed to parse bool: %v\n", bool_err)
+	if ok11 {
+		c, c_err := strconv.ParseBool(capture_evidence_env)
+
+		if c_err != nil {
+			log.Fatalf("FATAL: failed to parse CAPTURE_EVIDENCE: %v\n", c_err)
+		}
+
+		capturing = c
+	}
+
+	var evidence_chat_id int64
+
+	if ok12 {
+		var e_err error
+
+		if evidence_chat_id, e_err = strconv.ParseInt(evidence_chat_id_env, 0, 64); e_err != nil {
+			log.Fatalf("FATAL: error parsing evidence chat ID: %v\n", e_err)
+		}
 	}
 
 	Config = &Configuration{
@@ -77,11 +141,22 @@ func init() {
 		ConnectionString: connection_string_env,
 		LoggingToChannel: doLog,
 		LogChannelID:     chan_id,
+		StorageBackend:   storage_env,
+		BoltPath:         bolt_path_env,
+		TrashRetention:   retention,
+		TrackIdentities:  tracking,
+		CaptureEvidence:  capturing,
+		EvidenceChatID:   evidence_chat_id,
 	}
 
+	return port_env
+}
+
+// Connects to the database and makes sure its indexes exist.
+func openStore() {
 	// Connect to database
 
-	d, d_err := NewDatabase(Config.ConnectionString, DATABASE_NAME, COLLECTION_NAME)
+	d, d_err := NewStore(Config)
 
 	if d_err != nil {
 		panic(fmt.Errorf("error when connectiong to database: %w", d_err))
@@ -89,6 +164,28 @@ func init() {
 
 	Data = d
 
+	if i_err := Data.EnsureIndexes(); i_err != nil {
+		log.Fatalf("FATAL: %v; users sharing a tg_id have to be merged by hand first\n", i_err)
+	}
+}
+
+// Applies the pending migrations, or only reports them, with dryRun.
+func migrate(dryRun bool) {
+	results, m_err := RunMigrations(Migrations, dryRun)
+
+	for _, r := range results {
+		log.Printf("migration %d (%s): %d user(s) %s\n", r.Version, r.Description, r.Users, BoolToStr(dryRun, "would be changed", "changed"))
+	}
+
+	if m_err != nil {
+		log.Fatalf("FATAL: error migrating users: %v\n", m_err)
+	}
+
+	log.Printf("%d pending migration(s) %s\n", len(results), BoolToStr(dryRun, "found", "applied"))
+}
+
+// Registers the bot's handlers, given the port to listen on in webhook mode.
+func setupBot(port_env string) {
 	// Initialize bot
 
 	var pref tele.Settings
@@ -114,6 +211,14 @@ func init() {
 		}
 	}
 
+	// Watch group messages, to keep the identities of registered users up to date.
+	if Config.TrackIdentities {
+		// The tracker runs as long as the bot does.
+		StartIdentityTracker()
+
+		pref.Poller = tele.NewMiddlewarePoller(pref.Poller, TrackIdentities)
+	}
+
 	b, b_err := tele.NewBot(pref)
 
 	if b_err != nil {
@@ -123,12 +228,27 @@ func init() {
 
 	Bot = b
 
+	// Suggest the commands, with their syntax, as they're typed.
+	if err := Bot.SetCommands(BotCommands()); err != nil {
+		log.Printf("error setting the bot's commands: %v\n", err)
+	}
+
 	Bot.Use(func(hf tele.HandlerFunc) tele.HandlerFunc {
 		return func(ctx tele.Context) error {
 			toCheck := ""
 
+			// Messages that aren't commands are only handled as forwards sent in PM; the rest, such as
+			// the chatter of groups, are dropped before the sender is looked up.
+			if ctx.Query() == nil && ctx.Callback() == nil && ctx.Message() != nil && !strings.HasPrefix(ctx.Text(), "/") &&
+				!(ctx.Chat().Type == tele.ChatPrivate && IsForward(ctx.Message())) {
+				return nil
+			}
+
 			if ctx.Query() != nil {
 				toCheck = tele.OnQuery
+			} else if IsForward(ctx.Message()) && !strings.HasPrefix(ctx.Text(), "/") {
+				// Forwarded commands are still routed as commands, so they're checked as such.
+				toCheck = ON_FORWARD
 			} else if ctx.Callback() != nil {
 				toCheck = ctx.Callback().Unique
 			} else {
@@ -152,6 +272,14 @@ func init() {
 
 	Bot.Handle(tele.OnQuery, QueryHandler)
 
+	// Forwards sent in PM, whatever they hold.
+	for _, event := range []string{
+		tele.OnText, tele.OnPhoto, tele.OnVideo, tele.OnAnimation, tele.OnDocument,
+		tele.OnSticker, tele.OnVoice, tele.OnAudio, tele.OnVideoNote,
+	} {
+		Bot.Handle(event, ForwardedHandler)
+	}
+
 	Bot.Handle("/start", func(ctx tele.Context) error {
 		handler, ok := CommandMap[ctx.Message().Payload]
 
@@ -173,6 +301,17 @@ func init() {
 	Bot.Handle("/"+CMD_RECALL, RecallHandler)
 	Bot.Handle("/"+CMD_RECORD, RecordHandler)
 	Bot.Handle("/"+CMD_CREDITS, CreditsHandler)
+	Bot.Handle("/"+CMD_AUDIT, AuditHandler)
+	Bot.Handle("/"+CMD_TRASH, TrashHandler)
+	Bot.Handle("/"+CMD_RESTORE, RestoreHandler)
+	Bot.Handle("/"+CMD_MERGE, MergeHandler)
+	Bot.Handle("/"+CMD_SPLIT, SplitHandler)
+	Bot.Handle("/"+CMD_SEARCH, SearchHandler)
+	Bot.Handle("/"+CMD_EXPORT, ExportHandler)
+	Bot.Handle("/"+CMD_BACKUP, BackupHandler)
+	Bot.Handle("/"+CMD_IMPORT, ImportHandler)
+	Bot.Handle("/"+CMD_EDITREC, EditrecHandler)
+	Bot.Handle("/"+CMD_MINE, MyrecordsHandler)
 
 	Bot.Handle(SetPermBtn, SetPermBtnHandler)
 	Bot.Handle(SetHelpBtn, SetHelpBtnHandler)
@@ -186,6 +325,19 @@ func init() {
 	Bot.Handle(DelrecHelpBtn, DelrecHelpBtnHandler)
 	Bot.Handle(DeleteEntryBtn, DeleteEntryBtnHandler)
 	Bot.Handle(UploadResultBtn, UploadResultBtnHandler)
+	Bot.Handle(AuditPageBtn, AuditPageBtnHandler)
+	Bot.Handle(ConfirmMergeBtn, ConfirmMergeBtnHandler)
+	Bot.Handle(CancelBtn, CancelOperatorConfirmationBtnHandler)
+	Bot.Handle(SplitToggleBtn, SplitToggleBtnHandler)
+	Bot.Handle(ConfirmSplitBtn, ConfirmSplitBtnHandler)
+	Bot.Handle(ProfilePageBtn, ProfilePageBtnHandler)
+	Bot.Handle(MatchesPageBtn, MatchesPageBtnHandler)
+	Bot.Handle(OpenProfileBtn, OpenProfileBtnHandler)
+	Bot.Handle(ConfirmRestoreBtn, ConfirmRestoreBtnHandler)
+	Bot.Handle(ConfirmImportBtn, ConfirmImportBtnHandler)
+	Bot.Handle(EvidenceBtn, EvidenceBtnHandler)
+	Bot.Handle(QuickRegBtn, QuickRegBtnHandler)
+	Bot.Handle(QuickRecordBtn, QuickRecordBtnHandler)
 	Bot.Handle(ConfirmOperatorBtn, ConfirmOperatorBtnHandler)
 	Bot.Handle(CancelOperatorConfirmationBtn, CancelOperatorConfirmationBtnHandler)
 
@@ -194,7 +346,36 @@ func init() {
 	}
 }
 
-func main() {
+// Runs the bot until it's told to stop.
+//
+// Syntax:
+//
+//	botone serve [-poll] [-migrate [-dry-run]]
+func Serve(args []string) error {
+	TermSig = make(chan os.Signal, 1)
+	signal.Notify(TermSig, syscall.SIGINT, syscall.SIGTERM)
+
+	println("Initializing..")
+
+	flags := flag.NewFlagSet(SUBCMD_SERVE, flag.ExitOnError)
+
+	flags.BoolVar(&Polling, "poll", false, "set the bot to polling mode")
+	flags.BoolVar(&Migrating, "migrate", false, "apply the pending schema migrations and exit, like the migrate subcommand")
+	flags.BoolVar(&DryRun, "dry-run", false, "with -migrate, only report how many users each migration would change")
+	flags.Parse(args)
+
+	port := loadConfig(!Migrating)
+	openStore()
+
+	// Migrate the stored users
+	migrate(Migrating && DryRun)
+
+	if Migrating {
+		return Data.Disconnect()
+	}
+
+	setupBot(port)
+
 	var group sync.WaitGroup
 
 	// Start bot
@@ -252,4 +433,26 @@ func main() {
 	group.Wait()
 
 	log.Println("Program has ended.")
+
+	return nil
+}
+
+func main() {
+	// Without a subcommand, the bot is served, as it was before there were any.
+	name, args := SUBCMD_SERVE, os.Args[1:]
+
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+
+	run, ok := Subcommands[name]
+
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown subcommand \"%s\"\n\n%s", name, SUBCMD_USAGE)
+		os.Exit(2)
+	}
+
+	if err := run(args); err != nil {
+		log.Fatalf("FATAL: %v\n", err)
+	}
 }
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Initializes an empty in-memory store. Users are copied in and out of it, so that callers
+// never share slices or maps with the stored documents, as it would be with any other backend.
+func NewMemoryDatabase() *MemoryDatabase {
+	return &MemoryDatabase{
+		mutex: &sync.RWMutex{},
+		users: map[int64]User{},
+	}
+}
+
+func (d *MemoryDatabase) Disconnect() error {
+	return nil
+}
+
+// Returns the matching users, ordered by tg_id.
+func (d *MemoryDatabase) Filter(filter bson.D) ([]User, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	users := make([]User, 0)
+
+	for _, u := range d.users {
+		if MatchFilter(u, filter) {
+			clone, err := cloneUser(u)
+
+			if err != nil {
+				return nil, err
+			}
+
+			users = append(users, clone)
+		}
+	}
+
+	sort.Slice(users, func(i, j int) bool { return users[i].TelegramID < users[j].TelegramID })
+
+	return users, nil
+}
+
+func (d *MemoryDatabase) GetAll() ([]User, error) {
+	return d.Filter(bson.D{{}})
+}
+
+func (d *MemoryDatabase) FindByIdentity(field string, value string) ([]User, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	users := make([]User, 0)
+
+	for _, u := range d.users {
+		if MatchIdentity(u, field, value) {
+			clone, err := cloneUser(u)
+
+			if err != nil {
+				return nil, err
+			}
+
+			users = append(users, clone)
+		}
+	}
+
+	sort.Slice(users, func(i, j int) bool { return users[i].TelegramID < users[j].TelegramID })
+
+	return users, nil
+}
+
+func (d *MemoryDatabase) FindNotes(author int64, terms []string) ([]User, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	users := make([]User, 0)
+
+	for _, u := range d.users {
+		if MatchNotes(u, author, terms) {
+			clone, err := cloneUser(u)
+
+			if err != nil {
+				return nil, err
+			}
+
+			users = append(users, clone)
+		}
+	}
+
+	sort.Slice(users, func(i, j int) bool { return users[i].TelegramID < users[j].TelegramID })
+
+	return users, nil
+}
+
+func (d *MemoryDatabase) FindByID(id int64) (User, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	u, ok := d.users[id]
+
+	if !ok {
+		return User{}, ErrNotFound
+	}
+
+	return cloneUser(u)
+}
+
+func (d *MemoryDatabase) Add(users ...User) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	clones := make([]User, 0, len(users))
+
+	for _, u := range users {
+		if _, ok := d.users[u.TelegramID]; ok {
+			return ErrDuplicate
+		}
+
+		clone, err := cloneUser(u)
+
+		if err != nil {
+			return err
+		}
+
+		clones = append(clones, clone)
+	}
+
+	for _, u := range clones {
+		d.users[u.TelegramID] = u
+	}
+
+	return nil
+}
+
+func (d *MemoryDatabase) RemoveByID(id int64) (int64, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if _, ok := d.users[id]; !ok {
+		return 0, nil
+	}
+
+	delete(d.users, id)
+
+	return 1, nil
+}
+
+func (d *MemoryDatabase) ReplaceByID(id int64, user User) error {
+	return d.update(id, func(u *User) error {
+		if u.Version != user.Version {
+			return ErrConflict
+		}
+
+		*u = user
+		return nil
+	})
+}
+
+func (d *MemoryDatabase) Aliases(pull bool, id int64, ids ...int64) error {
+	return d.update(id, func(u *User) error {
+		if pull {
+			u.AliasIDs = Undupe(u.AliasIDs, ids)
+		} else {
+			u.AliasIDs = append(u.AliasIDs, ids...)
+		}
+
+		return nil
+	})
+}
+
+func (d *MemoryDatabase) Names(pull bool, id int64, names ...string) error {
+	return d.update(id, func(u *User) error {
+		if pull {
+			u.Names = Undupe(u.Names, names)
+		} else {
+			u.Names = append(u.Names, names...)
+		}
+
+		return nil
+	})
+}
+
+func (d *MemoryDatabase) Usernames(pull bool, id int64, usernames ...string) error {
+	return d.update(id, func(u *User) error {
+		if pull {
+			u.Usernames = Undupe(u.Usernames, usernames)
+		} else {
+			u.Usernames = append(u.Usernames, usernames...)
+		}
+
+		return nil
+	})
+}
+
+func (d *MemoryDatabase) Record(id int64, category string, record Record, remove bool) error {
+	return d.update(id, func(u *User) error {
+		if u.Records == nil {
+			u.Records = map[string][]Record{}
+		}
+
+		if remove {
+			kept := make([]Record, 0, len(u.Records[category]))
+
+			for _, r := range u.Records[category] {
+				if !SameRecord(r, record) {
+					kept = append(kept, r)
+				}
+			}
+
+			u.Records[category] = kept
+		} else {
+			u.Records[category] = append(u.Records[category], record)
+		}
+
+		return nil
+	})
+}
+
+// Applies modify to a copy of the user with the given tg_id and stores the result.
+func (d *MemoryDatabase) TouchIdentity(id int64, kind string, value string, at time.Time) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	u, ok := d.users[id]
+
+	if !ok {
+		return ErrNotFound
+	}
+
+	u.History = append([]IdentityEntry{}, u.History...)
+	touchIdentity(u.History, kind, value, at)
+	d.users[id] = u
+
+	return nil
+}
+
+func (d *MemoryDatabase) update(id int64, modify func(*User) error) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	u, ok := d.users[id]
+
+	if !ok {
+		return ErrNotFound
+	}
+
+	user, err := cloneUser(u)
+
+	if err != nil {
+		return err
+	}
+
+	if err = modify(&user); err != nil {
+		return err
+	}
+
+	user.Version++
+
+	if user, err = cloneUser(user); err != nil {
+		return err
+	}
+
+	// The tg_id is unique, as Mongo's index keeps it.
+	if _, taken := d.users[user.TelegramID]; taken && user.TelegramID != id {
+		return ErrDuplicate
+	}
+
+	delete(d.users, id)
+	d.users[user.TelegramID] = user
+
+	return nil
+}
+
+func (d *MemoryDatabase) AddAudit(entry AuditEntry) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.audit = append(d.audit, entry)
+
+	return nil
+}
+
+func (d *MemoryDatabase) FindAudit(target int64, action string, since time.Time, skip int64, limit int64) ([]AuditEntry, int64, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	entries := make([]AuditEntry, 0, limit)
+	count := int64(0)
+
+	for i := len(d.audit) - 1; i >= 0; i-- {
+		if !MatchAudit(d.audit[i], target, action, since) {
+			continue
+		}
+
+		if count >= skip && count < skip+limit {
+			entries = append(entries, d.audit[i])
+		}
+
+		count++
+	}
+
+	return entries, count, nil
+}
+
+func (d *MemoryDatabase) AddTrash(entry TrashEntry) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.trash = append(d.trash, entry)
+
+	return nil
+}
+
+func (d *MemoryDatabase) FindTrash(id int64) ([]TrashEntry, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	entries := make([]TrashEntry, 0)
+
+	for _, e := range d.trash {
+		if id == 0 || e.TelegramID == id {
+			entries = append(entries, e)
+		}
+	}
+
+	return entries, nil
+}
+
+func (d *MemoryDatabase) RemoveTrash(ids ...primitive.ObjectID) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	kept := make([]TrashEntry, 0, len(d.trash))
+
+	for _, e := range d.trash {
+		if len(Undupe([]primitive.ObjectID{e.ID}, ids)) > 0 {
+			kept = append(kept, e)
+		}
+	}
+
+	d.trash = kept
+
+	return nil
+}
+
+func (d *MemoryDatabase) PurgeTrash(before time.Time) (int64, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	kept := make([]TrashEntry, 0, len(d.trash))
+
+	for _, e := range d.trash {
+		if !e.Expires.Before(before) {
+			kept = append(kept, e)
+		}
+	}
+
+	count := int64(len(d.trash) - len(kept))
+	d.trash = kept
+
+	return count, nil
+}
+
+func (d *MemoryDatabase) SchemaVersion() (int, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	return d.schema, nil
+}
+
+func (d *MemoryDatabase) SetSchemaVersion(version int) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.schema = version
+
+	return nil
+}
+
+func (d *MemoryDatabase) EnsureIndexes() error {
+	return nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tele "github.com/Henry96Markle/telebot"
+)
+
+// Folds the identities, description and records of merge into keep. The ID of merge becomes
+// one of keep's alias IDs.
+func MergeUsers(keep *User, merge User) {
+	keep.Names = append(keep.Names, Undupe(merge.Names, keep.Names)...)
+	keep.Usernames = append(keep.Usernames, Undupe(merge.Usernames, keep.Usernames)...)
+
+	ids := append([]int64{merge.TelegramID}, merge.AliasIDs...)
+	keep.AliasIDs = append(keep.AliasIDs, Undupe(ids, append(keep.AliasIDs, keep.TelegramID))...)
+
+	// The merged user's identities keep their own dates, and its ID is known since it was registered.
+	MigrateHistory(&merge)
+	MergeHistory(keep, append(merge.History, IdentityEntry{
+		Kind:      IDENTITY_ALIAS,
+		Value:     strconv.FormatInt(merge.TelegramID, 10),
+		FirstSeen: RegistrationDate(merge),
+		LastSeen:  time.Now(),
+		Source:    SOURCE_MERGE,
+	})...)
+
+	if keep.Description == "" {
+		keep.Description = merge.Description
+	} else if merge.Description != "" && merge.Description != keep.Description {
+		keep.Description += "\n\n" + merge.Description
+	}
+
+	if keep.Records == nil {
+		keep.Records = map[string][]Record{}
+	}
+
+	MergeRecords(keep.Records, merge.Records)
+}
+
+// Looks up both users of a merge and checks that the sender may merge them.
+// On failure, the returned string is the message to show the sender.
+func mergeCandidates(sender int64, keepID int64, mergeID int64) (keep User, merge User, msg string) {
+	var err error
+
+	if keepID == mergeID {
+		return keep, merge, "Can't merge a user into itself."
+	}
+
+	if keep, err = Data.FindByID(keepID); err != nil {
+		return keep, merge, fmt.Sprintf("ID %d not found.", keepID)
+	}
+
+	if merge, err = Data.FindByID(mergeID); err != nil {
+		return keep, merge, fmt.Sprintf("ID %d not found.", mergeID)
+	}
+
+	if mergeID == Config.OwnerTelegramID || merge.Permission >= 4 {
+		return keep, merge, "You can't merge the owner into someone else."
+	}
+
+	if merge.Permission >= 3 && sender != Config.OwnerTelegramID {
+		return keep, merge, "You need to be the owner, to merge an operator."
+	}
+
+	return keep, merge, ""
+}
+
+// Syntax:
+//
+//	- /merge <ID/@username/mention/reply-to-message> <mergeID/@username>
+func MergeHandler(c tele.Context) error {
+	args, args_err := ParseArgs(c, CommandSpecs[CMD_MERGE])
+
+	if args_err != nil {
+		return ReplyArgsErr(c, CommandSpecs[CMD_MERGE], args_err)
+	}
+
+	keepID, mergeID := args.Target.ID, args.ID("merged-user")
+
+	keep, merge, msg := mergeCandidates(c.Sender().ID, keepID, mergeID)
+
+	if msg != "" {
+		return c.Reply(msg)
+	}
+
+	confirmBtn := *ConfirmMergeBtn
+	confirmBtn.Data = fmt.Sprintf("%d|%d", keepID, mergeID)
+
+	return c.Reply(
+		fmt.Sprintf(
+			"You're about to merge ID <code>%d</code>%s into ID <code>%d</code>%s.\n\n"+
+				"%d name%s, %d username%s, %d alias ID%s and %d record%s will be moved, "+
+				"and ID <code>%d</code> will be unregistered. Are you sure?",
+			mergeID, BoolToStr(len(merge.Names) > 0, " ("+LastOf(merge.Names)+")", ""),
+			keepID, BoolToStr(len(keep.Names) > 0, " ("+LastOf(keep.Names)+")", ""),
+			len(merge.Names), BoolToStr(len(merge.Names) != 1, "s", ""),
+			len(merge.Usernames), BoolToStr(len(merge.Usernames) != 1, "s", ""),
+			len(merge.AliasIDs)+1, BoolToStr(len(merge.AliasIDs) != 0, "s", ""),
+			CountRecords(merge.Records), BoolToStr(CountRecords(merge.Records) != 1, "s", ""),
+			mergeID,
+		),
+		&tele.ReplyMarkup{
+			InlineKeyboard: [][]tele.InlineButton{
+				{*CancelBtn.Inline(), *confirmBtn.Inline()},
+			},
+		},
+		tele.ModeHTML,
+	)
+}
+
+func ConfirmMergeBtnHandler(c tele.Context) error {
+	if len(c.Args()) != 2 {
+		return c.Edit("Invalid callback data.")
+	}
+
+	keepID, parse_err1 := strconv.ParseInt(c.Args()[0], 0, 64)
+	mergeID, parse_err2 := strconv.ParseInt(c.Args()[1], 0, 64)
+
+	if parse_err1 != nil || parse_err2 != nil {
+		return c.Edit("Invalid callback data.")
+	}
+
+	// Both users are looked up again, since they may have changed since the confirmation was asked.
+
+	_, merge, msg := mergeCandidates(c.Sender().ID, keepID, mergeID)
+
+	if msg != "" {
+		return c.Edit(msg)
+	}
+
+	// The merged user is kept in the trash, until restored or expired
+
+	trash_id, trash_err := TrashUser(c.Sender().ID, merge)
+
+	if trash_err != nil {
+		log.Printf("error moving user to the trash: %v\n", trash_err)
+		return c.Edit(MSG_COULD_NOT_PERFORM)
+	}
+
+	before, after, err := UpdateUser(keepID, func(u *User) error {
+		MergeUsers(u, merge)
+		return nil
+	})
+
+	if err != nil {
+		Data.RemoveTrash(trash_id)
+
+		if errors.Is(err, ErrConflict) {
+			return c.Edit(MSG_CONFLICT)
+		}
+
+		log.Printf(ERR_FMT_UPDATE+"\n", err)
+		return c.Edit(MSG_COULD_NOT_PERFORM)
+	}
+
+	if _, err = Data.RemoveByID(mergeID); err != nil {
+		log.Printf(ERR_FMT_DELETE+"\n", err)
+		return c.Edit("The users were merged, but ID " + strconv.FormatInt(mergeID, 10) + " could not be unregistered.")
+	}
+
+	AuditLog(c.Sender().ID, keepID, AUDIT_MERGE, &before, &after)
+	AuditLog(c.Sender().ID, mergeID, AUDIT_MERGE, &merge, nil)
+
+	// logging
+
+	name := c.Sender().FirstName + " " + c.Sender().LastName
+
+	ChanLogf("#merge\n[<code>%d</code>] %shas merged ID <code>%d</code> into ID <code>%d</code>:\n\n%s",
+		c.Sender().ID,
+		BoolToStr(name != "", name+" ", ""),
+		mergeID,
+		keepID,
+		strings.Join(Map(Diff(&before, &after), func(ch AuditChange) (string, error) {
+			return "\t- " + ch.Field, nil
+		}), "\n"),
+	)
+
+	// returning
+
+	return c.Edit("Users merged.")
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMergeUsers(t *testing.T) {
+	keep := recordedTarget()
+	keep.AliasIDs = []int64{3001}
+	keep.Description = "Prosecutor"
+
+	merge := newTestUser(2001, 0)
+	merge.Names = []string{"Miles Edgeworth", "Edgey"}
+	merge.Usernames = []string{"edgeworth"}
+	merge.AliasIDs = []int64{3001, 3002, testTargetID}
+	merge.Description = "Demon prosecutor"
+	merge.Records = map[string][]Record{
+		"bans": {
+			keep.Records["bans"][0],
+			{ChatID: testGroupID, Notes: []string{"between"}, Date: time.Date(2022, 1, 15, 0, 0, 0, 0, time.UTC)},
+		},
+		"kicks": {{ChatID: testGroupID, Notes: []string{"kicked"}, Date: time.Now()}},
+	}
+
+	MergeUsers(&keep, merge)
+
+	if strings.Join(keep.Names, "|") != "Miles Edgeworth|Edgey" {
+		t.Errorf("unexpected names %v", keep.Names)
+	}
+
+	if strings.Join(keep.Usernames, "|") != "miles|edgeworth" {
+		t.Errorf("unexpected usernames %v", keep.Usernames)
+	}
+
+	if strings.Join(IntToStrSlice(keep.AliasIDs...), "|") != "3001|2001|3002" {
+		t.Errorf("unexpected alias IDs %v", keep.AliasIDs)
+	}
+
+	if keep.Description != "Prosecutor\n\nDemon prosecutor" {
+		t.Errorf("unexpected description %q", keep.Description)
+	}
+
+	bans := keep.Records["bans"]
+
+	if len(bans) != 3 || bans[1].Notes[0] != "between" || len(keep.Records["kicks"]) != 1 {
+		t.Errorf("expected records merged by date without duplicates, got %v", keep.Records)
+	}
+
+	// Without an ObjectID, the merged ID is dated by its earliest record, as /recall dates it.
+	var merged IdentityEntry
+
+	for _, e := range keep.History {
+		if e.Kind == IDENTITY_ALIAS && e.Value == "2001" {
+			merged = e
+		}
+	}
+
+	if !merged.FirstSeen.Equal(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected the merged ID to be first seen on its earliest record, got %+v", merged)
+	}
+}
+
+func TestMergeHandler(t *testing.T) {
+	other := newTestUser(2001, 0)
+	other.Names = []string{"Edgey"}
+
+	tests := []struct {
+		name   string
+		sender int64
+		text   string
+		reply  string
+	}{
+		{name: "prompt", sender: testWriterID, text: "/merge 2000 2001", reply: "You're about to merge ID <code>2001</code> (Edgey) into ID <code>2000</code> (Miles Edgeworth)"},
+		{name: "same user", sender: testWriterID, text: "/merge 2000 2000", reply: "Can't merge a user into itself."},
+		{name: "not found", sender: testWriterID, text: "/merge 2000 4000", reply: "ID 4000 not found."},
+		{name: "owner", sender: testOperatorID, text: "/merge 2000 1000", reply: "You can't merge the owner into someone else."},
+		{name: "operator", sender: testWriterID, text: "/merge 2000 1001", reply: "You need to be the owner, to merge an operator."},
+		{name: "username", sender: testWriterID, text: "/merge @miles @edgey", reply: "You're about to merge ID <code>2001</code> (Edgey) into ID <code>2000</code>"},
+		{name: "insufficient", sender: testWriterID, text: "/merge 2000", reply: "Missing <merged-user>."},
+		{name: "invalid", sender: testWriterID, text: "/merge 2000 abc", reply: "Invalid merged-user: \"abc\" isn't an ID or @username."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTest(t, recordedTarget(), other)
+
+			ctx := newCommand(tt.sender, testGroupID, tt.text, nil)
+
+			if err := MergeHandler(ctx); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !strings.HasPrefix(ctx.last(), tt.reply) {
+				t.Errorf("expected reply %q, got %q", tt.reply, ctx.last())
+			}
+		})
+	}
+
+	t.Run("confirm", func(t *testing.T) {
+		setupTest(t, recordedTarget(), other)
+
+		ctx := newCommand(testWriterID, testGroupID, "/merge 2000 2001", nil)
+		MergeHandler(ctx)
+
+		data := ctx.lastMarkup().InlineKeyboard[0][1].Data
+
+		ctx = newCallback(testWriterID, BTN_CONFIRM_MERGE, data)
+
+		if err := ConfirmMergeBtnHandler(ctx); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if ctx.last() != "Users merged." {
+			t.Fatalf("unexpected reply %q", ctx.last())
+		}
+
+		if _, err := Data.FindByID(2001); err == nil {
+			t.Errorf("expected the merged user to be unregistered")
+		}
+
+		if u := mustFind(t, testTargetID); LastOf(u.Names) != "Edgey" || LastOf(u.AliasIDs) != 2001 {
+			t.Errorf("unexpected merged user %v", u)
+		}
+
+		if trash, _ := Data.FindTrash(2001); len(trash) != 1 {
+			t.Errorf("expected the merged user to be in the trash")
+		}
+
+		if _, count, _ := Data.FindAudit(testTargetID, AUDIT_MERGE, time.Time{}, 0, 1); count != 1 {
+			t.Errorf("expected an audit entry")
+		}
+	})
+}
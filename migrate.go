@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Applies the migrations newer than the stored schema version to every user, in order, and records
+// each version once its step is done. With dryRun, nothing is written; the result of each step is
+// what it would change, given the steps before it.
+func RunMigrations(migrations []Migration, dryRun bool) ([]MigrationResult, error) {
+	current, err := Data.SchemaVersion()
+
+	if err != nil {
+		return nil, fmt.Errorf("error reading schema version: %w", err)
+	}
+
+	pending := make([]Migration, 0, len(migrations))
+
+	for _, m := range migrations {
+		if m.Version > current {
+			pending = append(pending, m)
+		}
+	}
+
+	sort.SliceStable(pending, func(i, j int) bool { return pending[i].Version < pending[j].Version })
+
+	results := make([]MigrationResult, 0, len(pending))
+
+	if len(pending) == 0 {
+		return results, nil
+	}
+
+	users, err := Data.GetAll()
+
+	if err != nil {
+		return nil, fmt.Errorf("error reading users: %w", err)
+	}
+
+	for _, m := range pending {
+		result := MigrationResult{Version: m.Version, Description: m.Description}
+
+		for i := range users {
+			if !m.Apply(&users[i]) {
+				continue
+			}
+
+			result.Users++
+
+			if dryRun {
+				continue
+			}
+
+			if err = Data.ReplaceByID(users[i].TelegramID, users[i]); err != nil {
+				return results, fmt.Errorf("migration %d failed on ID %d: %w", m.Version, users[i].TelegramID, err)
+			}
+
+			// The store has incremented the version; keep up, for the next steps.
+			users[i].Version++
+		}
+
+		results = append(results, result)
+
+		if dryRun {
+			continue
+		}
+
+		if err = Data.SetSchemaVersion(m.Version); err != nil {
+			return results, fmt.Errorf("error recording schema version %d: %w", m.Version, err)
+		}
+	}
+
+	return results, nil
+}
+
+// Fills in the fields that documents written by older versions may lack, so that they can be
+// appended to safely. Reports whether anything changed.
+func NormalizeUser(u *User) bool {
+	changed := false
+
+	if u.Names == nil {
+		u.Names, changed = make([]string, 0), true
+	}
+
+	if u.Usernames == nil {
+		u.Usernames, changed = make([]string, 0), true
+	}
+
+	if u.AliasIDs == nil {
+		u.AliasIDs, changed = make([]int64, 0), true
+	}
+
+	if u.Records == nil {
+		u.Records, changed = map[string][]Record{}, true
+	}
+
+	return changed
+}
+
+// Returns the schema version the given migrations bring the users to.
+func LatestSchemaVersion(migrations []Migration) int {
+	latest := 0
+
+	for _, m := range migrations {
+		if m.Version > latest {
+			latest = m.Version
+		}
+	}
+
+	return latest
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestRunMigrations(t *testing.T) {
+	legacy := recordedTarget()
+	legacy.Records = nil
+
+	migrations := []Migration{
+		{Version: 2, Description: "history", Apply: MigrateHistory},
+		{Version: 1, Description: "normalize", Apply: NormalizeUser},
+	}
+
+	t.Run("dry run", func(t *testing.T) {
+		setupTest(t, legacy)
+
+		results, err := RunMigrations(migrations, true)
+
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if len(results) != 2 || results[0].Version != 1 || results[0].Users != 1 || results[1].Users != 1 {
+			t.Fatalf("unexpected results %v", results)
+		}
+
+		if v, _ := Data.SchemaVersion(); v != 0 {
+			t.Errorf("expected the schema version to stay 0, got %d", v)
+		}
+
+		if u := mustFind(t, testTargetID); u.Records != nil || len(u.History) != 0 {
+			t.Errorf("expected the user to be left alone, got %v", u)
+		}
+	})
+
+	t.Run("apply", func(t *testing.T) {
+		setupTest(t, legacy)
+
+		if _, err := RunMigrations(migrations, false); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if v, _ := Data.SchemaVersion(); v != 2 {
+			t.Errorf("expected schema version 2, got %d", v)
+		}
+
+		if u := mustFind(t, testTargetID); u.Records == nil || len(u.History) != 2 {
+			t.Errorf("expected the user to be migrated, got %v", u)
+		}
+
+		if results, _ := RunMigrations(migrations, false); len(results) != 0 {
+			t.Errorf("expected nothing left to migrate, got %v", results)
+		}
+	})
+
+	t.Run("partially applied", func(t *testing.T) {
+		setupTest(t, legacy)
+		Data.SetSchemaVersion(1)
+
+		results, err := RunMigrations(migrations, false)
+
+		if err != nil || len(results) != 1 || results[0].Version != 2 {
+			t.Fatalf("expected only the newer migration to run, got %v, %v", results, err)
+		}
+
+		if u := mustFind(t, testTargetID); u.Records != nil {
+			t.Errorf("expected the older migration to be skipped")
+		}
+	})
+}
@@ -2,15 +2,27 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"regexp"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+var (
+	// Added to every partial update, so that concurrent replaces notice it.
+	incVersion = bson.E{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}}
+
+	// Compares strings ignoring case, as names and usernames are looked up.
+	caseInsensitive = &options.Collation{Locale: "en", Strength: 2}
+)
+
 // Initializes a new Database struct. If connection to the database fails, an error is returned.
-func NewDatabase(connectionString string, databaseName string, collectionName string) (database *Database, err error) {
+func NewDatabase(connectionString string, databaseName string, collectionName string, auditCollectionName string, trashCollectionName string, metaCollectionName string) (database *Database, err error) {
 	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(connectionString))
 
 	if err != nil {
@@ -21,6 +33,9 @@ func NewDatabase(connectionString string, databaseName string, collectionName st
 		client:     client,
 		database:   client.Database(databaseName),
 		collection: collectionName,
+		audit:      auditCollectionName,
+		trash:      trashCollectionName,
+		meta:       metaCollectionName,
 	}, nil
 }
 
@@ -71,26 +86,108 @@ func (d Database) GetAll() (records []User, err error) {
 	return d.Filter(bson.D{{}})
 }
 
+// Names and usernames are compared under caseInsensitive, so that the indexes EnsureIndexes makes with it
+// are used.
+func (d Database) FindByIdentity(field string, value string) ([]User, error) {
+	cursor, err := d.Collection().Find(
+		context.TODO(),
+		bson.D{{Key: field, Value: value}},
+		options.Find().SetCollation(caseInsensitive),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]User, 0)
+	err = cursor.All(context.TODO(), &users)
+
+	return users, err
+}
+
+// Records are keyed by category, which no index can cover; the records are matched on the server,
+// so that only the users that have a match are sent over.
+func (d Database) FindNotes(author int64, terms []string) ([]User, error) {
+	var (
+		text = bson.D{{Key: "$concat", Value: bson.A{"$$c.k", "\n", bson.D{{Key: "$reduce", Value: bson.D{
+			{Key: "input", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$$r.notes", bson.A{}}}}},
+			{Key: "initialValue", Value: ""},
+			{Key: "in", Value: bson.D{{Key: "$cond", Value: bson.A{
+				bson.D{{Key: "$eq", Value: bson.A{"$$value", ""}}},
+				"$$this",
+				bson.D{{Key: "$concat", Value: bson.A{"$$value", "; ", "$$this"}}},
+			}}}},
+		}}}}}}
+
+		record      = bson.A{}
+		description = bson.A{}
+	)
+
+	if author != 0 {
+		record = append(record, bson.D{{Key: "$eq", Value: bson.A{"$$r.author", author}}})
+	}
+
+	for _, t := range terms {
+		record = append(record, bson.D{{Key: "$regexMatch", Value: bson.D{
+			{Key: "input", Value: text}, {Key: "regex", Value: regexp.QuoteMeta(t)}, {Key: "options", Value: "i"},
+		}}})
+
+		description = append(description, bson.D{{Key: "$regexMatch", Value: bson.D{
+			{Key: "input", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$description", ""}}}},
+			{Key: "regex", Value: regexp.QuoteMeta(t)},
+			{Key: "options", Value: "i"},
+		}}})
+	}
+
+	match := bson.D{{Key: "$anyElementTrue", Value: bson.A{bson.D{{Key: "$map", Value: bson.D{
+		{Key: "input", Value: bson.D{{Key: "$objectToArray", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$records", bson.D{}}}}}}},
+		{Key: "as", Value: "c"},
+		{Key: "in", Value: bson.D{{Key: "$anyElementTrue", Value: bson.A{bson.D{{Key: "$map", Value: bson.D{
+			{Key: "input", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$$c.v", bson.A{}}}}},
+			{Key: "as", Value: "r"},
+			{Key: "in", Value: bson.D{{Key: "$and", Value: record}}},
+		}}}}}}},
+	}}}}}}
+
+	if author == 0 && len(terms) > 0 {
+		match = bson.D{{Key: "$or", Value: bson.A{bson.D{{Key: "$and", Value: description}}, match}}}
+	}
+
+	return d.Filter(bson.D{{Key: "$expr", Value: match}})
+}
+
 // Looks up using only tg_id field.
 func (d Database) FindByID(id int64) (User, error) {
 	user := User{}
 
 	err := d.Collection().FindOne(context.TODO(), bson.D{{Key: "tg_id", Value: id}}).Decode(&user)
 
-	if err != nil {
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return User{}, ErrNotFound
+	} else if err != nil {
 		return User{}, err
 	} else {
 		return user, nil
 	}
 }
 
-func (d Database) Add(users ...any) error {
+func (d Database) Add(users ...User) error {
 	var err error = nil
 
 	if len(users) == 1 {
 		_, err = d.Collection().InsertOne(context.TODO(), users[0])
 	} else {
-		_, err = d.Collection().InsertMany(context.TODO(), users)
+		documents := make([]any, 0, len(users))
+
+		for _, u := range users {
+			documents = append(documents, u)
+		}
+
+		_, err = d.Collection().InsertMany(context.TODO(), documents)
+	}
+
+	if mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("%w: %v", ErrDuplicate, err)
 	}
 
 	return err
@@ -146,7 +243,20 @@ func (d Database) Aliases(pull bool, id int64, ids ...int64) error {
 					Key: operator, Value: ids,
 				}},
 			}},
-		}},
+		}, incVersion},
+	)
+
+	return err
+}
+
+func (d Database) TouchIdentity(id int64, kind string, value string, at time.Time) error {
+	_, err := d.Collection().UpdateOne(
+		context.TODO(),
+		bson.D{
+			{Key: "tg_id", Value: id},
+			{Key: "history", Value: bson.D{{Key: "$elemMatch", Value: bson.D{{Key: "kind", Value: kind}, {Key: "value", Value: value}}}}},
+		},
+		bson.D{{Key: "$max", Value: bson.D{{Key: "history.$.last_seen", Value: at}}}},
 	)
 
 	return err
@@ -162,7 +272,7 @@ func (d Database) Record(id int64, category string, record Record, remove bool)
 
 	_, err := d.Collection().UpdateOne(
 		context.TODO(),
-		bson.D{{Key: "tg_id", Value: id}}, bson.D{{Key: modifier, Value: bson.D{{Key: "records." + category, Value: record}}}},
+		bson.D{{Key: "tg_id", Value: id}}, bson.D{{Key: modifier, Value: bson.D{{Key: "records." + category, Value: record}}}, incVersion},
 	)
 
 	if err != nil {
@@ -188,7 +298,7 @@ func (d Database) Names(pull bool, id int64, names ...string) error {
 	_, err := d.Collection().UpdateOne(
 		context.TODO(),
 		bson.D{{Key: "tg_id", Value: id}},
-		bson.D{{Key: modifier, Value: bson.D{{Key: "names", Value: bson.D{{Key: operator, Value: names}}}}}},
+		bson.D{{Key: modifier, Value: bson.D{{Key: "names", Value: bson.D{{Key: operator, Value: names}}}}}, incVersion},
 	)
 
 	return err
@@ -214,20 +324,193 @@ func (d Database) Usernames(pull bool, id int64, usernames ...string) error {
 			context.TODO(),
 			bson.D{
 				{Key: "tg_id", Value: id}},
-			bson.D{{Key: modifier, Value: bson.D{{Key: "usernames", Value: bson.D{{Key: operator, Value: usernames}}}}}})
+			bson.D{{Key: modifier, Value: bson.D{{Key: "usernames", Value: bson.D{{Key: operator, Value: usernames}}}}}, incVersion})
 	} else if len(usernames) == 1 {
 		_, err = d.Collection().UpdateOne(
 			context.TODO(),
 			bson.D{
 				{Key: "tg_id", Value: id}},
-			bson.D{{Key: modifier, Value: bson.D{{Key: "usernames", Value: usernames[0]}}}})
+			bson.D{{Key: modifier, Value: bson.D{{Key: "usernames", Value: usernames[0]}}}, incVersion})
 	}
 
 	return err
 }
 
 func (d Database) ReplaceByID(id int64, user User) error {
-	_, err := d.Collection().ReplaceOne(context.TODO(), bson.D{{Key: "tg_id", Value: id}}, user)
+	version := bson.E{Key: "version", Value: user.Version}
+
+	if user.Version == 0 {
+		// Documents written before versioning don't have the field at all.
+		version.Value = bson.D{{Key: "$in", Value: bson.A{0, nil}}}
+	}
+
+	user.Version++
+
+	res, err := d.Collection().ReplaceOne(context.TODO(), bson.D{{Key: "tg_id", Value: id}, version}, user)
+
+	if mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("%w: %v", ErrDuplicate, err)
+	} else if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		if _, err = d.FindByID(id); err != nil {
+			return err
+		}
+
+		return ErrConflict
+	}
+
+	return nil
+}
+
+func (d Database) AddAudit(entry AuditEntry) error {
+	_, err := d.database.Collection(d.audit).InsertOne(context.TODO(), entry)
 
 	return err
 }
+
+func (d Database) FindAudit(target int64, action string, since time.Time, skip int64, limit int64) ([]AuditEntry, int64, error) {
+	filter := bson.D{{Key: "target", Value: target}}
+
+	if action != "" {
+		filter = append(filter, bson.E{Key: "action", Value: action})
+	}
+
+	if !since.IsZero() {
+		filter = append(filter, bson.E{Key: "date", Value: bson.D{{Key: "$gte", Value: since}}})
+	}
+
+	collection := d.database.Collection(d.audit)
+
+	count, err := collection.CountDocuments(context.TODO(), filter)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	cursor, err := collection.Find(
+		context.TODO(),
+		filter,
+		options.Find().SetSort(bson.D{{Key: "date", Value: -1}}).SetSkip(skip).SetLimit(limit),
+	)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	entries := make([]AuditEntry, 0, limit)
+	err = cursor.All(context.TODO(), &entries)
+
+	return entries, count, err
+}
+
+func (d Database) AddTrash(entry TrashEntry) error {
+	_, err := d.database.Collection(d.trash).InsertOne(context.TODO(), entry)
+
+	return err
+}
+
+func (d Database) FindTrash(id int64) ([]TrashEntry, error) {
+	filter := bson.D{{}}
+
+	if id != 0 {
+		filter = bson.D{{Key: "tg_id", Value: id}}
+	}
+
+	cursor, err := d.database.Collection(d.trash).Find(
+		context.TODO(),
+		filter,
+		options.Find().SetSort(bson.D{{Key: "date", Value: 1}}),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]TrashEntry, 0)
+	err = cursor.All(context.TODO(), &entries)
+
+	return entries, err
+}
+
+func (d Database) RemoveTrash(ids ...primitive.ObjectID) error {
+	_, err := d.database.Collection(d.trash).DeleteMany(
+		context.TODO(),
+		bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: ids}}}},
+	)
+
+	return err
+}
+
+func (d Database) PurgeTrash(before time.Time) (int64, error) {
+	res, err := d.database.Collection(d.trash).DeleteMany(
+		context.TODO(),
+		bson.D{{Key: "expires", Value: bson.D{{Key: "$lt", Value: before}}}},
+	)
+
+	count := int64(0)
+
+	if res != nil {
+		count = res.DeletedCount
+	}
+
+	return count, err
+}
+
+func (d Database) SchemaVersion() (int, error) {
+	doc := struct {
+		Value int `bson:"value"`
+	}{}
+
+	err := d.database.Collection(d.meta).FindOne(
+		context.TODO(),
+		bson.D{{Key: "_id", Value: META_SCHEMA_VERSION}},
+	).Decode(&doc)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, nil
+	}
+
+	return doc.Value, err
+}
+
+func (d Database) SetSchemaVersion(version int) error {
+	_, err := d.database.Collection(d.meta).UpdateOne(
+		context.TODO(),
+		bson.D{{Key: "_id", Value: META_SCHEMA_VERSION}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "value", Value: version}}}},
+		options.Update().SetUpsert(true),
+	)
+
+	return err
+}
+
+func (d Database) EnsureIndexes() error {
+	indexes := map[string][]mongo.IndexModel{
+		d.collection: {
+			{Keys: bson.D{{Key: "tg_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "alias_ids", Value: 1}}},
+			{Keys: bson.D{{Key: "names", Value: 1}}},
+			{Keys: bson.D{{Key: "usernames", Value: 1}}},
+			{Keys: bson.D{{Key: "names", Value: 1}}, Options: options.Index().SetName("names_ci").SetCollation(caseInsensitive)},
+			{Keys: bson.D{{Key: "usernames", Value: 1}}, Options: options.Index().SetName("usernames_ci").SetCollation(caseInsensitive)},
+		},
+		d.audit: {
+			{Keys: bson.D{{Key: "target", Value: 1}, {Key: "date", Value: -1}}},
+		},
+		d.trash: {
+			{Keys: bson.D{{Key: "tg_id", Value: 1}}},
+			{Keys: bson.D{{Key: "expires", Value: 1}}},
+		},
+	}
+
+	for collection, models := range indexes {
+		if _, err := d.database.Collection(collection).Indexes().CreateMany(context.TODO(), models); err != nil {
+			return fmt.Errorf("error creating indexes on %s: %w", collection, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	tele "github.com/Henry96Markle/telebot"
+)
+
+// Splits a profile into pages that fit in a message: the identity first, then one page for each
+// category of records, or more, if a category doesn't fit in one.
+func ProfilePages(user User) []string {
+	var (
+		pages  = []string{truncateIdentity(user, PROFILE_PAGE_LIMIT)}
+		header = fmt.Sprintf("%s[<code>%d</code>]\n\n", BoolToStr(len(user.Names) > 0, LastOf(user.Names)+" ", ""), user.TelegramID)
+	)
+
+	for _, category := range SortedCategories(user.Records) {
+		var (
+			chunks = make([][]string, 0, 1)
+			chunk  = make([]string, 0)
+			size   = 0
+		)
+
+		for _, r := range user.Records[category] {
+			str := RecordToStr(truncateNotes(r, PROFILE_PAGE_LIMIT), "\t")
+			length := utf8.RuneCountInString(str)
+
+			if len(chunk) > 0 && size+length > PROFILE_PAGE_LIMIT {
+				chunks = append(chunks, chunk)
+				chunk, size = make([]string, 0), 0
+			}
+
+			chunk = append(chunk, str)
+			size += length + 3
+		}
+
+		chunks = append(chunks, chunk)
+
+		for i, c := range chunks {
+			pages = append(pages, fmt.Sprintf(
+				"%s<b>%s</b>%s:\n\t%s",
+				header,
+				category,
+				BoolToStr(len(chunks) > 1, fmt.Sprintf(" (%d/%d)", i+1, len(chunks)), ""),
+				strings.Join(c, "\n\n\t"),
+			))
+		}
+	}
+
+	return pages
+}
+
+// Renders a user's identity within limit runes. The oldest identities are left out first, behind a line
+// that says how many there are, then the description is cut; both before formatting, so that no tag or
+// entity is cut in half.
+func truncateIdentity(user User, limit int) string {
+	var (
+		bare   = user
+		hidden = 0
+
+		more = func() string {
+			return BoolToStr(hidden > 0, fmt.Sprintf("\n\t- <i>…and %d more</i>", hidden), "")
+		}
+	)
+
+	bare.Description = ""
+
+	for utf8.RuneCountInString(DisplayIdentity(&bare)+more()) > limit && dropOldestIdentity(&bare) {
+		hidden++
+	}
+
+	room := limit - utf8.RuneCountInString(DisplayIdentity(&bare)+more()+"\n\n")
+
+	if room < 0 {
+		room = 0
+	}
+
+	bare.Description = TruncateStr(user.Description, room)
+
+	return DisplayIdentity(&bare) + more()
+}
+
+// Leaves the oldest identity of a user out of what DisplayIdentity shows: the first one of the timeline,
+// or, without one, the oldest past name, username or alias ID. It reports false if there's none left.
+func dropOldestIdentity(u *User) bool {
+	switch {
+	case len(u.History) > 0:
+		oldest := 0
+
+		for i, e := range u.History {
+			if e.FirstSeen.Before(u.History[oldest].FirstSeen) {
+				oldest = i
+			}
+		}
+
+		u.History = append(append([]IdentityEntry{}, u.History[:oldest]...), u.History[oldest+1:]...)
+	case len(u.Names) > 1:
+		u.Names = u.Names[1:]
+	case len(u.Usernames) > 1:
+		u.Usernames = u.Usernames[1:]
+	case len(u.AliasIDs) > 0:
+		u.AliasIDs = u.AliasIDs[1:]
+	default:
+		return false
+	}
+
+	return true
+}
+
+// Cuts the notes of a record down, so that it takes at most limit runes once formatted.
+// The notes that don't fit at all are left out, after an ellipsis.
+func truncateNotes(r Record, limit int) Record {
+	bare := r
+	bare.Notes = nil
+
+	var (
+		room  = limit - utf8.RuneCountInString(RecordToStr(bare, "\t")+"\n\tNotes:")
+		notes = make([]string, 0, len(r.Notes))
+	)
+
+	for _, n := range r.Notes {
+		room -= utf8.RuneCountInString("\n\t- ")
+
+		length := utf8.RuneCountInString(n)
+
+		if length > room {
+			if room < 0 {
+				room = 0
+			}
+
+			notes = append(notes, TruncateStr(n, room))
+			break
+		}
+
+		room -= length
+		notes = append(notes, n)
+	}
+
+	r.Notes = notes
+
+	return r
+}
+
+// Reports whether the sender of c may delete user from its profile: they need read/write permissions,
+// to be in PM, and the user can't be the owner or an operator.
+func canDeleteFromProfile(c tele.Context, user User) bool {
+	sender, err := Data.FindByID(c.Sender().ID)
+
+	return err == nil &&
+		sender.Permission >= 2 &&
+		c.Chat() != nil && c.Chat().ID == c.Sender().ID &&
+		user.Permission < 3 &&
+		user.TelegramID != Config.OwnerTelegramID
+}
+
+// Builds the message that shows a user's profile to the sender of c. Profiles that fit in a single
+// message are shown whole; longer ones are shown a page at a time, with buttons to move between pages.
+// token is the one the whole profile was kept under, for the "Send in a file" button, when turning pages;
+// a new one is made if it's empty or has expired.
+func ProfileMessage(c tele.Context, user User, page int, token string) (string, *tele.ReplyMarkup) {
+	var (
+		text     = DisplayUser(&user)
+		keyboard = make([][]tele.InlineButton, 0, 2)
+	)
+
+	if utf8.RuneCountInString(text) > MESSAGE_LENGTH_LIMIT {
+		pages := ProfilePages(user)
+
+		if page < 0 || page >= len(pages) {
+			page = 0
+		}
+
+		var (
+			upload = ReuseResultButton(c, token, text)
+			row    = make([]tele.InlineButton, 0, 2)
+
+			data = func(page int) string {
+				data := fmt.Sprintf("%d|%d", user.TelegramID, page)
+
+				if upload != nil && FitsCallbackData(BTN_PROFILE_PAGE, data+"|"+upload.Data) {
+					data += "|" + upload.Data
+				}
+
+				return data
+			}
+		)
+
+		text = pages[page] + fmt.Sprintf("\n\n<i>Page %d/%d</i>", page+1, len(pages))
+
+		if page > 0 {
+			row = append(row, tele.InlineButton{Unique: BTN_PROFILE_PAGE, Text: "« Prev", Data: data(page - 1)})
+		}
+
+		if page < len(pages)-1 {
+			row = append(row, tele.InlineButton{Unique: BTN_PROFILE_PAGE, Text: "Next »", Data: data(page + 1)})
+		}
+
+		keyboard = append(keyboard, row)
+
+		if upload != nil {
+			keyboard = append(keyboard, []tele.InlineButton{*upload})
+		}
+	}
+
+	keyboard = append(keyboard, EvidenceButtons(user)...)
+
+	if canDeleteFromProfile(c, user) {
+		deleteBtn := *DeleteEntryBtn
+		deleteBtn.Data = fmt.Sprintf("%d", user.TelegramID)
+
+		keyboard = append(keyboard, []tele.InlineButton{*deleteBtn.Inline()})
+	}
+
+	if len(keyboard) == 0 {
+		return text, nil
+	}
+
+	return text, &tele.ReplyMarkup{InlineKeyboard: keyboard}
+}
+
+// Searches users for /recall; field is one of "name", "username" or "any".
+func RecallSearch(field string, value string) ([]User, error) {
+	results, err := SearchUsers(value, field)
+
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]User, 0, len(results))
+
+	for _, r := range results {
+		users = append(users, r.User)
+	}
+
+	return users, nil
+}
+
+// Builds a page of a /recall list of matches, with a button to open each user's profile.
+func MatchesMessage(users []User, field string, value string, page int) (string, *tele.ReplyMarkup) {
+	pages := (len(users) + MATCHES_PAGE_SIZE - 1) / MATCHES_PAGE_SIZE
+
+	if page < 0 || page >= pages {
+		page = 0
+	}
+
+	var (
+		from = page * MATCHES_PAGE_SIZE
+		to   = from + MATCHES_PAGE_SIZE
+
+		lines    = make([]string, 0, MATCHES_PAGE_SIZE)
+		keyboard = make([][]tele.InlineButton, 0, MATCHES_PAGE_SIZE+1)
+	)
+
+	if to > len(users) {
+		to = len(users)
+	}
+
+	for _, u := range users[from:to] {
+		name := BoolToStr(len(u.Names) > 0, LastOf(u.Names), "")
+
+		lines = append(lines, fmt.Sprintf("[<code>%d</code>] %s", u.TelegramID, html.EscapeString(name)))
+		keyboard = append(keyboard, []tele.InlineButton{{
+			Unique: BTN_OPEN_PROFILE,
+			Text:   strings.TrimSpace(fmt.Sprintf("%s [%d]", TruncateStr(name, 40), u.TelegramID)),
+			Data:   strconv.FormatInt(u.TelegramID, 10),
+		}})
+	}
+
+	var (
+		row   = make([]tele.InlineButton, 0, 2)
+		data  = func(p int) string { return fmt.Sprintf("%s|%d|%s", field, p, value) }
+		fits  = FitsCallbackData(BTN_MATCHES_PAGE, data(pages))
+		notes = ""
+	)
+
+	if fits && page > 0 {
+		row = append(row, tele.InlineButton{Unique: BTN_MATCHES_PAGE, Text: "« Prev", Data: data(page - 1)})
+	}
+
+	if fits && page < pages-1 {
+		row = append(row, tele.InlineButton{Unique: BTN_MATCHES_PAGE, Text: "Next »", Data: data(page + 1)})
+	}
+
+	if len(row) > 0 {
+		keyboard = append(keyboard, row)
+	}
+
+	if pages > 1 {
+		notes = fmt.Sprintf("\n\n<i>Page %d/%d</i>", page+1, pages)
+
+		if !fits {
+			notes += "\n<i>The search is too long to page through; narrow it down.</i>"
+		}
+	}
+
+	return fmt.Sprintf(
+		"<b>%d</b> users matched:\n\n\t- %s%s",
+		len(users),
+		strings.Join(lines, "\n\t- "),
+		notes,
+	), &tele.ReplyMarkup{InlineKeyboard: keyboard}
+}
+
+func ProfilePageBtnHandler(c tele.Context) error {
+	if len(c.Args()) < 2 {
+		return c.Edit("Invalid callback data.")
+	}
+
+	id, parse_err1 := strconv.ParseInt(c.Args()[0], 0, 64)
+	page, parse_err2 := strconv.Atoi(c.Args()[1])
+
+	if parse_err1 != nil || parse_err2 != nil {
+		return c.Edit("Invalid callback data.")
+	}
+
+	user, data_err := Data.FindByID(id)
+
+	if data_err != nil {
+		log.Printf(ERR_FMT_QUERY+"\n", data_err)
+		return c.Edit(MSG_ID_NOT_FOUND)
+	}
+
+	token := ""
+
+	if len(c.Args()) > 2 {
+		token = c.Args()[2]
+	}
+
+	text, keyboard := ProfileMessage(c, user, page, token)
+
+	return c.Edit(text, keyboard, tele.ModeHTML)
+}
+
+func MatchesPageBtnHandler(c tele.Context) error {
+	if len(c.Args()) < 3 {
+		return c.Edit("Invalid callback data.")
+	}
+
+	var (
+		field = c.Args()[0]
+		value = strings.Join(c.Args()[2:], "|")
+	)
+
+	page, parse_err := strconv.Atoi(c.Args()[1])
+
+	if parse_err != nil {
+		return c.Edit("Invalid callback data.")
+	}
+
+	users, data_err := RecallSearch(field, value)
+
+	if data_err != nil {
+		log.Printf("error searching users by %s: %v\n", field, data_err)
+		return c.Edit(MSG_COULD_NOT_PERFORM)
+	}
+
+	if len(users) == 0 {
+		return c.Edit(MSG_NO_MATCH)
+	}
+
+	text, keyboard := MatchesMessage(users, field, value, page)
+
+	return c.Edit(text, keyboard, tele.ModeHTML)
+}
+
+func OpenProfileBtnHandler(c tele.Context) error {
+	id, parse_err := strconv.ParseInt(c.Callback().Data, 0, 64)
+
+	if parse_err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: "Invalid callback data."})
+	}
+
+	user, data_err := Data.FindByID(id)
+
+	if data_err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: MSG_ID_NOT_FOUND})
+	}
+
+	text, keyboard := ProfileMessage(c, user, 0, "")
+
+	c.Respond()
+
+	return c.Send(text, keyboard, tele.ModeHTML)
+}
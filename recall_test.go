@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// A user whose profile doesn't fit in a single message.
+func longTarget() User {
+	u := recordedTarget()
+
+	for i := 0; i < 40; i++ {
+		u.Records["kicks"] = append(u.Records["kicks"], Record{
+			ChatID: testGroupID,
+			Notes:  []string{fmt.Sprintf("kick %d: %s", i, strings.Repeat("flooding the chat ", 10))},
+			Date:   time.Date(2022, 4, 1, 0, i, 0, 0, time.UTC),
+		})
+	}
+
+	return u
+}
+
+func TestProfilePages(t *testing.T) {
+	pages := ProfilePages(longTarget())
+
+	// The identity, bans, at least two pages of kicks, then warns.
+	if len(pages) < 5 {
+		t.Fatalf("expected at least 5 pages, got %d", len(pages))
+	}
+
+	for i, p := range pages {
+		if utf8.RuneCountInString(p) > PROFILE_PAGE_LIMIT+100 {
+			t.Errorf("page %d is too long: %d", i, utf8.RuneCountInString(p))
+		}
+	}
+
+	if !strings.Contains(pages[1], "<b>bans</b>:") || !strings.Contains(pages[2], "<b>kicks</b> (1/") || !strings.Contains(LastOf(pages), "<b>warns</b>:") {
+		t.Errorf("unexpected page order")
+	}
+
+	// Notes and descriptions too long for a page are cut before they're formatted.
+	u := longTarget()
+	u.Description = strings.Repeat("é", 5000)
+	u.Records["bans"][0].Notes = []string{strings.Repeat("<ü>", 2000), "dropped"}
+
+	pages = ProfilePages(u)
+
+	if n := utf8.RuneCountInString(pages[0]); n > PROFILE_PAGE_LIMIT+1 || !strings.HasSuffix(pages[0], "é…") {
+		t.Errorf("expected the description to be cut down, got %d runes", n)
+	}
+
+	if n := utf8.RuneCountInString(pages[1]); n > PROFILE_PAGE_LIMIT+100 || strings.Contains(pages[1], "dropped") || !strings.Contains(pages[1], "…") {
+		t.Errorf("expected the notes to be cut down, got %d runes", n)
+	}
+
+	// So are identity timelines; the oldest identities are left out.
+	u = longTarget()
+
+	for i := 0; i < 300; i++ {
+		seen := time.Date(2022, 1, 1, i, 0, 0, 0, time.UTC)
+		u.History = append(u.History, IdentityEntry{Kind: IDENTITY_NAME, Value: fmt.Sprintf("Name %d", i), FirstSeen: seen, LastSeen: seen, Source: SOURCE_OBSERVED})
+	}
+
+	pages = ProfilePages(u)
+
+	if n := utf8.RuneCountInString(pages[0]); n > PROFILE_PAGE_LIMIT || !strings.Contains(pages[0], "Name 299 (") || strings.Contains(pages[0], "Name 0 (") || !strings.Contains(pages[0], "more</i>") {
+		t.Errorf("expected the timeline to be cut down, got %d runes: %q", n, pages[0])
+	}
+}
+
+func TestRecallPages(t *testing.T) {
+	setupTest(t, longTarget())
+
+	ctx := newCommand(testWriterID, testWriterID, "/recall 2000", nil)
+	RecallHandler(ctx)
+
+	if !strings.Contains(ctx.last(), "<i>Page 1/") {
+		t.Fatalf("expected the first page, got %q", ctx.last())
+	}
+
+	// Next, then the upload and delete buttons.
+	markup := ctx.lastMarkup()
+
+	if len(markup.InlineKeyboard) != 3 || len(markup.InlineKeyboard[0]) != 1 {
+		t.Fatalf("unexpected keyboard %v", markup.InlineKeyboard)
+	}
+
+	next := markup.InlineKeyboard[0][0]
+	ctx = newCallback(testWriterID, next.Unique, next.Data)
+
+	if err := ProfilePageBtnHandler(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !strings.Contains(ctx.last(), "<b>bans</b>:") || !strings.Contains(ctx.last(), "<i>Page 2/") {
+		t.Errorf("expected the second page, got %q", ctx.last())
+	}
+
+	if row := ctx.lastMarkup().InlineKeyboard[0]; len(row) != 2 || row[0].Text != "« Prev" {
+		t.Errorf("expected both navigation buttons, got %v", row)
+	}
+
+	// Turning pages keeps the result the first page kept.
+	if upload := ctx.lastMarkup().InlineKeyboard[1][0]; upload.Data != markup.InlineKeyboard[1][0].Data {
+		t.Errorf("expected the result's token to be reused, got %q and %q", markup.InlineKeyboard[1][0].Data, upload.Data)
+	}
+}
+
+func TestRecallMatches(t *testing.T) {
+	users := make([]User, 0, 12)
+
+	for i := 0; i < 12; i++ {
+		u := newTestUser(int64(2100+i), 0)
+		u.Names = []string{fmt.Sprintf("Miles %d", i)}
+
+		users = append(users, u)
+	}
+
+	setupTest(t, users...)
+
+	ctx := newCommand(testReaderID, testGroupID, "/recall name miles", nil)
+	RecallHandler(ctx)
+
+	if !strings.HasPrefix(ctx.last(), "<b>12</b> users matched:") || !strings.Contains(ctx.last(), "<i>Page 1/2</i>") {
+		t.Fatalf("unexpected reply %q", ctx.last())
+	}
+
+	markup := ctx.lastMarkup()
+
+	if len(markup.InlineKeyboard) != MATCHES_PAGE_SIZE+1 {
+		t.Fatalf("expected a button per user and a navigation row, got %d rows", len(markup.InlineKeyboard))
+	}
+
+	next := LastOf(markup.InlineKeyboard)[0]
+	ctx = newCallback(testReaderID, next.Unique, next.Data)
+
+	if err := MatchesPageBtnHandler(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !strings.Contains(ctx.last(), "<i>Page 2/2</i>") || len(ctx.lastMarkup().InlineKeyboard) != 3 {
+		t.Fatalf("expected the second page, got %q", ctx.last())
+	}
+
+	open := ctx.lastMarkup().InlineKeyboard[0][0]
+	ctx = newCallback(testReaderID, open.Unique, open.Data)
+
+	if err := OpenProfileBtnHandler(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !strings.Contains(ctx.last(), "<b>ID:</b> <code>"+open.Data+"</code>") {
+		t.Errorf("expected the profile, got %q", ctx.last())
+	}
+}
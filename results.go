@@ -0,0 +1,127 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	tele "github.com/Henry96Markle/telebot"
+)
+
+var htmlTag = regexp.MustCompile(`<[^>]*>`)
+
+func NewResultStore() *ResultStore {
+	return &ResultStore{
+		mutex:   &sync.Mutex{},
+		results: map[string]StoredResult{},
+	}
+}
+
+// Keeps a result for its owner until the TTL runs out, and returns the token to fetch it with.
+// Expired results are dropped along the way.
+func (r *ResultStore) Put(owner int64, value any, ttl time.Duration) (string, error) {
+	b := make([]byte, 8)
+
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	token := hex.EncodeToString(b)
+	now := time.Now()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for k, v := range r.results {
+		if now.After(v.Expires) {
+			delete(r.results, k)
+		}
+	}
+
+	r.results[token] = StoredResult{Owner: owner, Value: value, Expires: now.Add(ttl)}
+
+	return token, nil
+}
+
+// Returns the result kept under a token, unless it has expired.
+func (r *ResultStore) Get(token string) (StoredResult, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	result, ok := r.results[token]
+
+	if !ok || time.Now().After(result.Expires) {
+		delete(r.results, token)
+		return StoredResult{}, false
+	}
+
+	return result, true
+}
+
+// Drops a result before it expires.
+func (r *ResultStore) Delete(token string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.results, token)
+}
+
+// Turns an HTML-formatted message into plain text.
+func StripHTML(s string) string {
+	return html.UnescapeString(htmlTag.ReplaceAllString(s, ""))
+}
+
+// Keeps a result for the sender of c, and returns a "Send in a file" button that sends it.
+// The button is nil if the result couldn't be kept.
+func UploadResultButton(c tele.Context, text string) *tele.InlineButton {
+	token, err := Results.Put(c.Sender().ID, StripHTML(text), RESULT_TTL)
+
+	if err != nil {
+		return nil
+	}
+
+	btn := *UploadResultBtn
+	btn.Data = token
+
+	return btn.Inline()
+}
+
+// Returns a "Send in a file" button for the result kept under token, if it's still kept for the sender
+// of c; otherwise, text is kept anew, as UploadResultButton does.
+func ReuseResultButton(c tele.Context, token string, text string) *tele.InlineButton {
+	if result, ok := Results.Get(token); !ok || result.Owner != c.Sender().ID {
+		return UploadResultButton(c, text)
+	}
+
+	btn := *UploadResultBtn
+	btn.Data = token
+
+	return btn.Inline()
+}
+
+func UploadResultBtnHandler(ctx tele.Context) error {
+	result, ok := Results.Get(ctx.Callback().Data)
+	text, is_text := result.Value.(string)
+
+	if !ok || !is_text {
+		return ctx.Respond(&tele.CallbackResponse{Text: "This result has expired; ask for it again."})
+	}
+
+	if result.Owner != ctx.Sender().ID {
+		return ctx.Respond(&tele.CallbackResponse{Text: "Only the one who asked for this result can have it sent."})
+	}
+
+	f := tele.Document{
+		File:     tele.FromReader(strings.NewReader(text)),
+		FileName: fmt.Sprintf("Result-%s.txt", time.Now().Format("2006-01-02")),
+	}
+
+	ctx.Respond()
+
+	return ctx.Send(&f)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResultStore(t *testing.T) {
+	r := NewResultStore()
+
+	token, err := r.Put(testReaderID, "result", time.Minute)
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !FitsCallbackData(BTN_UPLOAD_RESULT, token) {
+		t.Errorf("token %q doesn't fit in callback data", token)
+	}
+
+	if result, ok := r.Get(token); !ok || result.Value != "result" || result.Owner != testReaderID {
+		t.Errorf("unexpected result %v", result)
+	}
+
+	expired, _ := r.Put(testReaderID, "expired", -time.Second)
+
+	if _, ok := r.Get(expired); ok {
+		t.Errorf("expected the result to have expired")
+	}
+
+	if _, ok := r.Get("unknown"); ok {
+		t.Errorf("expected an unknown token to miss")
+	}
+}
+
+func TestUploadResultBtnHandler(t *testing.T) {
+	setupTest(t, longTarget())
+
+	// Two readers ask for the same long profile; each gets their own result.
+	first := newCommand(testReaderID, testReaderID, "/recall 2000", nil)
+	RecallHandler(first)
+
+	second := newCommand(testWriterID, testWriterID, "/recall 2000", nil)
+	RecallHandler(second)
+
+	btn := first.lastMarkup().InlineKeyboard[1][0]
+
+	if btn.Unique != BTN_UPLOAD_RESULT || btn.Data == second.lastMarkup().InlineKeyboard[1][0].Data {
+		t.Fatalf("expected a button with its own token, got %v", btn)
+	}
+
+	ctx := newCallback(testWriterID, btn.Unique, btn.Data)
+	UploadResultBtnHandler(ctx)
+
+	if len(ctx.documents) != 0 || len(ctx.responses) != 1 {
+		t.Errorf("expected someone else's result not to be sent")
+	}
+
+	ctx = newCallback(testReaderID, btn.Unique, btn.Data)
+
+	if err := UploadResultBtnHandler(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(ctx.documents) != 1 {
+		t.Fatalf("expected a document to be sent")
+	}
+
+	content, _ := io.ReadAll(ctx.documents[0].File.FileReader)
+
+	if !strings.HasPrefix(string(content), "Name: Miles Edgeworth\nUsername: miles\nID: 2000") || strings.Contains(string(content), "<b>") {
+		t.Errorf("unexpected content %q", TruncateStr(string(content), 100))
+	}
+
+	ctx = newCallback(testReaderID, btn.Unique, "unknown")
+	UploadResultBtnHandler(ctx)
+
+	if len(ctx.documents) != 0 || !strings.Contains(ctx.responses[0].Text, "expired") {
+		t.Errorf("expected an unknown token to be reported as expired")
+	}
+}
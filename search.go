@@ -0,0 +1,500 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	tele "github.com/Henry96Markle/telebot"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Scores how closely a candidate string matches a query, from 0 to 1, ignoring case: an exact match
+// scores 1, then come prefixes, word prefixes and substrings. Anything else is scored by the edit distance
+// to the whole candidate, or to its closest word, so that typos still match.
+func MatchScore(query string, candidate string) float64 {
+	q, c := strings.ToLower(strings.TrimSpace(query)), strings.ToLower(strings.TrimSpace(candidate))
+
+	if q == "" || c == "" {
+		return 0
+	}
+
+	switch {
+	case q == c:
+		return 1
+	case strings.HasPrefix(c, q):
+		return 0.9
+	case strings.Contains(c, " "+q):
+		return 0.85
+	case strings.Contains(c, q):
+		return 0.8
+	}
+
+	best := Similarity(q, c)
+
+	for _, w := range strings.Fields(c) {
+		if s := Similarity(q, w); s > best {
+			best = s
+		}
+	}
+
+	return 0.7 * best
+}
+
+// The Levenshtein similarity of two strings: 1 if they're equal, 0 if they have nothing in common.
+func Similarity(a string, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+
+	longest := len(ra)
+
+	if len(rb) > longest {
+		longest = len(rb)
+	}
+
+	if longest == 0 {
+		return 1
+	}
+
+	return 1 - float64(Levenshtein(ra, rb))/float64(longest)
+}
+
+// The number of single-rune insertions, deletions and substitutions that turn a into b.
+func Levenshtein(a []rune, b []rune) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			curr[j] = min3(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+
+		prev, curr = curr, prev
+	}
+
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+
+	if c < a {
+		a = c
+	}
+
+	return a
+}
+
+// Scores a user against a query, by the best of its identities. Current names and usernames weigh
+// the most, past ones a bit less, and descriptions only count when they contain the query.
+// field narrows the search down to "name" or "username"; anything else searches everything.
+func ScoreUser(user User, query string, field string) float64 {
+	var (
+		best = 0.0
+
+		score = func(weight float64, candidates ...string) {
+			for _, c := range candidates {
+				if s := weight * MatchScore(query, c); s > best {
+					best = s
+				}
+			}
+		}
+
+		past = func(values []string) []string {
+			if len(values) < 2 {
+				return nil
+			}
+
+			return values[:len(values)-1]
+		}
+	)
+
+	if field != "username" {
+		score(1, LastOf(user.Names))
+		score(SEARCH_PAST_WEIGHT, past(user.Names)...)
+	}
+
+	if field != "name" {
+		query = TrimUsername(query)
+
+		score(1, LastOf(user.Usernames))
+		score(SEARCH_PAST_WEIGHT, past(user.Usernames)...)
+	}
+
+	if field != "name" && field != "username" {
+		score(SEARCH_DESCRIPTION_WEIGHT, user.Description)
+	}
+
+	return best
+}
+
+// Builds a regex that matches any pair of consecutive runes of a query, ignoring case. A candidate
+// that's close enough to the query for MatchScore shares at least one of them, so it's used to narrow
+// the users down before they're scored.
+func bigramPattern(query string) string {
+	var (
+		runes = []rune(strings.ToLower(strings.TrimSpace(query)))
+		seen  = map[string]bool{}
+		grams = make([]string, 0, len(runes))
+	)
+
+	if len(runes) < 2 {
+		return regexp.QuoteMeta(string(runes))
+	}
+
+	for i := 1; i < len(runes); i++ {
+		if g := regexp.QuoteMeta(string(runes[i-1 : i+1])); !seen[g] {
+			seen[g] = true
+			grams = append(grams, g)
+		}
+	}
+
+	return strings.Join(grams, "|")
+}
+
+// Filters the users that may match a query, for ScoreUser to rank. See ScoreUser for field.
+func searchFilter(query string, field string) bson.D {
+	alternatives := bson.A{}
+
+	if field != "username" {
+		alternatives = append(alternatives, bson.D{{Key: "names", Value: primitive.Regex{Pattern: bigramPattern(query), Options: "i"}}})
+	}
+
+	if field != "name" {
+		alternatives = append(alternatives, bson.D{{Key: "usernames", Value: primitive.Regex{Pattern: bigramPattern(TrimUsername(query)), Options: "i"}}})
+	}
+
+	if field != "name" && field != "username" {
+		// Descriptions only score high enough when they contain the query.
+		pattern := regexp.QuoteMeta(strings.TrimSpace(query))
+		alternatives = append(alternatives, bson.D{{Key: "description", Value: primitive.Regex{Pattern: pattern, Options: "i"}}})
+	}
+
+	return bson.D{{Key: "$or", Value: alternatives}}
+}
+
+// Ranks the users that match a query closely enough, best first. See ScoreUser for field.
+func SearchUsers(query string, field string) ([]SearchResult, error) {
+	if strings.TrimSpace(query) == "" {
+		return []SearchResult{}, nil
+	}
+
+	// Fuzzy matching can't be expressed as a filter, so the users the store narrows down are scored here.
+	users, err := Data.Filter(searchFilter(query, field))
+
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]SearchResult, 0)
+
+	for _, u := range users {
+		if s := ScoreUser(u, query, field); s >= SEARCH_MIN_SCORE {
+			results = append(results, SearchResult{User: u, Score: s})
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+
+		return results[i].User.TelegramID < results[j].User.TelegramID
+	})
+
+	if len(results) > SEARCH_LIMIT {
+		results = results[:SEARCH_LIMIT]
+	}
+
+	return results, nil
+}
+
+// Reports whether every term appears in the text, ignoring case.
+func containsTerms(text string, terms []string) bool {
+	text = strings.ToLower(text)
+
+	for _, t := range terms {
+		if !strings.Contains(text, t) {
+			return false
+		}
+	}
+
+	return len(terms) > 0
+}
+
+// Cuts the text down to radius runes on either side of the first term it contains.
+func Snippet(text string, terms []string, radius int) string {
+	var (
+		runes = []rune(text)
+		lower = strings.ToLower(text)
+		start = 0
+	)
+
+	for _, t := range terms {
+		if i := strings.Index(lower, t); i >= 0 {
+			start = utf8.RuneCountInString(lower[:i])
+			break
+		}
+	}
+
+	from, to := start-radius, start+radius
+
+	if from < 0 {
+		from = 0
+	}
+
+	if to > len(runes) {
+		to = len(runes)
+	}
+
+	return BoolToStr(from > 0, "…", "") + string(runes[from:to]) + BoolToStr(to < len(runes), "…", "")
+}
+
+// Finds the records whose category and notes contain every word of the query, and the users whose
+// description does. Records come first, the latest first.
+func SearchNotes(query string) ([]NoteHit, error) {
+	terms := strings.Fields(strings.ToLower(query))
+	users, err := Data.FindNotes(0, terms)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		records      = make([]NoteHit, 0)
+		descriptions = make([]NoteHit, 0)
+	)
+
+	for _, u := range users {
+		if containsTerms(u.Description, terms) {
+			descriptions = append(descriptions, NoteHit{
+				TelegramID: u.TelegramID,
+				Name:       LastOf(u.Names),
+				Snippet:    Snippet(u.Description, terms, SNIPPET_RADIUS),
+			})
+		}
+
+		for category, rs := range u.Records {
+			for _, r := range rs {
+				notes := strings.Join(r.Notes, "; ")
+
+				if !containsTerms(category+"\n"+notes, terms) {
+					continue
+				}
+
+				records = append(records, NoteHit{
+					TelegramID: u.TelegramID,
+					Name:       LastOf(u.Names),
+					Category:   category,
+					Record:     r,
+					Snippet:    Snippet(notes, terms, SNIPPET_RADIUS),
+				})
+			}
+		}
+	}
+
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Record.Date.After(records[j].Record.Date) })
+	sort.SliceStable(descriptions, func(i, j int) bool { return descriptions[i].TelegramID < descriptions[j].TelegramID })
+
+	return append(records, descriptions...), nil
+}
+
+// Finds the records written by author, the latest first. If the query isn't empty, only the records
+// whose category and notes contain every word of it are kept.
+func RecordsBy(author int64, query string) ([]NoteHit, error) {
+	terms := strings.Fields(strings.ToLower(query))
+	users, err := Data.FindNotes(author, terms)
+
+	if err != nil {
+		return nil, err
+	}
+
+	hits := make([]NoteHit, 0)
+
+	for _, u := range users {
+		for category, rs := range u.Records {
+			for _, r := range rs {
+				notes := strings.Join(r.Notes, "; ")
+
+				if r.Author != author || len(terms) > 0 && !containsTerms(category+"\n"+notes, terms) {
+					continue
+				}
+
+				hits = append(hits, NoteHit{
+					TelegramID: u.TelegramID,
+					Name:       LastOf(u.Names),
+					Category:   category,
+					Record:     r,
+					Snippet:    Snippet(notes, terms, SNIPPET_RADIUS),
+				})
+			}
+		}
+	}
+
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].Record.Date.After(hits[j].Record.Date) })
+
+	return hits, nil
+}
+
+// Lists the first NOTES_HITS_LIMIT hits under a title, or as many as fit in a message.
+func NoteHitsMessage(title string, hits []NoteHit) string {
+	const footer = "\n\n…and %d more; narrow the search down."
+
+	var (
+		lines = make([]string, 0, len(hits))
+		// The room the title and the footer take, at most.
+		size = utf8.RuneCountInString(title+":\n\n\t- ") + utf8.RuneCountInString(fmt.Sprintf(footer, len(hits)))
+	)
+
+	for i, h := range hits {
+		line := NoteHitToStr(h)
+
+		if i == NOTES_HITS_LIMIT || size+utf8.RuneCountInString(line) > MESSAGE_LENGTH_LIMIT {
+			break
+		}
+
+		lines = append(lines, line)
+		size += utf8.RuneCountInString(line + "\n\t- ")
+	}
+
+	return fmt.Sprintf(
+		"%s:\n\n\t- %s%s",
+		title,
+		strings.Join(lines, "\n\t- "),
+		BoolToStr(len(hits) > len(lines), fmt.Sprintf(footer, len(hits)-len(lines)), ""),
+	)
+}
+
+// Formats a hit as a single line.
+func NoteHitToStr(hit NoteHit) string {
+	return fmt.Sprintf(
+		"[<code>%d</code>] %s<b>%s</b>%s: %s",
+		hit.TelegramID,
+		BoolToStr(hit.Name != "", html.EscapeString(hit.Name)+" · ", ""),
+		html.EscapeString(BoolToStr(hit.Category != "", hit.Category, "description")),
+		BoolToStr(hit.Category != "", ", "+hit.Record.Date.UTC().Format("2006-01-02"), ""),
+		BoolToStr(hit.Snippet != "", html.EscapeString(hit.Snippet), "<i>no notes</i>"),
+	)
+}
+
+// Syntax:
+//
+//	- /search <text>
+func SearchHandler(c tele.Context) error {
+	args, args_err := ParseArgs(c, CommandSpecs[CMD_SEARCH])
+
+	if args_err != nil {
+		return ReplyArgsErr(c, CommandSpecs[CMD_SEARCH], args_err)
+	}
+
+	query := args.Str("text")
+	hits, err := SearchNotes(query)
+
+	if err != nil {
+		log.Printf("error searching notes: %v\n", err)
+		return c.Reply(MSG_COULD_NOT_PERFORM)
+	}
+
+	if len(hits) == 0 {
+		return c.Reply(MSG_NO_MATCH)
+	}
+
+	title := fmt.Sprintf("<b>%d</b> hit%s for \"%s\"", len(hits), BoolToStr(len(hits) != 1, "s", ""), html.EscapeString(TruncateStr(query, NOTES_QUERY_SHOWN)))
+
+	return c.Reply(NoteHitsMessage(title, hits), tele.ModeHTML)
+}
+
+// Syntax:
+//
+//	- /myrecords [text]
+func MyrecordsHandler(c tele.Context) error {
+	args, args_err := ParseArgs(c, CommandSpecs[CMD_MINE])
+
+	if args_err != nil {
+		return ReplyArgsErr(c, CommandSpecs[CMD_MINE], args_err)
+	}
+
+	query := args.Str("text")
+	hits, err := RecordsBy(c.Sender().ID, query)
+
+	if err != nil {
+		log.Printf("error searching records: %v\n", err)
+		return c.Reply(MSG_COULD_NOT_PERFORM)
+	}
+
+	if len(hits) == 0 {
+		return c.Reply(BoolToStr(query == "", "You haven't written any records yet.", MSG_NO_MATCH))
+	}
+
+	title := fmt.Sprintf("You wrote <b>%d</b> record%s", len(hits), BoolToStr(len(hits) != 1, "s", ""))
+
+	if query != "" {
+		title += fmt.Sprintf(" matching \"%s\"", html.EscapeString(TruncateStr(query, NOTES_QUERY_SHOWN)))
+	}
+
+	return c.Reply(NoteHitsMessage(title, hits), tele.ModeHTML)
+}
+
+// Answers inline queries that start with NOTES_QUERY_PREFIX.
+func NotesQueryHandler(c tele.Context, query string) error {
+	var (
+		hits []NoteHit
+		err  error
+	)
+
+	if utf8.RuneCountInString(strings.TrimSpace(query)) >= INLINE_MIN_QUERY {
+		hits, err = SearchNotes(query)
+	}
+
+	if err != nil {
+		log.Printf("error searching notes: %v\n", err)
+	}
+
+	results := make(tele.Results, 0, len(hits))
+
+	for i, h := range hits {
+		if i == NOTES_HITS_LIMIT {
+			break
+		}
+
+		text := NoteHitToStr(h)
+
+		if h.Category != "" {
+			text = fmt.Sprintf("[<code>%d</code>] <b>%s</b>:\n\n%s", h.TelegramID, html.EscapeString(h.Category), RecordToStr(truncateNotes(h.Record, PROFILE_PAGE_LIMIT), ""))
+		}
+
+		results = append(results, &tele.ArticleResult{
+			Title:       fmt.Sprintf("%d · %s", h.TelegramID, BoolToStr(h.Category != "", h.Category, "description")),
+			Text:        text,
+			Description: h.Snippet,
+		})
+	}
+
+	for i := range results {
+		results[i].SetResultID(fmt.Sprint(i))
+		results[i].SetParseMode(tele.ModeHTML)
+	}
+
+	return c.Answer(&tele.QueryResponse{
+		Results:   results,
+		CacheTime: 60,
+	})
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	tele "github.com/Henry96Markle/telebot"
+)
+
+func TestMatchScore(t *testing.T) {
+	tests := []struct {
+		query     string
+		candidate string
+		min, max  float64
+	}{
+		{query: "Miles Edgeworth", candidate: "miles edgeworth", min: 1, max: 1},
+		{query: "miles", candidate: "Miles Edgeworth", min: 0.9, max: 0.9},
+		{query: "edge", candidate: "Miles Edgeworth", min: 0.85, max: 0.85},
+		{query: "worth", candidate: "Miles Edgeworth", min: 0.8, max: 0.8},
+		{query: "edgewroth", candidate: "Miles Edgeworth", min: SEARCH_MIN_SCORE, max: 0.7},
+		{query: "phoenix", candidate: "Miles Edgeworth", min: 0, max: SEARCH_MIN_SCORE - 0.01},
+		{query: "", candidate: "Miles Edgeworth", min: 0, max: 0},
+	}
+
+	for _, tt := range tests {
+		if s := MatchScore(tt.query, tt.candidate); s < tt.min || s > tt.max {
+			t.Errorf("MatchScore(%q, %q) = %f, expected between %f and %f", tt.query, tt.candidate, s, tt.min, tt.max)
+		}
+	}
+}
+
+func TestLevenshtein(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		distance int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"kitten", "sitting", 3},
+		{"édgé", "edge", 2},
+	}
+
+	for _, tt := range tests {
+		if d := Levenshtein([]rune(tt.a), []rune(tt.b)); d != tt.distance {
+			t.Errorf("Levenshtein(%q, %q) = %d, expected %d", tt.a, tt.b, d, tt.distance)
+		}
+	}
+}
+
+func TestSearchUsers(t *testing.T) {
+	current := newTestUser(2001, 0)
+	current.Names = []string{"Phoenix Wright", "Miles"}
+
+	past := newTestUser(2002, 0)
+	past.Names = []string{"Miles", "Nick"}
+
+	described := newTestUser(2003, 0)
+	described.Description = "Says he's Miles' friend"
+
+	setupTest(t, recordedTarget(), current, past, described)
+
+	results, err := SearchUsers("miles", "")
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Exact matches tie, and are ordered by ID; past names and descriptions come after.
+	order := []int64{testTargetID, 2001, 2002, 2003}
+
+	if len(results) != len(order) {
+		t.Fatalf("expected %d results, got %v", len(order), results)
+	}
+
+	for i, id := range order {
+		if results[i].User.TelegramID != id {
+			t.Errorf("expected ID %d at %d, got %d", id, i, results[i].User.TelegramID)
+		}
+	}
+
+	if results, _ = SearchUsers("miles", "username"); len(results) != 1 || results[0].User.TelegramID != testTargetID {
+		t.Errorf("expected only the username to match, got %v", results)
+	}
+
+	// The store narrows the users down without losing the ones with a typo.
+	if results, _ = SearchUsers("edgewrth", ""); len(results) != 1 || results[0].User.TelegramID != testTargetID {
+		t.Errorf("expected a typo to match, got %v", results)
+	}
+}
+
+func TestSearchNotes(t *testing.T) {
+	spammer := newTestUser(2001, 0)
+	spammer.Names = []string{"Larry Butz"}
+	spammer.Description = "Posts spam links in every group"
+	spammer.Records = map[string][]Record{
+		"spam": {{ChatID: testGroupID, Notes: []string{"sent links to a phishing site"}, Date: time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC)}},
+	}
+
+	tests := []struct {
+		name     string
+		text     string
+		contains []string
+	}{
+		{name: "note", text: "/search SECOND", contains: []string{"<b>1</b> hit for \"SECOND\"", "[<code>2000</code>] Miles Edgeworth · <b>bans</b>, 2022-02-01: second"}},
+		{name: "terms in any order", text: "/search links spam", contains: []string{"<b>2</b> hits", "<b>spam</b>, 2022-05-01: sent links", "<b>description</b>: Posts spam links"}},
+		{name: "category", text: "/search warns", contains: []string{"<b>warns</b>, 2022-03-01: third"}},
+		{name: "no match", text: "/search phoenix", contains: []string{MSG_NO_MATCH}},
+		{name: "insufficient", text: "/search", contains: []string{"Missing <text..>."}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTest(t, recordedTarget(), spammer)
+
+			ctx := newCommand(testReaderID, testGroupID, tt.text, nil)
+
+			if err := SearchHandler(ctx); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			for _, c := range tt.contains {
+				if !strings.Contains(ctx.last(), c) {
+					t.Errorf("expected reply to contain %q, got %q", c, ctx.last())
+				}
+			}
+		})
+	}
+
+	t.Run("inline", func(t *testing.T) {
+		setupTest(t, recordedTarget(), spammer)
+
+		ctx := newQuery(testReaderID, "notes: phishing")
+
+		if err := QueryHandler(ctx); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if len(ctx.answers) != 1 || len(ctx.answers[0].Results) != 1 {
+			t.Fatalf("expected a single result")
+		}
+	})
+}
+
+func TestNoteHitsMessage(t *testing.T) {
+	hits := make([]NoteHit, NOTES_HITS_LIMIT)
+
+	for i := range hits {
+		hits[i] = NoteHit{TelegramID: int64(i), Name: strings.Repeat("Larry ", 10), Category: "spam", Snippet: strings.Repeat("spam links ", 20)}
+	}
+
+	msg := NoteHitsMessage("Hits", hits)
+
+	if utf8.RuneCountInString(msg) > MESSAGE_LENGTH_LIMIT {
+		t.Errorf("expected the message to fit in %d runes, got %d", MESSAGE_LENGTH_LIMIT, utf8.RuneCountInString(msg))
+	}
+
+	if !strings.Contains(msg, "more; narrow the search down.") {
+		t.Errorf("expected the hits left out to be counted, got %q", msg)
+	}
+}
+
+func TestNotesQueryHandler(t *testing.T) {
+	target := recordedTarget()
+	target.Records["spam"] = []Record{{ChatID: testGroupID, Notes: []string{"flood " + strings.Repeat("links ", 1000), "dropped"}, Date: time.Now()}}
+
+	setupTest(t, target)
+
+	ctx := newQuery(testReaderID, NOTES_QUERY_PREFIX+"flood")
+
+	if err := QueryHandler(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(ctx.answers) != 1 || len(ctx.answers[0].Results) != 1 {
+		t.Fatalf("expected a result, got %+v", ctx.answers)
+	}
+
+	text := ctx.answers[0].Results[0].(*tele.ArticleResult).Text
+
+	if utf8.RuneCountInString(text) > MESSAGE_LENGTH_LIMIT || strings.Contains(text, "dropped") {
+		t.Errorf("expected the record to be cut down, got %d runes", utf8.RuneCountInString(text))
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	text := "The defendant was seen sending spam links to the whole group, twice"
+
+	if s := Snippet(text, []string{"spam"}, 10); s != "…n sending spam links…" {
+		t.Errorf("unexpected snippet %q", s)
+	}
+
+	if s := Snippet("short", []string{"missing"}, 10); s != "short" {
+		t.Errorf("unexpected snippet %q", s)
+	}
+}
+
+func TestMyrecordsHandler(t *testing.T) {
+	target := recordedTarget()
+	target.Records["bans"][0].Author = testWriterID
+	target.Records["warns"][0].Author = testWriterID
+	target.Records["bans"][1].Author = testOperatorID
+
+	tests := []struct {
+		name   string
+		sender int64
+		text   string
+		want   []string
+		not    []string
+	}{
+		{"all", testWriterID, "/myrecords", []string{"You wrote <b>2</b> records", "first", "third"}, []string{"second"}},
+		{"filtered", testWriterID, "/myrecords warns", []string{"You wrote <b>1</b> record matching \"warns\"", "third"}, []string{"first"}},
+		{"no match", testWriterID, "/myrecords kicks", []string{MSG_NO_MATCH}, nil},
+		{"none", testReaderID, "/myrecords", []string{"You haven't written any records yet."}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTest(t, target)
+
+			c := newCommand(tt.sender, tt.sender, tt.text, nil)
+
+			if err := MyrecordsHandler(c); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, w := range tt.want {
+				if !strings.Contains(c.last(), w) {
+					t.Errorf("expected %q in %q", w, c.last())
+				}
+			}
+
+			for _, n := range tt.not {
+				if strings.Contains(c.last(), n) {
+					t.Errorf("didn't expect %q in %q", n, c.last())
+				}
+			}
+		})
+	}
+}
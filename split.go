@@ -0,0 +1,356 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	tele "github.com/Henry96Markle/telebot"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Returns the categories of a set of records in a stable order, so that callback data can
+// refer to them by index.
+func SortedCategories(records map[string][]Record) []string {
+	categories := make([]string, 0, len(records))
+
+	for k := range records {
+		categories = append(categories, k)
+	}
+
+	sort.Strings(categories)
+
+	return categories
+}
+
+// Parses the data of a /split button: the user ID, the alias ID and the user's version,
+// followed by any number of indexes.
+func parseSplitData(data string) (id int64, alias int64, version int64, indexes []int, err error) {
+	// Buttons read back from a message carry their unique name in front of the data.
+	if strings.HasPrefix(data, "\f") {
+		_, data, _ = strings.Cut(data, "|")
+	}
+
+	fields := strings.Split(data, "|")
+
+	if len(fields) < 3 {
+		return 0, 0, 0, nil, errors.New("invalid callback data")
+	}
+
+	if id, err = strconv.ParseInt(fields[0], 0, 64); err != nil {
+		return
+	}
+
+	if alias, err = strconv.ParseInt(fields[1], 0, 64); err != nil {
+		return
+	}
+
+	if version, err = strconv.ParseInt(fields[2], 0, 64); err != nil {
+		return
+	}
+
+	for _, f := range fields[3:] {
+		i, e := strconv.Atoi(f)
+
+		if e != nil {
+			return 0, 0, 0, nil, e
+		}
+
+		indexes = append(indexes, i)
+	}
+
+	return
+}
+
+// Reads which records are selected from the toggle buttons of a /split keyboard. Records are
+// keyed by their category index and their index in that category.
+func splitSelection(user User, markup *tele.ReplyMarkup) map[[2]int]bool {
+	selected := map[[2]int]bool{}
+
+	if markup == nil {
+		return selected
+	}
+
+	categories := SortedCategories(user.Records)
+
+	for _, row := range markup.InlineKeyboard {
+		for _, btn := range row {
+			_, _, _, indexes, err := parseSplitData(btn.Data)
+
+			if err != nil || len(indexes) != 2 || !strings.HasPrefix(btn.Text, SPLIT_SELECTED) {
+				continue
+			}
+
+			ci, ri := indexes[0], indexes[1]
+
+			if ci < 0 || ci >= len(categories) {
+				continue
+			}
+
+			if ri < 0 {
+				for i := range user.Records[categories[ci]] {
+					selected[[2]int{ci, i}] = true
+				}
+			} else if ri < len(user.Records[categories[ci]]) {
+				selected[[2]int{ci, ri}] = true
+			}
+		}
+	}
+
+	return selected
+}
+
+// Builds the /split prompt and its keyboard: a toggle button per category and, if there
+// aren't too many, per record.
+func SplitPrompt(user User, alias int64, selected map[[2]int]bool) (string, *tele.ReplyMarkup) {
+	var (
+		categories = SortedCategories(user.Records)
+		keyboard   = make([][]tele.InlineButton, 0, len(categories)+1)
+
+		listRecords = CountRecords(user.Records) <= SPLIT_MAX_RECORD_BUTTONS
+
+		prefix = fmt.Sprintf("%d|%d|%d", user.TelegramID, alias, user.Version)
+
+		mark = func(b bool) string { return BoolToStr(b, SPLIT_SELECTED, SPLIT_UNSELECTED) + " " }
+	)
+
+	for ci, category := range categories {
+		all := len(user.Records[category]) > 0
+
+		for ri := range user.Records[category] {
+			all = all && selected[[2]int{ci, ri}]
+		}
+
+		keyboard = append(keyboard, []tele.InlineButton{*tele.Btn{
+			Unique: BTN_SPLIT_TOGGLE,
+			Text:   fmt.Sprintf("%s%s (%d)", mark(all), category, len(user.Records[category])),
+			Data:   fmt.Sprintf("%s|%d|-1", prefix, ci),
+		}.Inline()})
+
+		if !listRecords {
+			continue
+		}
+
+		for ri, r := range user.Records[category] {
+			keyboard = append(keyboard, []tele.InlineButton{*tele.Btn{
+				Unique: BTN_SPLIT_TOGGLE,
+				Text: fmt.Sprintf(
+					"%s#%d %s %s",
+					mark(selected[[2]int{ci, ri}]),
+					ri+1,
+					r.Date.UTC().Format("2006-01-02"),
+					TruncateStr(strings.Join(r.Notes, "; "), SPLIT_NOTE_LIMIT),
+				),
+				Data: fmt.Sprintf("%s|%d|%d", prefix, ci, ri),
+			}.Inline()})
+		}
+	}
+
+	confirmBtn := *ConfirmSplitBtn
+	confirmBtn.Data = prefix
+
+	keyboard = append(keyboard, []tele.InlineButton{*CancelBtn.Inline(), *confirmBtn.Inline()})
+
+	return fmt.Sprintf(
+		"Splitting alias ID <code>%d</code> out of ID <code>%d</code>.\n\n"+
+			"Choose the records that belong to the alias; they'll be moved to its own user. "+
+			"<b>%d</b> of %d record%s selected.",
+		alias, user.TelegramID,
+		len(selected), CountRecords(user.Records), BoolToStr(CountRecords(user.Records) != 1, "s", ""),
+	), &tele.ReplyMarkup{InlineKeyboard: keyboard}
+}
+
+// Syntax:
+//
+//	- /split <ID/@username/mention/reply-to-message> <aliasID>
+func SplitHandler(c tele.Context) error {
+	args, args_err := ParseArgs(c, CommandSpecs[CMD_SPLIT])
+
+	if args_err != nil {
+		return ReplyArgsErr(c, CommandSpecs[CMD_SPLIT], args_err)
+	}
+
+	id, alias := args.Target.ID, int64(args.Int("alias-id"))
+
+	user, data_err := Data.FindByID(id)
+
+	if data_err != nil {
+		log.Printf(ERR_FMT_QUERY+"\n", data_err)
+		return c.Reply(MSG_ID_NOT_FOUND)
+	}
+
+	if (id == Config.OwnerTelegramID || user.Permission >= 4) && c.Sender().ID != Config.OwnerTelegramID {
+		return c.Reply("You can't change owner's data.")
+	}
+
+	if len(Undupe([]int64{alias}, user.AliasIDs)) > 0 {
+		return c.Reply(fmt.Sprintf("ID %d is not an alias of ID %d.", alias, id))
+	}
+
+	if _, err := Data.FindByID(alias); err == nil {
+		return c.Reply(fmt.Sprintf("ID %d is already registered on its own.", alias))
+	}
+
+	text, keyboard := SplitPrompt(user, alias, map[[2]int]bool{})
+
+	return c.Reply(text, keyboard, tele.ModeHTML)
+}
+
+func SplitToggleBtnHandler(c tele.Context) error {
+	id, alias, version, indexes, err := parseSplitData(c.Callback().Data)
+
+	if err != nil || len(indexes) != 2 {
+		return c.Edit("Invalid callback data.")
+	}
+
+	user, data_err := Data.FindByID(id)
+
+	if data_err != nil {
+		return c.Edit(MSG_ID_NOT_FOUND)
+	}
+
+	if user.Version != version {
+		return c.Edit("The user has changed in the meantime; start over.")
+	}
+
+	var (
+		selected   = splitSelection(user, c.Message().ReplyMarkup)
+		categories = SortedCategories(user.Records)
+
+		ci, ri = indexes[0], indexes[1]
+	)
+
+	if ci < 0 || ci >= len(categories) || ri >= len(user.Records[categories[ci]]) {
+		return c.Edit("Invalid callback data.")
+	}
+
+	if ri >= 0 {
+		key := [2]int{ci, ri}
+
+		if selected[key] {
+			delete(selected, key)
+		} else {
+			selected[key] = true
+		}
+	} else {
+		// A category is toggled as a whole: selected entirely, unless it already is.
+		all := true
+
+		for i := range user.Records[categories[ci]] {
+			all = all && selected[[2]int{ci, i}]
+		}
+
+		for i := range user.Records[categories[ci]] {
+			if all {
+				delete(selected, [2]int{ci, i})
+			} else {
+				selected[[2]int{ci, i}] = true
+			}
+		}
+	}
+
+	text, keyboard := SplitPrompt(user, alias, selected)
+
+	return c.Edit(text, keyboard, tele.ModeHTML)
+}
+
+func ConfirmSplitBtnHandler(c tele.Context) error {
+	id, alias, version, _, err := parseSplitData(c.Callback().Data)
+
+	if err != nil {
+		return c.Edit("Invalid callback data.")
+	}
+
+	user, data_err := Data.FindByID(id)
+
+	if data_err != nil {
+		return c.Edit(MSG_ID_NOT_FOUND)
+	}
+
+	if user.Version != version {
+		return c.Edit("The user has changed in the meantime; start over.")
+	}
+
+	if _, err = Data.FindByID(alias); err == nil {
+		return c.Edit(fmt.Sprintf("ID %d is already registered on its own.", alias))
+	}
+
+	var (
+		before, _  = cloneUser(user)
+		selected   = splitSelection(user, c.Message().ReplyMarkup)
+		categories = SortedCategories(user.Records)
+
+		split = User{
+			ID:         primitive.NewObjectID(),
+			TelegramID: alias,
+			Names:      make([]string, 0),
+			Usernames:  make([]string, 0),
+			AliasIDs:   make([]int64, 0),
+			Records:    map[string][]Record{},
+		}
+	)
+
+	for ci, category := range categories {
+		kept := make([]Record, 0, len(user.Records[category]))
+
+		for ri, r := range user.Records[category] {
+			if selected[[2]int{ci, ri}] {
+				split.Records[category] = append(split.Records[category], r)
+			} else {
+				kept = append(kept, r)
+			}
+		}
+
+		if len(kept) > 0 {
+			user.Records[category] = kept
+		} else {
+			delete(user.Records, category)
+		}
+	}
+
+	ForgetIdentity(&user, IDENTITY_ALIAS, strconv.FormatInt(alias, 10))
+
+	if err = Data.Add(split); errors.Is(err, ErrDuplicate) {
+		return c.Edit(fmt.Sprintf("ID %d is already registered on its own.", alias))
+	} else if err != nil {
+		log.Printf(ERR_FMT_ADD+"\n", err)
+		return c.Edit(MSG_COULD_NOT_PERFORM)
+	}
+
+	if err = Data.ReplaceByID(id, user); err != nil {
+		Data.RemoveByID(alias)
+
+		if errors.Is(err, ErrConflict) {
+			return c.Edit("The user has changed in the meantime; start over.")
+		}
+
+		log.Printf(ERR_FMT_UPDATE+"\n", err)
+		return c.Edit(MSG_COULD_NOT_PERFORM)
+	}
+
+	AuditLog(c.Sender().ID, id, AUDIT_SPLIT, &before, &user)
+	AuditLog(c.Sender().ID, alias, AUDIT_SPLIT, nil, &split)
+
+	// logging
+
+	name := c.Sender().FirstName + " " + c.Sender().LastName
+
+	ChanLogf("#split\n[<code>%d</code>] %shas split alias ID <code>%d</code> out of ID <code>%d</code>, with %d record%s.",
+		c.Sender().ID,
+		BoolToStr(name != "", name+" ", ""),
+		alias,
+		id,
+		len(selected),
+		BoolToStr(len(selected) != 1, "s", ""),
+	)
+
+	// returning
+
+	return c.Edit(fmt.Sprintf(
+		"ID <code>%d</code> is now registered on its own, with %d record%s.",
+		alias, len(selected), BoolToStr(len(selected) != 1, "s", ""),
+	), tele.ModeHTML)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	tele "github.com/Henry96Markle/telebot"
+)
+
+func TestSplitHandler(t *testing.T) {
+	target := recordedTarget()
+	target.AliasIDs = []int64{3001, 2001}
+
+	tests := []struct {
+		name   string
+		sender int64
+		text   string
+		reply  string
+	}{
+		{name: "prompt", sender: testWriterID, text: "/split 2000 3001", reply: "Splitting alias ID <code>3001</code> out of ID <code>2000</code>."},
+		{name: "not an alias", sender: testWriterID, text: "/split 2000 3002", reply: "ID 3002 is not an alias of ID 2000."},
+		{name: "registered", sender: testWriterID, text: "/split 2000 2001", reply: "ID 2001 is already registered on its own."},
+		{name: "not found", sender: testWriterID, text: "/split 4000 3001", reply: MSG_ID_NOT_FOUND},
+		{name: "username", sender: testWriterID, text: "/split @miles 3001", reply: "Splitting alias ID <code>3001</code> out of ID <code>2000</code>."},
+		{name: "insufficient", sender: testWriterID, text: "/split 2000", reply: "Missing <alias-id>."},
+		{name: "invalid", sender: testWriterID, text: "/split 2000 abc", reply: "Invalid alias-id: \"abc\" isn't a number."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTest(t, target, newTestUser(2001, 0))
+
+			ctx := newCommand(tt.sender, testGroupID, tt.text, nil)
+
+			if err := SplitHandler(ctx); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !strings.HasPrefix(ctx.last(), tt.reply) {
+				t.Errorf("expected reply %q, got %q", tt.reply, ctx.last())
+			}
+		})
+	}
+
+	// Presses the button at row, col of markup, as if it was sent in a message with it.
+	press := func(t *testing.T, markup *tele.ReplyMarkup, row, col int, handler tele.HandlerFunc) *fakeContext {
+		btn := markup.InlineKeyboard[row][col]
+		ctx := newCallback(testWriterID, btn.Unique, "\f"+btn.Unique+"|"+btn.Data)
+		ctx.message.ReplyMarkup = markup
+
+		if err := handler(ctx); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		return ctx
+	}
+
+	t.Run("confirm", func(t *testing.T) {
+		setupTest(t, target)
+
+		ctx := newCommand(testWriterID, testGroupID, "/split 2000 3001", nil)
+		SplitHandler(ctx)
+
+		// Rows: bans, bans #1, bans #2, warns, warns #1, then cancel and confirm.
+		ctx = press(t, ctx.lastMarkup(), 3, 0, SplitToggleBtnHandler)
+
+		if markup := ctx.lastMarkup(); !strings.HasPrefix(markup.InlineKeyboard[4][0].Text, SPLIT_SELECTED) {
+			t.Fatalf("expected the category's records to be selected, got %q", markup.InlineKeyboard[4][0].Text)
+		}
+
+		ctx = press(t, ctx.lastMarkup(), 2, 0, SplitToggleBtnHandler)
+
+		if !strings.Contains(ctx.last(), "<b>2</b> of 3 records selected.") {
+			t.Fatalf("unexpected prompt %q", ctx.last())
+		}
+
+		ctx = press(t, ctx.lastMarkup(), 5, 1, ConfirmSplitBtnHandler)
+
+		if !strings.HasPrefix(ctx.last(), "ID <code>3001</code> is now registered on its own, with 2 records.") {
+			t.Fatalf("unexpected reply %q", ctx.last())
+		}
+
+		original := mustFind(t, testTargetID)
+
+		if len(original.AliasIDs) != 1 || original.AliasIDs[0] != 2001 {
+			t.Errorf("expected the alias to be removed, got %v", original.AliasIDs)
+		}
+
+		if len(original.Records) != 1 || len(original.Records["bans"]) != 1 || original.Records["bans"][0].Notes[0] != "first" {
+			t.Errorf("unexpected remaining records %v", original.Records)
+		}
+
+		split := mustFind(t, 3001)
+
+		if len(split.Records["bans"]) != 1 || split.Records["bans"][0].Notes[0] != "second" || len(split.Records["warns"]) != 1 {
+			t.Errorf("unexpected moved records %v", split.Records)
+		}
+
+		if _, count, _ := Data.FindAudit(3001, AUDIT_SPLIT, time.Time{}, 0, 1); count != 1 {
+			t.Errorf("expected an audit entry")
+		}
+	})
+
+	t.Run("stale", func(t *testing.T) {
+		setupTest(t, target)
+
+		ctx := newCommand(testWriterID, testGroupID, "/split 2000 3001", nil)
+		SplitHandler(ctx)
+
+		markup := ctx.lastMarkup()
+
+		UpdateUser(testTargetID, func(u *User) error {
+			u.Description = "changed"
+			return nil
+		})
+
+		ctx = press(t, markup, 5, 1, ConfirmSplitBtnHandler)
+
+		if !strings.HasPrefix(ctx.last(), "The user has changed") {
+			t.Errorf("unexpected reply %q", ctx.last())
+		}
+
+		if _, err := Data.FindByID(3001); err == nil {
+			t.Errorf("expected the alias to stay unregistered")
+		}
+	})
+}
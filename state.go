@@ -3,8 +3,10 @@ package main
 import (
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"strings"
+	"time"
 
 	tele "github.com/Henry96Markle/telebot"
 )
@@ -13,6 +15,41 @@ const (
 	DATABASE_NAME   = "telegram"
 	COLLECTION_NAME = "user-records"
 
+	AUDIT_COLLECTION_NAME = "audit-log"
+	TRASH_COLLECTION_NAME = "trash"
+	META_COLLECTION_NAME  = "meta"
+
+	// The key of the schema version in the meta collection.
+	META_SCHEMA_VERSION = "schema_version"
+
+	TRASH_USER    = "user"
+	TRASH_RECORDS = "records"
+
+	TRASH_DEFAULT_RETENTION = 30 // days
+
+	// Storage backends
+
+	STORAGE_MONGO  = "mongo"
+	STORAGE_BOLT   = "bolt"
+	STORAGE_MEMORY = "memory"
+
+	BOLT_DEFAULT_PATH = "./botone.db"
+
+	MAX_UPDATE_ATTEMPTS = 3
+
+	// Identity history
+
+	IDENTITY_NAME     = "name"
+	IDENTITY_USERNAME = "username"
+	IDENTITY_ALIAS    = "alias_id"
+
+	SOURCE_REGISTRATION = "registration"
+	SOURCE_MANUAL       = "manual"
+	SOURCE_OBSERVED     = "observed"
+	SOURCE_MERGE        = "merge"
+	SOURCE_MIGRATION    = "migration"
+	SOURCE_IMPORT       = "import"
+
 	CMD_HELP    = "help"
 	CMD_REG     = "reg"
 	CMD_UNREG   = "unreg"
@@ -23,6 +60,17 @@ const (
 	CMD_CREDITS = "credits"
 	CMD_PERM    = "perm"
 	CMD_DELREC  = "delrec"
+	CMD_AUDIT   = "audit"
+	CMD_TRASH   = "trash"
+	CMD_RESTORE = "restore"
+	CMD_MERGE   = "merge"
+	CMD_SPLIT   = "split"
+	CMD_SEARCH  = "search"
+	CMD_EXPORT  = "export"
+	CMD_BACKUP  = "backup"
+	CMD_IMPORT  = "import"
+	CMD_EDITREC = "editrec"
+	CMD_MINE    = "myrecords"
 
 	// Button unique strings
 
@@ -40,6 +88,26 @@ const (
 
 	BTN_DELETE_ENTRY = "deleteEntryBtn"
 
+	BTN_AUDIT_PAGE = "auditPageBtn"
+
+	BTN_CONFIRM_MERGE = "confirmMergeBtn"
+	BTN_CANCEL        = "cancelActionBtn"
+	BTN_SPLIT_TOGGLE  = "splitToggleBtn"
+	BTN_CONFIRM_SPLIT = "confirmSplitBtn"
+
+	BTN_CONFIRM_RESTORE = "confirmRestoreBtn"
+	BTN_CONFIRM_IMPORT  = "confirmImportBtn"
+	BTN_EVIDENCE        = "evidenceBtn"
+	BTN_QUICK_REG       = "quickRegBtn"
+	BTN_QUICK_RECORD    = "quickRecordBtn"
+
+	// The permission key of messages forwarded to the bot, to be identified.
+	ON_FORWARD = "\aforward"
+
+	BTN_PROFILE_PAGE = "profilePageBtn"
+	BTN_MATCHES_PAGE = "matchesPageBtn"
+	BTN_OPEN_PROFILE = "openProfileBtn"
+
 	BTN_CANCEL_OPERATOR_CONFIRMATION = "cancelBtn"
 	BTN_CONFIRM_OPERATOR             = "confirmOperatorBtn"
 
@@ -56,32 +124,51 @@ const (
 		"With Botone, you can keep track of their identities and record " +
 		"their most significant actions, so you don't have to worry about forgetting and feeling like " +
 		"everyone on telegram is the same person.\n\n" +
+		"Wherever a command takes a <code>&lt;user&gt;</code>, you can give their ID, their @username or a mention, " +
+		"or reply to one of their messages. If you do both, the user you give wins over the reply; " +
+		"so in a reply, a description or a note can't start with an ID or an @username, unless the user is given first.\n\n" +
 		"Click on the buttons below, to learn each command."
 
 	HELP_DELREC = "Delete one record or more. You can delete a single record, an entire category, " +
-		"or all the records from a user.\n\nSyntax:\n\n/delrec <ID/reply-to-message> [category] [note index]"
+		"or all the records from a user.\n\nSyntax:\n\n%s"
+
+	HELP_EDITREC = "Edit a record in place, keeping its date and chat. The index counts the records of the category " +
+		"from 1, as /recall lists them; every edit is kept in the record's history.\n\nSyntax:\n\n%s\n\n" +
+		"The value depends on the operation:\n\n" +
+		"- replace: the index of the note, then the new note\n" +
+		"- append: the notes to add, as in /record\n" +
+		"- remove: the index of the note\n" +
+		"- move: the new category"
+
+	HELP_MINE = "List the records you wrote, the latest first; give some text to only list those whose category " +
+		"or notes contain it.\n\nSyntax:\n\n%s"
 
 	HELP_RECALL = "Recall information about a person who's registered before. " +
-		"You can use IDs, usernames, or names.\n\nSyntax:\n\n" +
-		"- /recall <ID/reply-to-message>\n\n" +
-		"- /recall name <name>\n\nExamples:\n\n" +
-		"- /recall username <username>\n\n" +
-		"/recall 69696969\n/recall name Miles Edgeworth"
-	HELP_REG = "Register new users.\n\nSyntax:\n\n/reg <ID/reply-to-message> [description]"
+		"You can use IDs, usernames, or names.\n\nSyntax:\n\n%s\n\n" +
+		"An ID or an @username recalls that user; any other query is searched for among names, usernames " +
+		"and descriptions, or only names or usernames if you say which. " +
+		"Replying to someone's message with /recall alone recalls them. " +
+		"Replying to a forwarded message recalls its original sender; forwarding a message to the bot in PM does too.\n\n" +
+		"Names and usernames don't have to be exact: parts of them, or slight typos, match too, " +
+		"and the closest matches come first.\n\nExamples:\n\n" +
+		"/recall 69696969\n/recall name Miles Edgeworth\n/recall edgewoth"
+	HELP_REG = "Register new users.\n\nSyntax:\n\n%s"
 
 	HELP_UNREG = "There are some people you just want to forget.\n" +
-		"Unregister and delete them from the database.\n\nSyntax:\n\n" +
-		"- /unreg <ID/reply-to-message>"
+		"Unregister and delete them from the database. They're kept in the trash for a while, " +
+		"in case you change your mind; see /restore.\n\nSyntax:\n\n%s"
 
-	HELP_HELP = "Learn each command's syntax by typing /help followed by the name of the command.\n\nSyntax:\n\n" +
-		"- /help\n- /help <command>"
+	HELP_HELP = "Learn each command's syntax by typing /help followed by the name of the command.\n\nSyntax:\n\n%s"
 
-	HELP_RECORD = "Write down what the user did under a certain category.\n\n" +
-		"Syntax:\n\n/record <ID/reply-to-message> <category> [note1; note2; note3; ..]\n\nExample:\n\n" +
+	HELP_CREDITS = "Show the version of the bot, and who made it.\n\nSyntax:\n\n%s"
+
+	HELP_RECORD = "Write down what the user did under a certain category. " +
+		"When used as a reply, the replied-to message can be kept as evidence, if the bot is set to capture it.\n\n" +
+		"Syntax:\n\n%s\n\nExample:\n\n" +
 		"/record 69696969 bans shared a pirated movie; he blamed me for eating his sandwish"
 
 	HELP_SET = "Set description to a user record.\n" +
-		"Syntax:\n\n/set <ID/reply-to-message> <description>\n\n" +
+		"Syntax:\n\n%s\n\n" +
 		"Example:\n\n/set 6969669 My brother-in-law.\n"
 
 	HELP_PERM = "You can allow others to use your bot, but " +
@@ -99,15 +186,57 @@ const (
 		"\n\nBy default, every newly registered user has permission level 0, " +
 		"which means that they can't interract with the bot at all.\n\n" +
 		"You can increase the amount of control they have, with the /perm command.\n\n" +
-		"By granting them permission level 1, you only allow them to use the /recall command and inline queries.\n\n" +
-		"Permission level 2 unlocks the rest of the commands for the user, except for /perm.\n\n" +
+		"By granting them permission level 1, you only allow them to look users up: /recall, /search, /export " +
+		"and inline queries.\n\n" +
+		"Permission level 2 unlocks the commands that write, such as /reg and /record; the list above tells which " +
+		"level each command needs.\n\n" +
 		"Permission level 3 is the operator eccess permission. Operators can grant or revoke others' " +
 		"permissions, but they obviously can't grant others permission level 3. Only the owner of the bot can do that.\n\n" +
-		"Syntax:\n\n" +
-		"- /perm <ID/reply-to-message>\n- /perm <ID/reply-to-message> set <permission-level>"
+		"Without a level, the user's current permission level is shown.\n\nSyntax:\n\n%s"
+
+	HELP_AUDIT = "Go through the history of changes made to a user, newest first. " +
+		"You can narrow it down to one action (%s), " +
+		"and to the entries made since a date or within a period of time.\n\nSyntax:\n\n" +
+		"%s\n\nExamples:\n\n" +
+		"/audit 69696969\n/audit 69696969 record 7d\n/audit 69696969 2022-07-01"
+
+	HELP_TRASH = "Unregistered users and deleted records aren't gone right away; they're kept in the trash " +
+		"for a while, until they expire. List everything that's in the trash, or only what belongs to one user.\n\nSyntax:\n\n%s"
+
+	HELP_RESTORE = "Bring a user back from the trash, along with all of their deleted records. " +
+		"If the user is still registered, only the records are restored.\n\nSyntax:\n\n" +
+		"%s\n\n" +
+		"The owner can also reply to a /backup archive, to restore every user in it. " +
+		"Merging (the default) adds the missing users and records; replacing makes the users exactly those of the backup.\n\n" +
+		"%s, in a reply to the backup"
+
+	HELP_MERGE = "When two registered users turn out to be the same person, merge the second one into the first. " +
+		"Its names, usernames, IDs, description and records are added to the first user, " +
+		"and the second one is unregistered.\n\nSyntax:\n\n" +
+		"%s\n\nThe merged user is given by ID or @username.\n\nExample:\n\n/merge 69696969 @phoenix"
+
+	HELP_SEARCH = "Search the notes and categories of every record, and the descriptions of every user. " +
+		"All the words have to appear, in any order, regardless of case. " +
+		"Inline queries starting with \"" + NOTES_QUERY_PREFIX + "\" search the same way.\n\nSyntax:\n\n" +
+		"%s\n\nExample:\n\n/search spam links"
+
+	HELP_EXPORT = "Send everything about a user in a file: JSON (the default), CSV, HTML or Markdown.\n\nSyntax:\n\n" +
+		"%s\n\nExample:\n\n/export 69696969 html"
+
+	HELP_BACKUP = "Send a compressed archive of every user to the owner, in PM. " +
+		"Reply to it with /restore to bring the users back.\n\nSyntax:\n\n%s"
+
+	HELP_IMPORT = "Register users and add records in bulk, from a CSV or JSON file laid out as /export writes them. " +
+		"Several users can share one file, and record notes are separated by \";\"; a \";\" within a note is written \"\\;\", and a \"\\\" \"\\\\\". " +
+		"Registered users only get the identities and records they lack; permission levels are never imported. " +
+		"A preview is shown before anything is imported.\n\nSyntax:\n\n%s, in a reply to the file"
+
+	HELP_SPLIT = "The reverse of /merge: when an alias ID was added to the wrong person, " +
+		"register it as its own user, and choose which of the records go with it.\n\nSyntax:\n\n" +
+		"%s\n\nExample:\n\n/split 69696969 42042042"
 
 	HELP_ALIAS = "Add more IDs, names, or usernames that belong to the same person.\n\nSyntax:\n\n" +
-		"/alias <ID/reply-to-message> <add/remove> <id/name/username> <value1>; <value2> ..\n\nExample:\n\n" +
+		"%s\n\nExample:\n\n" +
 		"/alias 69696969 add name Henry Markle; Steward; Rose Smith"
 
 	// Messages
@@ -119,6 +248,7 @@ const (
 	MSG_INSUFFICIENT_ARGS = "Insufficient arguments"
 	MSG_COULD_NOT_PERFORM = "Could not perform this action"
 	MSG_UNAUTHORIZED      = "You're unauthorized to perform this action"
+	MSG_CONFLICT          = "Someone else has modified this user in the meantime; try again."
 
 	ERR_FMT_ADD    = "error adding ID: %v"
 	ERR_FMT_QUERY  = "error finding ID: %v"
@@ -126,33 +256,191 @@ const (
 	ERR_FMT_UPDATE = "error updating ID: %v"
 	ERR_FMT_PARSE  = "error parsing string: %v"
 
+	// Audit actions
+
+	AUDIT_REG        = "reg"
+	AUDIT_UNREG      = "unreg"
+	AUDIT_RECORD     = "record"
+	AUDIT_DELREC     = "delrec"
+	AUDIT_ALIAS      = "alias"
+	AUDIT_SET        = "set"
+	AUDIT_PERM       = "perm"
+	AUDIT_OP_CONFIRM = "op_confirm"
+	AUDIT_RESTORE    = "restore"
+	AUDIT_MERGE      = "merge"
+	AUDIT_SPLIT      = "split"
+	AUDIT_NAMECHANGE = "namechange"
+	AUDIT_BACKUP     = "backup_restore"
+	AUDIT_IMPORT     = "import"
+	AUDIT_EDITREC    = "editrec"
+
+	AUDIT_PAGE_SIZE   = 5
+	AUDIT_VALUE_LIMIT = 200
+
+	TRASH_LIST_LIMIT = 30
+
+	// Search scores range from 0 to 1; fuzzy matches are scaled down, so that substrings rank above them.
+	SEARCH_MIN_SCORE          = 0.5
+	SEARCH_PAST_WEIGHT        = 0.9
+	SEARCH_DESCRIPTION_WEIGHT = 0.7
+	SEARCH_LIMIT              = 50
+
+	// Inline queries shorter than this, in runes, aren't searched.
+	INLINE_MIN_QUERY = 3
+
+	// The prefix of inline queries that search notes instead of identities.
+	NOTES_QUERY_PREFIX = "notes:"
+	NOTES_HITS_LIMIT   = 30
+	SNIPPET_RADIUS     = 40
+	// How much of the query the title of the hits repeats, in runes.
+	NOTES_QUERY_SHOWN = 100
+
+	// Export formats
+
+	EXPORT_JSON     = "json"
+	EXPORT_CSV      = "csv"
+	EXPORT_HTML     = "html"
+	EXPORT_MARKDOWN = "md"
+
+	// The columns of EXPORT_CSV_HEADER an import needs; the record provenance columns are optional.
+	EXPORT_CSV_REQUIRED = 6
+
+	// How many senders the identity tracker remembers, and for how long, to spare the database.
+	IDENTITY_CACHE_SIZE = 10000
+	IDENTITY_CACHE_TTL  = 6 * time.Hour
+	// How many senders can wait for the tracker before new ones are dropped.
+	IDENTITY_QUEUE_SIZE = 256
+
+	// How many evidence buttons a profile shows.
+	EVIDENCE_BUTTONS_LIMIT = 6
+
+	// Record edits
+
+	EDITREC_REPLACE = "replace"
+	EDITREC_APPEND  = "append"
+	EDITREC_REMOVE  = "remove"
+	EDITREC_MOVE    = "move"
+
+	// Subcommands
+
+	SUBCMD_SERVE   = "serve"
+	SUBCMD_MIGRATE = "migrate"
+	SUBCMD_EXPORT  = "export"
+	SUBCMD_IMPORT  = "import"
+	SUBCMD_USER    = "user"
+	SUBCMD_PERM    = "perm"
+
+	SUBCMD_USAGE = "usage: botone [subcommand] [arguments]\n\n" +
+		"\tserve [-poll] [-migrate [-dry-run]]   run the bot; the default\n" +
+		"\tmigrate [-dry-run]                    apply the pending schema migrations\n" +
+		"\texport [-o <file>] <ID> [format]      export a user as json, csv, html or md\n" +
+		"\timport [-yes] <file>                  import users from a CSV or JSON file\n" +
+		"\tuser get <ID>                         show a user's profile\n" +
+		"\tperm get <ID>                         show a user's permission level\n" +
+		"\tperm set <ID> <permission-level>      set a user's permission level, up to 3\n"
+
+	// Backups
+
+	BACKUP_FORMAT  = "botone-backup"
+	BACKUP_VERSION = 1
+	// The largest archive /restore reads, uncompressed.
+	BACKUP_MAX_SIZE = 64 << 20
+
+	// Imports
+
+	// The largest file /import reads.
+	IMPORT_MAX_SIZE = 10 << 20
+	// How many conflicts an import preview lists.
+	IMPORT_CONFLICTS_SHOWN = 10
+
+	RESTORE_MERGE   = "merge"
+	RESTORE_REPLACE = "replace"
+
+	// Telegram's limits
+	MESSAGE_LENGTH_LIMIT = 4096
+	CALLBACK_DATA_LIMIT  = 64
+
+	// Leaves room for the header and the page number.
+	PROFILE_PAGE_LIMIT = 3800
+	MATCHES_PAGE_SIZE  = 10
+
+	// The most choices of a parameter that its syntax lists; past that, it's shown by name.
+	ENUM_CHOICES_SHOWN = 4
+
+	// How long a result is kept for the "Send in a file" button.
+	RESULT_TTL = 30 * time.Minute
+
+	SPLIT_SELECTED           = "✅"
+	SPLIT_UNSELECTED         = "⬜"
+	SPLIT_MAX_RECORD_BUTTONS = 80
+	SPLIT_NOTE_LIMIT         = 30
+
 	// Formatted messages
 
 	//
 
-	BUFF_FILE_PATH = "./b.txt"
-
 	VERSION = "0.59"
 )
 
+// Kinds of command parameters; see ParseArgs.
+const (
+	PARAM_TARGET = iota // a user: an ID, an @username, a mention or a reply
+	PARAM_WORD          // a single word
+	PARAM_ENUM          // a word out of the param's choices
+	PARAM_INT           // a whole number
+	PARAM_REST          // the rest of the arguments, as a line
+	PARAM_LIST          // the rest of the arguments, as a ";"-separated list
+	PARAM_USER          // another user, besides the target: an ID or an @username
+	PARAM_DATE          // a date (2006-01-02) or a period of time back from now (7d, 36h); see ParseSince
+)
+
 var (
 	// main.go
 	Config *Configuration
 
 	Bot *tele.Bot
 
-	Data *Database
+	Data Store
 
 	TermSig chan os.Signal
 
 	Polling = false
 
+	// Where the subcommands write their output.
+	Stdout io.Writer = os.Stdout
+
+	// Every command-line subcommand, by name.
+	Subcommands = map[string]func(args []string) error{
+		SUBCMD_SERVE:   Serve,
+		SUBCMD_MIGRATE: MigrateCommand,
+		SUBCMD_EXPORT:  ExportCommand,
+		SUBCMD_IMPORT:  ImportCommand,
+		SUBCMD_USER:    UserCommand,
+		SUBCMD_PERM:    PermCommand,
+	}
+
+	// Run the pending migrations and exit, instead of starting the bot.
+	Migrating = false
+	// Only report what the migrations would do.
+	DryRun = false
+
+	// migrate.go
+
+	// Every schema migration, in the order they're applied. Only append to this list;
+	// versions that have been released must never change.
+	Migrations = []Migration{
+		{Version: 1, Description: "fill in missing names, usernames, alias IDs and records", Apply: NormalizeUser},
+		{Version: 2, Description: "add an identity history entry for every identity", Apply: MigrateHistory},
+	}
+
 	// bot.go
 
 	Commands = []string{
 		CMD_HELP, CMD_REG, CMD_RECORD, CMD_ALIAS,
 		CMD_RECALL, CMD_UNREG, CMD_SET, CMD_CREDITS,
-		CMD_PERM, CMD_DELREC,
+		CMD_PERM, CMD_DELREC, CMD_AUDIT, CMD_TRASH,
+		CMD_RESTORE, CMD_MERGE, CMD_SPLIT, CMD_SEARCH,
+		CMD_EXPORT, CMD_BACKUP, CMD_IMPORT, CMD_EDITREC, CMD_MINE,
 	}
 
 	CommandMap = map[string]func(tele.Context) error{
@@ -166,6 +454,17 @@ var (
 		CMD_SET:     SetHandler,
 		CMD_PERM:    PermHandler,
 		CMD_DELREC:  DelrecHandler,
+		CMD_AUDIT:   AuditHandler,
+		CMD_TRASH:   TrashHandler,
+		CMD_RESTORE: RestoreHandler,
+		CMD_MERGE:   MergeHandler,
+		CMD_SPLIT:   SplitHandler,
+		CMD_SEARCH:  SearchHandler,
+		CMD_EXPORT:  ExportHandler,
+		CMD_BACKUP:  BackupHandler,
+		CMD_IMPORT:  ImportHandler,
+		CMD_EDITREC: EditrecHandler,
+		CMD_MINE:    MyrecordsHandler,
 	}
 
 	Permissions = map[string]int{
@@ -178,7 +477,18 @@ var (
 		CMD_UNREG:   2,
 		CMD_REG:     2,
 		CMD_DELREC:  2,
+		CMD_TRASH:   2,
+		CMD_RESTORE: 2,
+		CMD_MERGE:   2,
+		CMD_SPLIT:   2,
+		CMD_SEARCH:  1,
+		CMD_EXPORT:  1,
+		CMD_BACKUP:  4,
+		CMD_IMPORT:  3,
+		CMD_EDITREC: 2,
+		CMD_MINE:    2,
 		CMD_PERM:    3,
+		CMD_AUDIT:   3,
 
 		BTN_UPLOAD_RESULT:                1,
 		BTN_BACK_TO_HELP:                 1,
@@ -190,12 +500,26 @@ var (
 		BTN_UNREG_HELP:                   2,
 		BTN_SET_HELP:                     2,
 		BTN_DELETE_ENTRY:                 2,
+		BTN_CONFIRM_MERGE:                2,
+		BTN_CANCEL:                       2,
+		BTN_SPLIT_TOGGLE:                 2,
+		BTN_CONFIRM_SPLIT:                2,
+		BTN_CONFIRM_RESTORE:              4,
+		BTN_CONFIRM_IMPORT:               3,
+		BTN_EVIDENCE:                     1,
+		BTN_QUICK_REG:                    2,
+		BTN_QUICK_RECORD:                 2,
+		BTN_PROFILE_PAGE:                 1,
+		BTN_MATCHES_PAGE:                 1,
+		BTN_OPEN_PROFILE:                 1,
 		BTN_PERM_HELP:                    3,
 		BTN_SET_PERM:                     3,
+		BTN_AUDIT_PAGE:                   3,
 		BTN_CANCEL_OPERATOR_CONFIRMATION: 4,
 		BTN_CONFIRM_OPERATOR:             4,
 
 		tele.OnQuery: 1,
+		ON_FORWARD:   1,
 	}
 
 	PermissionNames = map[int]string{
@@ -207,24 +531,134 @@ var (
 	}
 
 	CommandSyntax = map[string]string{
-		CMD_REG:    HELP_REG,
-		CMD_RECORD: HELP_RECORD,
-		CMD_RECALL: HELP_RECALL,
-		CMD_ALIAS:  HELP_ALIAS,
-		CMD_HELP:   HELP_HELP,
-		CMD_UNREG:  HELP_UNREG,
-		CMD_SET:    HELP_SET,
-		CMD_DELREC: HELP_DELREC,
+		CMD_REG:     CommandHelp(CMD_REG, HELP_REG),
+		CMD_RECORD:  CommandHelp(CMD_RECORD, HELP_RECORD),
+		CMD_RECALL:  CommandHelp(CMD_RECALL, HELP_RECALL),
+		CMD_ALIAS:   CommandHelp(CMD_ALIAS, HELP_ALIAS),
+		CMD_HELP:    CommandHelp(CMD_HELP, HELP_HELP),
+		CMD_CREDITS: CommandHelp(CMD_CREDITS, HELP_CREDITS),
+		CMD_UNREG:   CommandHelp(CMD_UNREG, HELP_UNREG),
+		CMD_SET:     CommandHelp(CMD_SET, HELP_SET),
+		CMD_DELREC:  CommandHelp(CMD_DELREC, HELP_DELREC),
+		CMD_TRASH:   CommandHelp(CMD_TRASH, HELP_TRASH),
+		CMD_MERGE:   CommandHelp(CMD_MERGE, HELP_MERGE),
+		CMD_SPLIT:   CommandHelp(CMD_SPLIT, HELP_SPLIT),
+		CMD_SEARCH:  CommandHelp(CMD_SEARCH, HELP_SEARCH),
+		CMD_EXPORT:  CommandHelp(CMD_EXPORT, HELP_EXPORT),
+		CMD_BACKUP:  CommandHelp(CMD_BACKUP, HELP_BACKUP),
+		CMD_IMPORT:  CommandHelp(CMD_IMPORT, HELP_IMPORT),
+		CMD_EDITREC: CommandHelp(CMD_EDITREC, HELP_EDITREC),
+		CMD_MINE:    CommandHelp(CMD_MINE, HELP_MINE),
+		CMD_AUDIT:   fmt.Sprintf(HELP_AUDIT, strings.Join(AUDIT_ACTIONS, ", "), Usage(CommandSpecs[CMD_AUDIT])),
+		CMD_RESTORE: fmt.Sprintf(HELP_RESTORE, Usage(CommandSpecs[CMD_RESTORE]), Usage(RestoreBackupSpec)),
 		CMD_PERM: fmt.Sprintf(HELP_PERM, strings.Join(MaptoSlice(Permissions, func(k string, v int) (string, error) {
-			if !strings.HasSuffix(k, "Btn") && k != "\aquery" {
+			if !strings.HasSuffix(k, "Btn") && !strings.HasPrefix(k, "\a") {
 				return fmt.Sprintf("/%s: %d", k, v), nil
 			} else {
 				return "", errors.New("must be a command")
 			}
-		}), "\n")),
+		}), "\n"), Usage(CommandSpecs[CMD_PERM])),
+	}
+
+	// The parameters of every command, which their handlers parse with ParseArgs.
+	CommandSpecs = map[string]CommandSpec{
+		CMD_HELP: {Name: CMD_HELP, Description: "Learn how to use the bot", Params: []Param{
+			{Name: "command", Kind: PARAM_WORD, Optional: true},
+		}},
+		CMD_CREDITS: {Name: CMD_CREDITS, Description: "Show the credits"},
+		CMD_RECALL: {Name: CMD_RECALL, Description: "Recall what's known about a user", Params: []Param{
+			{Name: "field", Kind: PARAM_ENUM, Choices: []string{"name", "username"}, Optional: true},
+			{Name: "query", Kind: PARAM_REST},
+		}},
+		CMD_REG: {Name: CMD_REG, Description: "Register a user", Params: []Param{
+			{Kind: PARAM_TARGET},
+			{Name: "description", Kind: PARAM_REST, Optional: true},
+		}},
+		CMD_UNREG: {Name: CMD_UNREG, Description: "Unregister a user", Params: []Param{
+			{Kind: PARAM_TARGET},
+		}},
+		CMD_RECORD: {Name: CMD_RECORD, Description: "Record what a user did", Params: []Param{
+			{Kind: PARAM_TARGET},
+			{Name: "category", Kind: PARAM_WORD},
+			{Name: "note", Kind: PARAM_LIST, Optional: true},
+		}},
+		CMD_SET: {Name: CMD_SET, Description: "Set a user's description", Params: []Param{
+			{Kind: PARAM_TARGET},
+			{Name: "description", Kind: PARAM_REST},
+		}},
+		CMD_PERM: {Name: CMD_PERM, Description: "Show or set a user's permission level", Params: []Param{
+			{Kind: PARAM_TARGET},
+			{Name: "operation", Kind: PARAM_ENUM, Choices: []string{"set"}, Optional: true},
+			{Name: "level", Kind: PARAM_INT},
+		}},
+		CMD_DELREC: {Name: CMD_DELREC, Description: "Delete a user's records", Params: []Param{
+			{Kind: PARAM_TARGET},
+			{Name: "category", Kind: PARAM_WORD, Optional: true},
+			{Name: "index", Kind: PARAM_INT, Optional: true},
+		}},
+		CMD_ALIAS: {Name: CMD_ALIAS, Description: "Add or remove a user's aliases", Params: []Param{
+			{Kind: PARAM_TARGET},
+			{Name: "operation", Kind: PARAM_ENUM, Choices: []string{"add", "remove"}},
+			{Name: "kind", Kind: PARAM_ENUM, Choices: []string{"name", "id", "username"}},
+			{Name: "value", Kind: PARAM_LIST},
+		}},
+		CMD_EDITREC: {Name: CMD_EDITREC, Description: "Edit a record in place", Params: []Param{
+			{Kind: PARAM_TARGET},
+			{Name: "category", Kind: PARAM_WORD},
+			{Name: "index", Kind: PARAM_INT},
+			{Name: "operation", Kind: PARAM_ENUM, Choices: []string{EDITREC_REPLACE, EDITREC_APPEND, EDITREC_REMOVE, EDITREC_MOVE}},
+			{Name: "value", Kind: PARAM_REST},
+		}},
+		CMD_TRASH: {Name: CMD_TRASH, Description: "List the trash", Params: []Param{
+			{Kind: PARAM_TARGET, Optional: true},
+		}},
+		CMD_RESTORE: {Name: CMD_RESTORE, Description: "Restore a user from the trash", Params: []Param{
+			{Kind: PARAM_TARGET},
+		}},
+		CMD_MERGE: {Name: CMD_MERGE, Description: "Merge a user into another", Params: []Param{
+			{Kind: PARAM_TARGET},
+			{Name: "merged-user", Kind: PARAM_USER},
+		}},
+		CMD_SPLIT: {Name: CMD_SPLIT, Description: "Register an alias ID as its own user", Params: []Param{
+			{Kind: PARAM_TARGET},
+			{Name: "alias-id", Kind: PARAM_INT},
+		}},
+		CMD_EXPORT: {Name: CMD_EXPORT, Description: "Send a user in a file", Params: []Param{
+			{Kind: PARAM_TARGET},
+			{Name: "format", Kind: PARAM_ENUM, Choices: EXPORT_FORMATS, Optional: true},
+		}},
+		CMD_AUDIT: {Name: CMD_AUDIT, Description: "Show the changes made to a user", Params: []Param{
+			{Kind: PARAM_TARGET},
+			{Name: "action", Kind: PARAM_ENUM, Choices: AUDIT_ACTIONS, Optional: true},
+			{Name: "since", Kind: PARAM_DATE, Optional: true},
+		}},
+		CMD_SEARCH: {Name: CMD_SEARCH, Description: "Search the notes of every record", Params: []Param{
+			{Name: "text", Kind: PARAM_REST},
+		}},
+		CMD_MINE: {Name: CMD_MINE, Description: "List the records you wrote", Params: []Param{
+			{Name: "text", Kind: PARAM_REST, Optional: true},
+		}},
+		CMD_BACKUP: {Name: CMD_BACKUP, Description: "Send a backup of every user"},
+		CMD_IMPORT: {Name: CMD_IMPORT, Description: "Import users from the replied-to file"},
+	}
+
+	// The parameters of /restore in a reply to a backup, which restores the backup instead of a user.
+	RestoreBackupSpec = CommandSpec{Name: CMD_RESTORE, Description: "Restore a backup", Params: []Param{
+		{Name: "mode", Kind: PARAM_ENUM, Choices: []string{RESTORE_MERGE, RESTORE_REPLACE}, Optional: true},
+	}}
+
+	EXPORT_FORMATS = []string{EXPORT_JSON, EXPORT_CSV, EXPORT_HTML, EXPORT_MARKDOWN}
+
+	AUDIT_ACTIONS = []string{
+		AUDIT_REG, AUDIT_UNREG, AUDIT_RECORD, AUDIT_DELREC, AUDIT_ALIAS, AUDIT_SET, AUDIT_PERM, AUDIT_OP_CONFIRM,
+		AUDIT_RESTORE, AUDIT_MERGE, AUDIT_SPLIT, AUDIT_NAMECHANGE, AUDIT_BACKUP, AUDIT_IMPORT, AUDIT_EDITREC,
 	}
 
-	StringBuffer = ""
+	// The columns of CSV exports: a row for each field of a user, and one for each record.
+	EXPORT_CSV_HEADER = []string{"tg_id", "field", "category", "value", "date", "chat_id", "author", "message_id", "reply_to", "link"}
+
+	// Results too long for a message, kept for the "Send in a file" button.
+	Results = NewResultStore()
 
 	// Buttons
 
@@ -297,6 +731,65 @@ var (
 		Text:   "Send in a file",
 	}
 
+	AuditPageBtn = &tele.Btn{
+		Unique: BTN_AUDIT_PAGE,
+	}
+
+	ConfirmMergeBtn = &tele.Btn{
+		Unique: BTN_CONFIRM_MERGE,
+		Text:   "Merge",
+	}
+
+	CancelBtn = &tele.Btn{
+		Unique: BTN_CANCEL,
+		Text:   "Cancel",
+	}
+
+	SplitToggleBtn = &tele.Btn{
+		Unique: BTN_SPLIT_TOGGLE,
+	}
+
+	ConfirmRestoreBtn = &tele.Btn{
+		Unique: BTN_CONFIRM_RESTORE,
+		Text:   "Restore",
+	}
+
+	ConfirmImportBtn = &tele.Btn{
+		Unique: BTN_CONFIRM_IMPORT,
+		Text:   "Import",
+	}
+
+	ProfilePageBtn = &tele.Btn{
+		Unique: BTN_PROFILE_PAGE,
+	}
+
+	MatchesPageBtn = &tele.Btn{
+		Unique: BTN_MATCHES_PAGE,
+	}
+
+	OpenProfileBtn = &tele.Btn{
+		Unique: BTN_OPEN_PROFILE,
+	}
+
+	EvidenceBtn = &tele.Btn{
+		Unique: BTN_EVIDENCE,
+	}
+
+	QuickRegBtn = &tele.Btn{
+		Unique: BTN_QUICK_REG,
+		Text:   "Register",
+	}
+
+	QuickRecordBtn = &tele.Btn{
+		Unique: BTN_QUICK_RECORD,
+		Text:   "Record",
+	}
+
+	ConfirmSplitBtn = &tele.Btn{
+		Unique: BTN_CONFIRM_SPLIT,
+		Text:   "Split",
+	}
+
 	InviteBtn = func() *tele.Btn {
 		return &tele.Btn{
 			Unique: "inviteBtn",
@@ -366,12 +859,6 @@ var (
 		},
 	}
 
-	UploadResultBtnKeyboard = &tele.ReplyMarkup{
-		InlineKeyboard: [][]tele.InlineButton{
-			{*UploadResultBtn.Inline()},
-		},
-	}
-
 	HelpMainPageKeyboard = &tele.ReplyMarkup{
 		InlineKeyboard: [][]tele.InlineButton{
 			{*AliasHelpBtn.Inline(), *RecallHelpBtn.Inline()},
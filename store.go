@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrNotFound  = errors.New("user not found")
+	ErrConflict  = errors.New("user was modified by someone else in the meantime")
+	ErrDuplicate = errors.New("user is already registered")
+)
+
+// Opens the store selected by the configuration's storage backend.
+func NewStore(config *Configuration) (Store, error) {
+	switch config.StorageBackend {
+	case STORAGE_MONGO, "":
+		d, err := NewDatabase(config.ConnectionString, DATABASE_NAME, COLLECTION_NAME, AUDIT_COLLECTION_NAME, TRASH_COLLECTION_NAME, META_COLLECTION_NAME)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return d, nil
+	case STORAGE_BOLT:
+		d, err := NewBoltDatabase(config.BoltPath, COLLECTION_NAME, AUDIT_COLLECTION_NAME, TRASH_COLLECTION_NAME, META_COLLECTION_NAME)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return d, nil
+	case STORAGE_MEMORY:
+		return NewMemoryDatabase(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend \"%s\"", config.StorageBackend)
+	}
+}
+
+// Reports whether a user matches an equality filter, the way MongoDB would: every key
+// of the filter must equal the user's field, or be contained in it, if the field is an array.
+// A primitive.Regex value matches a string field, or any string of an array, and an "$or" key
+// holds a bson.A of filters, one of which must match. An empty key matches everything, so
+// bson.D{{}} matches all users.
+func MatchFilter(user User, filter bson.D) bool {
+	raw, err := bson.Marshal(user)
+
+	if err != nil {
+		return false
+	}
+
+	doc := bson.M{}
+
+	if err = bson.Unmarshal(raw, &doc); err != nil {
+		return false
+	}
+
+	return matchDoc(doc, filter)
+}
+
+func matchDoc(doc bson.M, filter bson.D) bool {
+	for _, e := range filter {
+		if e.Key == "" {
+			continue
+		}
+
+		if e.Key == "$or" {
+			alternatives, _ := e.Value.(bson.A)
+			found := false
+
+			for _, alt := range alternatives {
+				if f, ok := alt.(bson.D); ok && matchDoc(doc, f) {
+					found = true
+					break
+				}
+			}
+
+			if !found {
+				return false
+			}
+
+			continue
+		}
+
+		value, ok := doc[e.Key]
+
+		if !ok {
+			return false
+		}
+
+		if arr, isArr := value.(primitive.A); isArr {
+			found := false
+
+			for _, v := range arr {
+				if looseMatch(v, e.Value) {
+					found = true
+					break
+				}
+			}
+
+			if !found {
+				return false
+			}
+		} else if !looseMatch(value, e.Value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Matches a value against a regex, if the filter holds one, or compares them with looseEqual.
+func looseMatch(value, filter any) bool {
+	re, isRegex := filter.(primitive.Regex)
+
+	if !isRegex {
+		return looseEqual(value, filter)
+	}
+
+	str, isStr := value.(string)
+
+	if !isStr {
+		return false
+	}
+
+	// Only the "i" option is used by the queries.
+	pattern := re.Pattern
+
+	if strings.Contains(re.Options, "i") {
+		pattern = "(?i)" + pattern
+	}
+
+	matched, err := regexp.MatchString(pattern, str)
+
+	return err == nil && matched
+}
+
+// Reports whether a user goes, or went, by a name or username, ignoring case. It's what FindByIdentity
+// checks; field is "names" or "usernames".
+func MatchIdentity(user User, field string, value string) bool {
+	values := user.Names
+
+	if field == "usernames" {
+		values = user.Usernames
+	}
+
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Moves the last_seen of a history entry forward to the given time, if it's earlier, and reports whether
+// it did. It's what TouchIdentity does.
+func touchIdentity(history []IdentityEntry, kind string, value string, at time.Time) bool {
+	for i := range history {
+		if history[i].Kind == kind && history[i].Value == value && history[i].LastSeen.Before(at) {
+			history[i].LastSeen = at
+			return true
+		}
+	}
+
+	return false
+}
+
+// Reports whether a user has a record written by author, or by anyone if author is 0, whose category
+// and notes contain every term, ignoring case. Without an author, a description that contains every
+// term is a match too. It's what FindNotes checks.
+func MatchNotes(user User, author int64, terms []string) bool {
+	if author == 0 && len(terms) > 0 && containsTerms(user.Description, terms) {
+		return true
+	}
+
+	for category, rs := range user.Records {
+		for _, r := range rs {
+			if author != 0 && r.Author != author {
+				continue
+			}
+
+			if len(terms) == 0 || containsTerms(category+"\n"+strings.Join(r.Notes, "; "), terms) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Compares two values, treating all integer types as the same type.
+func looseEqual(a, b any) bool {
+	x, ok1 := toInt64(a)
+	y, ok2 := toInt64(b)
+
+	if ok1 && ok2 {
+		return x == y
+	}
+
+	return reflect.DeepEqual(a, b)
+}
+
+func toInt64(v any) (int64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	default:
+		return 0, false
+	}
+}
+
+// Reports whether two records are identical, once encoded; that's how MongoDB compares them on $pull.
+func SameRecord(a, b Record) bool {
+	x, err1 := bson.Marshal(a)
+	y, err2 := bson.Marshal(b)
+
+	return err1 == nil && err2 == nil && bytes.Equal(x, y)
+}
+
+// Returns a deep copy of a user, made by encoding and decoding it, so that the copy
+// shares no slices or maps with the original.
+func cloneUser(user User) (User, error) {
+	clone := User{}
+
+	raw, err := bson.Marshal(user)
+
+	if err != nil {
+		return clone, err
+	}
+
+	err = bson.Unmarshal(raw, &clone)
+
+	return clone, err
+}
+
+// Reports whether an audit entry matches the arguments of Store.FindAudit.
+func MatchAudit(entry AuditEntry, target int64, action string, since time.Time) bool {
+	return entry.Target == target &&
+		(action == "" || entry.Action == action) &&
+		(since.IsZero() || !entry.Date.Before(since))
+}
+
+// Reads a user, applies modify to it and writes it back. If someone else writes the user in the
+// meantime, it starts over with the new version, up to MAX_UPDATE_ATTEMPTS times.
+// If modify returns an error, nothing is written and the error is returned as is.
+func UpdateUser(id int64, modify func(*User) error) (before User, after User, err error) {
+	for attempt := 0; attempt < MAX_UPDATE_ATTEMPTS; attempt++ {
+		if before, err = Data.FindByID(id); err != nil {
+			return
+		}
+
+		if after, err = cloneUser(before); err != nil {
+			return
+		}
+
+		if err = modify(&after); err != nil {
+			return
+		}
+
+		err = Data.ReplaceByID(id, after)
+
+		if errors.Is(err, ErrConflict) {
+			continue
+		}
+
+		if err == nil {
+			after.Version++
+		}
+
+		return
+	}
+
+	return
+}
+
+// Finds the user an ID belongs to, whether it's their own or one of their alias IDs.
+func FindByAnyID(id int64) (User, error) {
+	user, err := Data.FindByID(id)
+
+	if !errors.Is(err, ErrNotFound) {
+		return user, err
+	}
+
+	users, err := Data.Filter(bson.D{{Key: "alias_ids", Value: id}})
+
+	if err != nil {
+		return User{}, err
+	}
+
+	if len(users) == 0 {
+		return User{}, ErrNotFound
+	}
+
+	return users[0], nil
+}
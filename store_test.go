@@ -0,0 +1,316 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Runs the same checks against every backend that doesn't need a server.
+func TestStores(t *testing.T) {
+	backends := map[string]func(t *testing.T) Store{
+		"memory": func(t *testing.T) Store {
+			return NewMemoryDatabase()
+		},
+		"bolt": func(t *testing.T) Store {
+			d, err := NewBoltDatabase(filepath.Join(t.TempDir(), "test.db"), COLLECTION_NAME, AUDIT_COLLECTION_NAME, TRASH_COLLECTION_NAME, META_COLLECTION_NAME)
+
+			if err != nil {
+				t.Fatalf("error opening database: %v", err)
+			}
+
+			return d
+		},
+	}
+
+	for name, open := range backends {
+		t.Run(name, func(t *testing.T) {
+			d := open(t)
+			defer d.Disconnect()
+
+			testStore(t, d)
+		})
+	}
+}
+
+func testStore(t *testing.T, d Store) {
+	u := newTestUser(testTargetID, 0)
+	u.Names = []string{"Miles Edgeworth"}
+	u.History = []IdentityEntry{{Kind: IDENTITY_NAME, Value: "Miles Edgeworth", LastSeen: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)}}
+
+	if err := d.Add(u, newTestUser(testWriterID, 2)); err != nil {
+		t.Fatalf("error adding users: %v", err)
+	}
+
+	if _, err := d.FindByID(4000); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	if err := d.Add(newTestUser(4000, 0), newTestUser(testTargetID, 0)); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("expected ErrDuplicate, got %v", err)
+	}
+
+	if _, err := d.FindByID(4000); err != ErrNotFound {
+		t.Errorf("expected a failed batch not to add anything, got %v", err)
+	}
+
+	// A replace can't move a user onto the tg_id of another.
+	moved, _ := d.FindByID(testTargetID)
+	moved.TelegramID = testWriterID
+
+	if err := d.ReplaceByID(testTargetID, moved); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("expected ErrDuplicate, got %v", err)
+	}
+
+	if w, err := d.FindByID(testWriterID); err != nil || w.Permission != 2 {
+		t.Errorf("expected the other user to be kept, got %+v, %v", w, err)
+	}
+
+	d.Names(false, testTargetID, "Phoenix")
+	d.Usernames(false, testTargetID, "miles")
+	d.Aliases(false, testTargetID, 3001, 3002)
+	d.Aliases(true, testTargetID, 3001)
+
+	r := Record{ChatID: testGroupID, Notes: []string{"spam"}, Date: time.Now()}
+
+	d.Record(testTargetID, "bans", r, false)
+	d.Record(testTargetID, "bans", r, false)
+
+	filters := []struct {
+		name   string
+		filter bson.D
+		count  int
+	}{
+		{name: "names", filter: bson.D{{Key: "names", Value: "Phoenix"}}, count: 1},
+		{name: "usernames", filter: bson.D{{Key: "usernames", Value: "miles"}}, count: 1},
+		{name: "alias_ids", filter: bson.D{{Key: "alias_ids", Value: int64(3002)}}, count: 1},
+		{name: "pulled alias", filter: bson.D{{Key: "alias_ids", Value: int64(3001)}}, count: 0},
+		{name: "tg_id", filter: bson.D{{Key: "tg_id", Value: testWriterID}}, count: 1},
+		{name: "all", filter: bson.D{{}}, count: 2},
+		{name: "regex", filter: bson.D{{Key: "names", Value: primitive.Regex{Pattern: "^phoe", Options: "i"}}}, count: 1},
+		{name: "regex on a missing value", filter: bson.D{{Key: "names", Value: primitive.Regex{Pattern: "larry"}}}, count: 0},
+		{name: "$or", filter: bson.D{{Key: "$or", Value: bson.A{
+			bson.D{{Key: "usernames", Value: "nobody"}},
+			bson.D{{Key: "tg_id", Value: testWriterID}},
+		}}}, count: 1},
+	}
+
+	for _, f := range filters {
+		users, err := d.Filter(f.filter)
+
+		if err != nil {
+			t.Fatalf("error filtering by %s: %v", f.name, err)
+		}
+
+		if len(users) != f.count {
+			t.Errorf("filtering by %s: expected %d users, got %d", f.name, f.count, len(users))
+		}
+	}
+
+	for _, lookup := range [][2]string{{"usernames", "MILES"}, {"names", "phoenix"}} {
+		if users, err := d.FindByIdentity(lookup[0], lookup[1]); err != nil || len(users) != 1 || users[0].TelegramID != testTargetID {
+			t.Errorf("expected %s %q to find the target, got %v, %v", lookup[0], lookup[1], users, err)
+		}
+	}
+
+	seen, _ := d.FindByID(testTargetID)
+	at := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	if err := d.TouchIdentity(testTargetID, IDENTITY_NAME, "Miles Edgeworth", at); err != nil {
+		t.Fatalf("error touching identity: %v", err)
+	}
+
+	if touched, _ := d.FindByID(testTargetID); !touched.History[0].LastSeen.Equal(at) || touched.Version != seen.Version {
+		t.Errorf("expected last_seen %v at version %d, got %v at version %d", at, seen.Version, touched.History[0].LastSeen, touched.Version)
+	}
+
+	notes := []struct {
+		name   string
+		author int64
+		terms  []string
+		count  int
+	}{
+		{name: "a term", terms: []string{"spam"}, count: 1},
+		{name: "the category", terms: []string{"bans", "sp"}, count: 1},
+		{name: "a missing term", terms: []string{"spam", "flood"}, count: 0},
+		{name: "another author", author: testWriterID, count: 0},
+	}
+
+	for _, n := range notes {
+		users, err := d.FindNotes(n.author, n.terms)
+
+		if err != nil {
+			t.Fatalf("error finding notes by %s: %v", n.name, err)
+		}
+
+		if len(users) != n.count {
+			t.Errorf("finding notes by %s: expected %d users, got %d", n.name, n.count, len(users))
+		}
+	}
+
+	found, _ := d.FindByID(testTargetID)
+
+	if len(found.Records["bans"]) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(found.Records["bans"]))
+	}
+
+	// Changing a returned user must not change the stored one.
+	found.Records["bans"] = nil
+
+	if again, _ := d.FindByID(testTargetID); len(again.Records["bans"]) != 2 {
+		t.Errorf("stored user was modified through a returned copy")
+	}
+
+	again, _ := d.FindByID(testTargetID)
+
+	if err := d.Record(testTargetID, "bans", again.Records["bans"][0], true); err != nil {
+		t.Fatalf("error pulling record: %v", err)
+	}
+
+	if again, _ = d.FindByID(testTargetID); len(again.Records["bans"]) != 0 {
+		t.Errorf("expected identical records to be pulled, %d left", len(again.Records["bans"]))
+	}
+
+	again.Description = "Prosecutor"
+
+	if err := d.ReplaceByID(testTargetID, again); err != nil {
+		t.Fatalf("error replacing user: %v", err)
+	}
+
+	if again, _ = d.FindByID(testTargetID); again.Description != "Prosecutor" {
+		t.Errorf("expected description to be replaced, got %q", again.Description)
+	}
+
+	if count, err := d.RemoveByID(testTargetID); err != nil || count != 1 {
+		t.Errorf("expected one user to be removed, got %d (%v)", count, err)
+	}
+
+	if count, _ := d.RemoveByID(testTargetID); count != 0 {
+		t.Errorf("expected nothing to be removed, got %d", count)
+	}
+
+	for i, action := range []string{AUDIT_REG, AUDIT_RECORD, AUDIT_RECORD, AUDIT_SET} {
+		d.AddAudit(AuditEntry{
+			ID:     primitive.NewObjectID(),
+			Target: testTargetID,
+			Action: action,
+			Date:   time.Date(2022, 1, i+1, 0, 0, 0, 0, time.UTC),
+		})
+	}
+
+	d.AddAudit(AuditEntry{ID: primitive.NewObjectID(), Target: testWriterID, Action: AUDIT_REG, Date: time.Now()})
+
+	entries, count, err := d.FindAudit(testTargetID, "", time.Time{}, 1, 2)
+
+	if err != nil || count != 4 || len(entries) != 2 || entries[0].Action != AUDIT_RECORD {
+		t.Errorf("expected the second page of 4 entries, got %d of %d (%v)", len(entries), count, err)
+	}
+
+	if _, count, _ = d.FindAudit(testTargetID, AUDIT_RECORD, time.Date(2022, 1, 3, 0, 0, 0, 0, time.UTC), 0, 10); count != 1 {
+		t.Errorf("expected one record entry since the 3rd, got %d", count)
+	}
+
+	old := TrashEntry{ID: primitive.NewObjectID(), TelegramID: testTargetID, Kind: TRASH_USER, User: &u, Expires: time.Now().Add(-time.Hour)}
+	recent := TrashEntry{ID: primitive.NewObjectID(), TelegramID: testTargetID, Kind: TRASH_RECORDS, Expires: time.Now().Add(time.Hour)}
+	other := TrashEntry{ID: primitive.NewObjectID(), TelegramID: testWriterID, Kind: TRASH_RECORDS, Expires: time.Now().Add(time.Hour)}
+
+	for _, e := range []TrashEntry{old, recent, other} {
+		if err := d.AddTrash(e); err != nil {
+			t.Fatalf("error adding trash entry: %v", err)
+		}
+	}
+
+	if trash, _ := d.FindTrash(testTargetID); len(trash) != 2 || trash[0].User == nil || LastOf(trash[0].User.Names) != "Miles Edgeworth" {
+		t.Errorf("expected two trash entries for the target, got %v", trash)
+	}
+
+	if count, err := d.PurgeTrash(time.Now()); err != nil || count != 1 {
+		t.Errorf("expected one expired entry to be purged, got %d (%v)", count, err)
+	}
+
+	d.RemoveTrash(recent.ID)
+
+	if trash, _ := d.FindTrash(0); len(trash) != 1 || trash[0].TelegramID != testWriterID {
+		t.Errorf("expected only the other user's entry to be left, got %v", trash)
+	}
+
+	// Versioning
+
+	v1, _ := d.FindByID(testWriterID)
+	v1.Description = "first"
+
+	if err := d.ReplaceByID(testWriterID, v1); err != nil {
+		t.Fatalf("error replacing user: %v", err)
+	}
+
+	v1.Description = "stale"
+
+	if err := d.ReplaceByID(testWriterID, v1); err != ErrConflict {
+		t.Errorf("expected a stale replace to conflict, got %v", err)
+	}
+
+	d.Names(false, testWriterID, "Larry")
+
+	if v2, _ := d.FindByID(testWriterID); v2.Version != v1.Version+2 || v2.Description != "first" {
+		t.Errorf("expected version %d with the first description, got %d and %q", v1.Version+2, v2.Version, v2.Description)
+	}
+
+	if err := d.ReplaceByID(4000, v1); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	if v, err := d.SchemaVersion(); v != 0 || err != nil {
+		t.Errorf("expected schema version 0, got %d, %v", v, err)
+	}
+
+	d.SetSchemaVersion(3)
+
+	if v, _ := d.SchemaVersion(); v != 3 {
+		t.Errorf("expected schema version 3, got %d", v)
+	}
+}
+
+func TestUpdateUser(t *testing.T) {
+	setupTest(t, recordedTarget())
+
+	attempts := 0
+
+	before, after, err := UpdateUser(testTargetID, func(u *User) error {
+		attempts++
+
+		// Someone else writes the user while the first attempt is in progress.
+		if attempts == 1 {
+			Data.Names(false, testTargetID, "Edgey")
+		}
+
+		u.Description = "Prosecutor"
+		return nil
+	})
+
+	if err != nil || attempts != 2 {
+		t.Fatalf("expected a successful second attempt, got %d attempts (%v)", attempts, err)
+	}
+
+	if len(before.Names) != 2 || after.Description != "Prosecutor" {
+		t.Errorf("expected before to include the concurrent change, got %v", before.Names)
+	}
+
+	u := mustFind(t, testTargetID)
+
+	if u.Description != "Prosecutor" || LastOf(u.Names) != "Edgey" || u.Version != after.Version {
+		t.Errorf("expected both changes to be kept, got %v", u)
+	}
+
+	_, _, err = UpdateUser(testTargetID, func(u *User) error {
+		Data.Names(false, testTargetID, "Again")
+		return nil
+	})
+
+	if err != ErrConflict {
+		t.Errorf("expected ErrConflict after too many attempts, got %v", err)
+	}
+}
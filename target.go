@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	tele "github.com/Henry96Markle/telebot"
+)
+
+var (
+	ErrNoTarget        = errors.New("no target given")
+	ErrUnknownUsername = errors.New("unknown username")
+	ErrAmbiguousTarget = errors.New("ambiguous target")
+	ErrInvalidTarget   = errors.New("not an ID or @username")
+)
+
+// Looks a public username up on Telegram. It's a variable so that tests can do without Telegram.
+var ChatByUsername = func(c tele.Context, username string) (*tele.Chat, error) {
+	return c.Bot().ChatByUsername("@" + username)
+}
+
+// Resolves the user a command is about. The first argument is taken as the target if it's a numeric
+// ID, an @username or a text mention; otherwise the sender of the replied-to message is. The
+// arguments that follow the target are returned along with it.
+//
+// An explicit target wins over a reply, so that one can reply to a message while writing about
+// someone else. In a reply, a first argument that looks like an ID or an @username is therefore
+// taken as the target, even if it was meant as the start of a description or a note; giving the
+// target explicitly before it avoids that.
+func ResolveTarget(c tele.Context) (Target, error) {
+	var (
+		msg  = c.Message()
+		args = c.Args()
+	)
+
+	if len(args) > 0 {
+		// A text mention may span several arguments, since it's the name of the user.
+		payload := strings.TrimSpace(msg.Payload)
+
+		for _, e := range msg.Entities {
+			if e.Type != tele.EntityTMention || e.User == nil {
+				continue
+			}
+
+			if mention := msg.EntityText(e); mention != "" && strings.HasPrefix(payload, mention) {
+				return Target{ID: e.User.ID, User: e.User, Args: splitArgs(payload[len(mention):])}, nil
+			}
+		}
+
+		if id, parse_err := strconv.ParseInt(args[0], 0, 64); parse_err == nil {
+			return Target{ID: id, Args: args[1:]}, nil
+		}
+
+		if strings.HasPrefix(args[0], "@") {
+			target, err := ResolveUsername(c, TrimUsername(args[0]))
+			target.Args = args[1:]
+
+			return target, err
+		}
+	}
+
+	if msg.ReplyTo != nil && msg.ReplyTo.Sender != nil {
+		return Target{ID: msg.ReplyTo.Sender.ID, User: msg.ReplyTo.Sender, Args: args, Reply: true}, nil
+	}
+
+	return Target{}, ErrNoTarget
+}
+
+// Resolves a username to a Telegram ID. The usernames, then the names, of registered users are checked
+// first; if none matches, the username is looked up on Telegram, which only knows public ones.
+func ResolveUsername(c tele.Context, username string) (Target, error) {
+	for _, field := range []string{"usernames", "names"} {
+		users, err := Data.FindByIdentity(field, username)
+
+		if err != nil {
+			return Target{}, err
+		}
+
+		if len(users) == 1 {
+			return Target{ID: users[0].TelegramID}, nil
+		} else if len(users) > 1 {
+			return Target{}, fmt.Errorf("%w: @%s", ErrAmbiguousTarget, username)
+		}
+	}
+
+	chat, chat_err := ChatByUsername(c, username)
+
+	if chat_err != nil || chat.Type != tele.ChatPrivate {
+		return Target{}, fmt.Errorf("%w: @%s", ErrUnknownUsername, username)
+	}
+
+	return Target{
+		ID:   chat.ID,
+		User: &tele.User{ID: chat.ID, FirstName: chat.FirstName, LastName: chat.LastName, Username: chat.Username},
+	}, nil
+}
+
+// Resolves a single argument to a user: a numeric ID or an @username. It's how a command takes a user
+// besides its target.
+func ResolveUser(c tele.Context, arg string) (Target, error) {
+	if id, parse_err := strconv.ParseInt(arg, 0, 64); parse_err == nil {
+		return Target{ID: id}, nil
+	}
+
+	if strings.HasPrefix(arg, "@") {
+		return ResolveUsername(c, TrimUsername(arg))
+	}
+
+	return Target{}, fmt.Errorf("%w: %s", ErrInvalidTarget, arg)
+}
+
+// Replies with why the target of a command couldn't be resolved.
+func ReplyTargetErr(c tele.Context, err error) error {
+	switch {
+	case errors.Is(err, ErrNoTarget):
+		return c.Reply(MSG_ID_REQUIRED)
+	case errors.Is(err, ErrUnknownUsername):
+		return c.Reply("Unknown username; reply to one of their messages, or use their ID.")
+	case errors.Is(err, ErrAmbiguousTarget):
+		return c.Reply("More than one user goes by that name; use their ID.")
+	case errors.Is(err, ErrInvalidTarget):
+		return c.Reply(MSG_INVALID_ID)
+	default:
+		log.Printf("error resolving target: %v\n", err)
+		return c.Reply(MSG_COULD_NOT_PERFORM)
+	}
+}
+
+// Splits what's left of a payload into arguments, the same way telebot does.
+func splitArgs(payload string) []string {
+	if payload = strings.Trim(payload, " "); payload == "" {
+		return nil
+	}
+
+	return strings.Split(payload, " ")
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	tele "github.com/Henry96Markle/telebot"
+)
+
+// Answers Telegram username lookups from the given chats, or fails if there's none.
+func fakeChatByUsername(t *testing.T, chats ...*tele.Chat) {
+	old := ChatByUsername
+
+	ChatByUsername = func(_ tele.Context, username string) (*tele.Chat, error) {
+		for _, chat := range chats {
+			if chat.Username == username {
+				return chat, nil
+			}
+		}
+
+		return nil, errors.New("chat not found")
+	}
+
+	t.Cleanup(func() { ChatByUsername = old })
+}
+
+func TestResolveTarget(t *testing.T) {
+	phoenix := &tele.Chat{ID: 2500, Type: tele.ChatPrivate, FirstName: "Phoenix", Username: "phoenix"}
+	news := &tele.Chat{ID: -1005, Type: tele.ChatChannel, Title: "News", Username: "news"}
+
+	tests := []struct {
+		name     string
+		text     string
+		replyTo  *tele.User
+		entities tele.Entities
+		want     Target
+		err      error
+	}{
+		{name: "ID", text: "/record 2000 kicks spam", want: Target{ID: 2000, Args: []string{"kicks", "spam"}}},
+		{name: "ID before reply", text: "/record 2000 kicks", replyTo: testBot, want: Target{ID: 2000, Args: []string{"kicks"}}},
+		// An explicit target wins over a reply, even if it was meant as the start of a description.
+		{name: "number in a reply", text: "/reg 5 warnings", replyTo: testTarget, want: Target{ID: 5, Args: []string{"warnings"}}},
+		{name: "reply", text: "/record kicks spam", replyTo: testTarget, want: Target{ID: 2000, User: testTarget, Args: []string{"kicks", "spam"}, Reply: true}},
+		{name: "reply without arguments", text: "/record", replyTo: testTarget, want: Target{ID: 2000, User: testTarget, Reply: true}},
+		{name: "stored username", text: "/record @Miles kicks", want: Target{ID: 2000, Args: []string{"kicks"}}},
+		{name: "not a name", text: "/record @miles_edgeworth kicks", err: ErrUnknownUsername},
+		{name: "telegram username", text: "/record @phoenix kicks", want: Target{ID: 2500, User: &tele.User{ID: 2500, FirstName: "Phoenix", Username: "phoenix"}, Args: []string{"kicks"}}},
+		{name: "channel username", text: "/record @news kicks", err: ErrUnknownUsername},
+		{name: "unknown username", text: "/record @nobody kicks", replyTo: testTarget, err: ErrUnknownUsername},
+		{name: "mention", text: "/record Miles Edgeworth kicks", entities: tele.Entities{{Type: tele.EntityTMention, Offset: 8, Length: 15, User: testTarget}}, want: Target{ID: 2000, User: testTarget, Args: []string{"kicks"}}},
+		{name: "mention elsewhere", text: "/record kicks Miles Edgeworth", replyTo: testBot, entities: tele.Entities{{Type: tele.EntityTMention, Offset: 14, Length: 15, User: testTarget}}, want: Target{ID: 3000, User: testBot, Args: []string{"kicks", "Miles", "Edgeworth"}, Reply: true}},
+		{name: "no target", text: "/record kicks", err: ErrNoTarget},
+		{name: "nothing", text: "/record", err: ErrNoTarget},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTest(t, recordedTarget())
+			fakeChatByUsername(t, phoenix, news)
+
+			ctx := newCommand(testWriterID, testGroupID, tt.text, tt.replyTo)
+			ctx.message.Entities = tt.entities
+
+			got, err := ResolveTarget(ctx)
+
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("expected error %v, got %v", tt.err, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+
+	t.Run("ambiguous", func(t *testing.T) {
+		other := newTestUser(2001, 0)
+		other.Usernames = []string{"miles"}
+
+		setupTest(t, recordedTarget(), other)
+		fakeChatByUsername(t)
+
+		if _, err := ResolveTarget(newCommand(testWriterID, testGroupID, "/record @miles kicks", nil)); !errors.Is(err, ErrAmbiguousTarget) {
+			t.Errorf("expected the username to be ambiguous, got %v", err)
+		}
+	})
+
+	t.Run("name", func(t *testing.T) {
+		other := newTestUser(2001, 0)
+		other.Names = []string{"Godot"}
+
+		setupTest(t, recordedTarget(), other)
+		fakeChatByUsername(t)
+
+		if got, err := ResolveTarget(newCommand(testWriterID, testGroupID, "/record @godot kicks", nil)); err != nil || got.ID != 2001 {
+			t.Errorf("expected the name to resolve to 2001, got %+v, %v", got, err)
+		}
+	})
+}
+
+func TestTargetedCommands(t *testing.T) {
+	phoenix := &tele.Chat{ID: 2500, Type: tele.ChatPrivate, FirstName: "Phoenix", LastName: "Wright", Username: "phoenix"}
+
+	t.Run("record by username", func(t *testing.T) {
+		setupTest(t, recordedTarget())
+		fakeChatByUsername(t)
+
+		ctx := newCommand(testWriterID, testGroupID, "/record @miles kicks spam", nil)
+		RecordHandler(ctx)
+
+		if recs := mustFind(t, testTargetID).Records["kicks"]; ctx.last() != "Recorded." || len(recs) != 1 || recs[0].Notes[0] != "spam" {
+			t.Errorf("expected a kick to be recorded, got reply %q", ctx.last())
+		}
+	})
+
+	t.Run("record by reply keeps the category", func(t *testing.T) {
+		setupTest(t, recordedTarget())
+
+		ctx := newCommand(testWriterID, testGroupID, "/record kicks spam; flood", testTarget)
+		RecordHandler(ctx)
+
+		if recs := mustFind(t, testTargetID).Records["kicks"]; len(recs) != 1 || !reflect.DeepEqual(recs[0].Notes, []string{"spam", "flood"}) {
+			t.Errorf("expected a kick with two notes, got %+v", mustFind(t, testTargetID).Records)
+		}
+	})
+
+	t.Run("register by username", func(t *testing.T) {
+		setupTest(t)
+		fakeChatByUsername(t, phoenix)
+
+		ctx := newCommand(testWriterID, testGroupID, "/reg @phoenix Defense attorney", nil)
+		RegHandler(ctx)
+
+		user := mustFind(t, 2500)
+
+		if ctx.last() != "User registered." || user.Description != "Defense attorney" || LastOf(user.Names) != "Phoenix Wright" {
+			t.Errorf("expected Phoenix to be registered, got reply %q and %+v", ctx.last(), user)
+		}
+	})
+
+	t.Run("unknown username", func(t *testing.T) {
+		setupTest(t, recordedTarget())
+		fakeChatByUsername(t)
+
+		ctx := newCommand(testWriterID, testGroupID, "/unreg @phoenix", nil)
+		UnregHandler(ctx)
+
+		if ctx.last() != "Unknown username; reply to one of their messages, or use their ID." {
+			t.Errorf("unexpected reply %q", ctx.last())
+		}
+	})
+
+	t.Run("recall by username", func(t *testing.T) {
+		setupTest(t, recordedTarget())
+		fakeChatByUsername(t)
+
+		ctx := newCommand(testReaderID, testGroupID, "/recall @MILES", nil)
+		RecallHandler(ctx)
+
+		if !strings.Contains(ctx.last(), "<b>ID:</b> <code>2000</code>") {
+			t.Errorf("expected the profile, got %q", ctx.last())
+		}
+	})
+}
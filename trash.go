@@ -0,0 +1,270 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	tele "github.com/Henry96Markle/telebot"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Returns when an entry trashed now should expire.
+func TrashExpiry() time.Time {
+	days := Config.TrashRetention
+
+	if days <= 0 {
+		days = TRASH_DEFAULT_RETENTION
+	}
+
+	return time.Now().AddDate(0, 0, days)
+}
+
+// Moves a copy of a whole user to the trash. The caller is still responsible for removing the user.
+func TrashUser(actor int64, user User) (primitive.ObjectID, error) {
+	entry := TrashEntry{
+		ID:         primitive.NewObjectID(),
+		TelegramID: user.TelegramID,
+		Kind:       TRASH_USER,
+		User:       &user,
+		DeletedBy:  actor,
+		Date:       time.Now(),
+		Expires:    TrashExpiry(),
+	}
+
+	return entry.ID, Data.AddTrash(entry)
+}
+
+// Moves records deleted from a user to the trash, by category.
+func TrashRecords(actor int64, id int64, records map[string][]Record) (primitive.ObjectID, error) {
+	entry := TrashEntry{
+		ID:         primitive.NewObjectID(),
+		TelegramID: id,
+		Kind:       TRASH_RECORDS,
+		Records:    records,
+		DeletedBy:  actor,
+		Date:       time.Now(),
+		Expires:    TrashExpiry(),
+	}
+
+	return entry.ID, Data.AddTrash(entry)
+}
+
+// Counts the records of every category.
+func CountRecords(records map[string][]Record) int {
+	count := 0
+
+	for _, r := range records {
+		count += len(r)
+	}
+
+	return count
+}
+
+// Syntax:
+//
+//	- /trash [ID/@username/mention/reply-to-message]
+func TrashHandler(c tele.Context) error {
+	args, args_err := ParseArgs(c, CommandSpecs[CMD_TRASH])
+
+	if args_err != nil {
+		return ReplyArgsErr(c, CommandSpecs[CMD_TRASH], args_err)
+	}
+
+	// 0 lists every entry.
+	id := args.Target.ID
+
+	if _, err := Data.PurgeTrash(time.Now()); err != nil {
+		log.Printf("error purging trash: %v\n", err)
+	}
+
+	entries, err := Data.FindTrash(id)
+
+	if err != nil {
+		log.Printf("error querying trash: %v\n", err)
+		return c.Reply(MSG_COULD_NOT_PERFORM)
+	}
+
+	if len(entries) == 0 {
+		return c.Reply("The trash is empty.")
+	}
+
+	str := make([]string, 0, len(entries))
+
+	// Newest first
+	for i := len(entries) - 1; i >= 0 && len(str) < TRASH_LIST_LIMIT; i-- {
+		e := entries[i]
+
+		what := ""
+
+		if e.Kind == TRASH_USER && e.User != nil {
+			what = fmt.Sprintf(
+				"user%s with %d record%s",
+				BoolToStr(len(e.User.Names) > 0, " "+LastOf(e.User.Names), ""),
+				CountRecords(e.User.Records),
+				BoolToStr(CountRecords(e.User.Records) != 1, "s", ""),
+			)
+		} else {
+			categories := MaptoSlice(e.Records, func(k string, v []Record) (string, error) {
+				return fmt.Sprintf("%s: %d", k, len(v)), nil
+			})
+
+			what = fmt.Sprintf(
+				"%d record%s (%s)",
+				CountRecords(e.Records),
+				BoolToStr(CountRecords(e.Records) != 1, "s", ""),
+				strings.Join(categories, ", "),
+			)
+		}
+
+		str = append(str, fmt.Sprintf(
+			"[<code>%d</code>] %s, deleted on %s by <code>%d</code>; expires on %s",
+			e.TelegramID,
+			what,
+			e.Date.UTC().Format("2006-01-02"),
+			e.DeletedBy,
+			e.Expires.UTC().Format("2006-01-02"),
+		))
+	}
+
+	return c.Reply(
+		fmt.Sprintf(
+			"<b>%d</b> item%s in the trash:\n\n\t- %s%s",
+			len(entries),
+			BoolToStr(len(entries) != 1, "s", ""),
+			strings.Join(str, "\n\t- "),
+			BoolToStr(len(entries) > len(str), fmt.Sprintf("\n\n..and %d more.", len(entries)-len(str)), ""),
+		),
+		tele.ModeHTML,
+	)
+}
+
+// Syntax:
+//
+//	- /restore <ID/@username/mention/reply-to-message>
+//	- /restore [merge/replace] <reply-to-backup>
+func RestoreHandler(c tele.Context) error {
+	if c.Message().ReplyTo != nil && c.Message().ReplyTo.Document != nil {
+		return RestoreBackupHandler(c)
+	}
+
+	args, args_err := ParseArgs(c, CommandSpecs[CMD_RESTORE])
+
+	if args_err != nil {
+		return ReplyArgsErr(c, CommandSpecs[CMD_RESTORE], args_err)
+	}
+
+	var (
+		id   = args.Target.ID
+		user User
+
+		base = -1
+	)
+
+	if _, err := Data.PurgeTrash(time.Now()); err != nil {
+		log.Printf("error purging trash: %v\n", err)
+	}
+
+	entries, err := Data.FindTrash(id)
+
+	if err != nil {
+		log.Printf("error querying trash: %v\n", err)
+		return c.Reply(MSG_COULD_NOT_PERFORM)
+	}
+
+	if len(entries) == 0 {
+		return c.Reply("Nothing to restore.")
+	}
+
+	current, find_err := Data.FindByID(id)
+	registered := find_err == nil
+
+	if registered {
+		user = current
+	} else {
+		// Restore the latest copy of the user, then merge the rest into it.
+		for i := len(entries) - 1; i >= 0; i-- {
+			if entries[i].Kind == TRASH_USER && entries[i].User != nil {
+				base = i
+				break
+			}
+		}
+
+		if base < 0 {
+			return c.Reply("The ID is not registered; register it again to restore its records.")
+		}
+
+		if user, err = cloneUser(*entries[base].User); err != nil {
+			log.Printf("error copying user: %v\n", err)
+			return c.Reply(MSG_COULD_NOT_PERFORM)
+		}
+	}
+
+	before, _ := cloneUser(user)
+
+	if user.Records == nil {
+		user.Records = map[string][]Record{}
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(entries))
+	restored := 0
+
+	for i, e := range entries {
+		ids = append(ids, e.ID)
+
+		if i == base {
+			restored += CountRecords(e.User.Records)
+		} else if e.Kind == TRASH_USER && e.User != nil {
+			restored += MergeRecords(user.Records, e.User.Records)
+		} else {
+			restored += MergeRecords(user.Records, e.Records)
+		}
+	}
+
+	if registered {
+		err = Data.ReplaceByID(id, user)
+	} else {
+		err = Data.Add(user)
+	}
+
+	// Either way, someone else got to the user first.
+	if errors.Is(err, ErrConflict) || errors.Is(err, ErrDuplicate) {
+		return c.Reply(MSG_CONFLICT)
+	} else if err != nil {
+		log.Printf(ERR_FMT_UPDATE+"\n", err)
+		return c.Reply(MSG_COULD_NOT_PERFORM)
+	}
+
+	if err = Data.RemoveTrash(ids...); err != nil {
+		log.Printf("error removing trash entries: %v\n", err)
+	}
+
+	if registered {
+		AuditLog(c.Sender().ID, id, AUDIT_RESTORE, &before, &user)
+	} else {
+		AuditLog(c.Sender().ID, id, AUDIT_RESTORE, nil, &user)
+	}
+
+	// logging
+
+	name := c.Message().Sender.FirstName + " " + c.Message().Sender.LastName
+
+	ChanLogf("#restore\n[<code>%d</code>] %shas restored %sID <code>%d</code> from the trash, with %d record%s.",
+		c.Sender().ID,
+		BoolToStr(name != "", name+" ", ""),
+		BoolToStr(registered, "the records of ", ""),
+		id,
+		restored,
+		BoolToStr(restored != 1, "s", ""),
+	)
+
+	// returning
+
+	if registered {
+		return c.Reply(fmt.Sprintf("%d record%s restored.", restored, BoolToStr(restored != 1, "s", "")))
+	}
+
+	return c.Reply(fmt.Sprintf("User restored, with %d record%s.", restored, BoolToStr(restored != 1, "s", "")))
+}
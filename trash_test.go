@@ -0,0 +1,112 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTrashAndRestore(t *testing.T) {
+	t.Run("unreg and restore", func(t *testing.T) {
+		setupTest(t, recordedTarget())
+
+		UnregHandler(newCommand(testWriterID, testGroupID, "/unreg 2000", nil))
+
+		ctx := newCommand(testWriterID, testGroupID, "/trash", nil)
+		TrashHandler(ctx)
+
+		if !strings.Contains(ctx.last(), "[<code>2000</code>] user Miles Edgeworth with 3 records") {
+			t.Fatalf("expected the user in the trash, got %q", ctx.last())
+		}
+
+		ctx = newCommand(testWriterID, testGroupID, "/restore 2000", nil)
+		RestoreHandler(ctx)
+
+		if ctx.last() != "User restored, with 3 records." {
+			t.Fatalf("unexpected reply %q", ctx.last())
+		}
+
+		if u := mustFind(t, testTargetID); CountRecords(u.Records) != 3 || LastOf(u.Names) != "Miles Edgeworth" {
+			t.Errorf("expected the user to be restored as it was, got %v", u)
+		}
+
+		ctx = newCommand(testWriterID, testGroupID, "/trash 2000", nil)
+		TrashHandler(ctx)
+
+		if ctx.last() != "The trash is empty." {
+			t.Errorf("expected the trash to be emptied, got %q", ctx.last())
+		}
+	})
+
+	t.Run("delete entry button", func(t *testing.T) {
+		setupTest(t, recordedTarget())
+
+		DeleteEntryBtnHandler(newCallback(testWriterID, BTN_DELETE_ENTRY, "2000"))
+
+		ctx := newCommand(testWriterID, testGroupID, "/restore", testTarget)
+		RestoreHandler(ctx)
+
+		if ctx.last() != "User restored, with 3 records." {
+			t.Fatalf("unexpected reply %q", ctx.last())
+		}
+	})
+
+	t.Run("delrec and restore", func(t *testing.T) {
+		setupTest(t, recordedTarget())
+
+		DelrecHandler(newCommand(testWriterID, testGroupID, "/delrec 2000 bans 1", nil))
+		DelrecHandler(newCommand(testWriterID, testGroupID, "/delrec 2000 warns", nil))
+
+		ctx := newCommand(testWriterID, testGroupID, "/trash @miles", nil)
+		TrashHandler(ctx)
+
+		if !strings.HasPrefix(ctx.last(), "<b>2</b> items in the trash") {
+			t.Fatalf("expected two trash entries, got %q", ctx.last())
+		}
+
+		ctx = newCommand(testWriterID, testGroupID, "/restore @miles", nil)
+		RestoreHandler(ctx)
+
+		if ctx.last() != "2 records restored." {
+			t.Fatalf("unexpected reply %q", ctx.last())
+		}
+
+		u := mustFind(t, testTargetID)
+
+		if len(u.Records["bans"]) != 2 || u.Records["bans"][0].Notes[0] != "first" || len(u.Records["warns"]) != 1 {
+			t.Errorf("expected records to be restored in order, got %v", u.Records)
+		}
+	})
+
+	t.Run("records of an unregistered user", func(t *testing.T) {
+		setupTest(t, recordedTarget())
+
+		Data.(*MemoryDatabase).AddTrash(TrashEntry{TelegramID: 4000, Kind: TRASH_RECORDS, Expires: TrashExpiry()})
+
+		ctx := newCommand(testWriterID, testGroupID, "/restore 4000", nil)
+		RestoreHandler(ctx)
+
+		if !strings.HasPrefix(ctx.last(), "The ID is not registered") {
+			t.Errorf("unexpected reply %q", ctx.last())
+		}
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		setupTest(t, recordedTarget())
+
+		UnregHandler(newCommand(testWriterID, testGroupID, "/unreg 2000", nil))
+
+		entries, _ := Data.FindTrash(testTargetID)
+		Data.RemoveTrash(entries[0].ID)
+
+		entries[0].Expires = time.Now().Add(-time.Minute)
+		Data.AddTrash(entries[0])
+
+		ctx := newCommand(testWriterID, testGroupID, "/restore 2000", nil)
+		RestoreHandler(ctx)
+
+		if ctx.last() != "Nothing to restore." {
+			t.Errorf("expected expired entries to be purged, got %q", ctx.last())
+		}
+	})
+}
@@ -1,8 +1,13 @@
 package main
 
 import (
+	"container/list"
+	"sync"
 	"time"
 
+	tele "github.com/Henry96Markle/telebot"
+	"go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 )
@@ -14,12 +19,59 @@ type (
 		ConnectionString string `json:"connection_string"`
 		LogChannelID     int64  `json:"log_channel_id"`
 		LoggingToChannel bool   `json:"logging_to_channel"`
+		StorageBackend   string `json:"storage_backend"`
+		BoltPath         string `json:"bolt_path"`
+		TrashRetention   int    `json:"trash_retention"`
+		TrackIdentities  bool   `json:"track_identities"`
+		CaptureEvidence  bool   `json:"capture_evidence"`
+		EvidenceChatID   int64  `json:"evidence_chat_id"`
 	}
 
 	Record struct {
 		ChatID int64     `bson:"chat_id" json:"chat_id"`
 		Notes  []string  `bson:"notes" json:"notes"`
 		Date   time.Time `bson:"date" json:"date"`
+		// Who wrote the record, and the message they wrote it with, in ChatID.
+		Author    int64 `bson:"author,omitempty" json:"author,omitempty"`
+		MessageID int   `bson:"message_id,omitempty" json:"message_id,omitempty"`
+		// The message the record was written in reply to, and a link to it, if the chat has links.
+		ReplyTo int    `bson:"reply_to,omitempty" json:"reply_to,omitempty"`
+		Link    string `bson:"link,omitempty" json:"link,omitempty"`
+		// A snapshot of the message replied to, if evidence was captured.
+		Evidence *Evidence `bson:"evidence,omitempty" json:"evidence,omitempty"`
+		// Every edit made with /editrec, oldest first.
+		Edits []RecordEdit `bson:"edits,omitempty" json:"edits,omitempty"`
+	}
+
+	// Evidence is a snapshot of a message, kept with a record in case the message is deleted.
+	Evidence struct {
+		SenderID  int64            `bson:"sender_id" json:"sender_id"`
+		Date      time.Time        `bson:"date" json:"date"`
+		Text      string           `bson:"text,omitempty" json:"text,omitempty"`
+		Caption   string           `bson:"caption,omitempty" json:"caption,omitempty"`
+		Entities  []EvidenceEntity `bson:"entities,omitempty" json:"entities,omitempty"`
+		MediaType string           `bson:"media_type,omitempty" json:"media_type,omitempty"`
+		// Where a forwarded copy of the message's media is kept, in the evidence chat.
+		ChatID    int64 `bson:"chat_id,omitempty" json:"chat_id,omitempty"`
+		MessageID int   `bson:"message_id,omitempty" json:"message_id,omitempty"`
+	}
+
+	// EvidenceEntity is the formatting of a part of an evidence's text or caption.
+	EvidenceEntity struct {
+		Type   string `bson:"type" json:"type"`
+		Offset int    `bson:"offset" json:"offset"`
+		Length int    `bson:"length" json:"length"`
+		URL    string `bson:"url,omitempty" json:"url,omitempty"`
+		UserID int64  `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	}
+
+	// RecordEdit is what a record was like before an edit.
+	RecordEdit struct {
+		Date     time.Time `bson:"date" json:"date"`
+		By       int64     `bson:"by" json:"by"`
+		Action   string    `bson:"action" json:"action"`
+		Category string    `bson:"category" json:"category"`
+		Notes    []string  `bson:"notes" json:"notes"`
 	}
 
 	User struct {
@@ -31,6 +83,227 @@ type (
 		Permission  int                   `bson:"permission_level" json:"permission_level"`
 		Description string                `bson:"description" json:"description"`
 		Records     map[string]([]Record) `bson:"records" json:"records"`
+		// Incremented on every write; a replace only goes through if the version hasn't changed since the user was read.
+		Version int64 `bson:"version" json:"version"`
+		// Every name, username and alias ID the user has been known by. Names, Usernames and AliasIDs
+		// hold the same values, the current one last, so that they can be queried directly.
+		History []IdentityEntry `bson:"history" json:"history"`
+	}
+
+	// IdentityEntry is a name, username or alias ID that a user has been known by, and when.
+	IdentityEntry struct {
+		Kind      string    `bson:"kind" json:"kind"`
+		Value     string    `bson:"value" json:"value"`
+		FirstSeen time.Time `bson:"first_seen" json:"first_seen"`
+		LastSeen  time.Time `bson:"last_seen" json:"last_seen"`
+		// How the entry was learned: at registration, through /alias, observed in a group, and so on.
+		Source  string `bson:"source" json:"source"`
+		AddedBy int64  `bson:"added_by" json:"added_by"`
+	}
+
+	// AuditChange is a single field that a mutation has changed.
+	AuditChange struct {
+		Field  string `bson:"field" json:"field"`
+		Before string `bson:"before" json:"before"`
+		After  string `bson:"after" json:"after"`
+	}
+
+	// AuditEntry describes who changed whom, how, and when.
+	AuditEntry struct {
+		ID      primitive.ObjectID `bson:"_id" json:"_id"`
+		Actor   int64              `bson:"actor" json:"actor"`
+		Target  int64              `bson:"target" json:"target"`
+		Action  string             `bson:"action" json:"action"`
+		Changes []AuditChange      `bson:"changes" json:"changes"`
+		Date    time.Time          `bson:"date" json:"date"`
+	}
+
+	// TrashEntry holds either a whole unregistered user, or records deleted from a user,
+	// until it's restored or it expires.
+	TrashEntry struct {
+		ID         primitive.ObjectID  `bson:"_id" json:"_id"`
+		TelegramID int64               `bson:"tg_id" json:"tg_id"`
+		Kind       string              `bson:"kind" json:"kind"`
+		User       *User               `bson:"user,omitempty" json:"user,omitempty"`
+		Records    map[string][]Record `bson:"records,omitempty" json:"records,omitempty"`
+		DeletedBy  int64               `bson:"deleted_by" json:"deleted_by"`
+		Date       time.Time           `bson:"date" json:"date"`
+		Expires    time.Time           `bson:"expires" json:"expires"`
+	}
+
+	// Store is the set of operations the bot performs on the user records,
+	// regardless of where they're kept.
+	Store interface {
+		Disconnect() error
+		Filter(filter bson.D) ([]User, error)
+		GetAll() ([]User, error)
+		FindByID(id int64) (User, error)
+		// Returns the users that may have notes matching a search: see MatchNotes. Callers still check
+		// each record, as the stores are free to return more users than that.
+		FindNotes(author int64, terms []string) ([]User, error)
+		// Returns the users that go, or went, by a name or username, ignoring case; field is "names" or "usernames".
+		FindByIdentity(field string, value string) ([]User, error)
+		// Adds new users; fails with ErrDuplicate, if one of their tg_id is already taken.
+		Add(users ...User) error
+		RemoveByID(id int64) (int64, error)
+		// Fails with ErrConflict if the stored user's version differs from user.Version.
+		ReplaceByID(id int64, user User) error
+		Aliases(pull bool, id int64, ids ...int64) error
+		Names(pull bool, id int64, names ...string) error
+		Usernames(pull bool, id int64, usernames ...string) error
+		Record(id int64, category string, record Record, remove bool) error
+		// Moves the last_seen of a user's history entry forward to the given time, if it's earlier. The version
+		// is left alone: being seen again isn't an edit, and mustn't conflict with the edits in progress.
+		TouchIdentity(id int64, kind string, value string, at time.Time) error
+
+		// Audit log
+
+		AddAudit(entry AuditEntry) error
+		// Returns a page of the target's audit entries, newest first, along with the total number of matches.
+		// An empty action matches all actions and a zero since matches any date.
+		FindAudit(target int64, action string, since time.Time, skip int64, limit int64) ([]AuditEntry, int64, error)
+
+		// Trash
+
+		AddTrash(entry TrashEntry) error
+		// Returns the trash entries of a user, oldest first; an ID of 0 returns every entry.
+		FindTrash(id int64) ([]TrashEntry, error)
+		RemoveTrash(ids ...primitive.ObjectID) error
+		// Removes the entries that expire before the given time.
+		PurgeTrash(before time.Time) (int64, error)
+		// The version of the latest schema migration applied to the stored users; 0 if none was.
+		SchemaVersion() (int, error)
+		SetSchemaVersion(version int) error
+		// Creates the indexes the queries rely on, if missing, including the unique one on tg_id.
+		EnsureIndexes() error
+	}
+
+	// Migration is a step in the evolution of the user documents. Steps are applied in order of
+	// their version, each at most once.
+	Migration struct {
+		Version     int
+		Description string
+		// Migrates a single user, and reports whether anything changed.
+		Apply func(u *User) bool
+	}
+
+	// SearchResult is a user that matched a search, and how closely.
+	SearchResult struct {
+		User  User
+		Score float64
+	}
+
+	// NoteHit is a record, or a description, that matched a /search.
+	NoteHit struct {
+		TelegramID int64
+		Name       string
+		// Empty when the description matched.
+		Category string
+		Record   Record
+		Snippet  string
+	}
+
+	// StoredResult is a result kept for the one who asked for it, until it expires.
+	StoredResult struct {
+		Owner   int64
+		Value   any
+		Expires time.Time
+	}
+
+	// ResultStore keeps what can't fit in callback data, like results that are too long for a message,
+	// or uploads waiting for a confirmation, keyed by a random token that's carried in the callback data instead.
+	ResultStore struct {
+		mutex   *sync.Mutex
+		results map[string]StoredResult
+	}
+
+	// Backup is the content of a /backup archive: every user, as of the given schema version.
+	Backup struct {
+		Format  string    `json:"format"`
+		Version int       `json:"version"`
+		Schema  int       `json:"schema"`
+		Created time.Time `json:"created"`
+		Users   []User    `json:"users"`
+	}
+
+	// RestorePlan is what restoring a backup would change.
+	RestorePlan struct {
+		Add       []User
+		Replace   []User
+		Remove    []User
+		Unchanged int
+		// The records a merge would add back to users that already exist.
+		Records int
+	}
+
+	// PendingRestore is a backup waiting for the owner to confirm its restoration.
+	PendingRestore struct {
+		Backup Backup
+		Mode   string
+	}
+
+	// ImportPlan is what importing a file would change.
+	ImportPlan struct {
+		Add       []User
+		Update    []User
+		Unchanged int
+		// The records the update would add to registered users.
+		Records int
+		// Why each left-out user was left out.
+		Conflicts []string
+	}
+
+	// PendingImport holds the users read from a file, until the import is confirmed.
+	PendingImport struct {
+		Users []User
+	}
+
+	// MigrationResult is how many users a migration has changed, or would change, in a dry run.
+	MigrationResult struct {
+		Version     int
+		Description string
+		Users       int
+	}
+
+	// Target is the user a command is about, and the arguments that follow it.
+	Target struct {
+		ID int64
+		// The Telegram user, when it's known from a reply, a mention or a lookup.
+		User *tele.User
+		Args []string
+		// Whether the target is the sender of the replied-to message.
+		Reply bool
+	}
+
+	// Param is a parameter of a command; see ParseArgs.
+	Param struct {
+		Name string
+		// One of the PARAM_* kinds.
+		Kind int
+		// The values a PARAM_ENUM accepts.
+		Choices []string
+		// Whether the arguments may end before this parameter.
+		Optional bool
+	}
+
+	// CommandSpec declares the parameters of a command, in order.
+	CommandSpec struct {
+		Name        string
+		Description string
+		Params      []Param
+	}
+
+	// Args are the arguments of a command, parsed after its CommandSpec.
+	Args struct {
+		Target Target
+		values map[string][]string
+	}
+
+	// ArgError is why an argument didn't fit the parameter it was given for.
+	ArgError struct {
+		Param Param
+		Value string
+		Err   error
 	}
 
 	// User structure is a wrapper for the MongoDB document.
@@ -38,5 +311,44 @@ type (
 		client     *mongo.Client
 		database   *mongo.Database
 		collection string
+		audit      string
+		trash      string
+		meta       string
+	}
+
+	// BoltDatabase keeps the user records in an embedded, on-disk BoltDB file.
+	BoltDatabase struct {
+		db     *bbolt.DB
+		bucket []byte
+		audit  []byte
+		trash  []byte
+		meta   []byte
+	}
+
+	// IdentityCache remembers the last identity observed for each sender, for a while. It holds a
+	// bounded number of senders, dropping the least recently observed first.
+	IdentityCache struct {
+		mutex *sync.Mutex
+		size  int
+		ttl   time.Duration
+		// Of *observedIdentity, the most recently observed first.
+		order   *list.List
+		entries map[int64]*list.Element
+	}
+
+	observedIdentity struct {
+		id  int64
+		key string
+		at  time.Time
+	}
+
+	// MemoryDatabase keeps the user records in memory; nothing survives a restart.
+	MemoryDatabase struct {
+		mutex *sync.RWMutex
+		users map[int64]User
+		audit []AuditEntry
+		trash []TrashEntry
+		// The applied schema version.
+		schema int
 	}
 )
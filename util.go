@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -77,13 +78,61 @@ func BoolToStr(b bool, str1 string, str2 string) string {
 	}
 }
 
+// Cuts a string down to limit runes, marking the cut with an ellipsis.
+func TruncateStr(s string, limit int) string {
+	r := []rune(s)
+
+	if len(r) <= limit {
+		return s
+	}
+
+	return string(r[:limit]) + "…"
+}
+
+// Returns a link to a message, or "" if the chat has no links: only supergroups and channels do.
+func MessageLink(chat *tele.Chat, id int) string {
+	switch {
+	case chat == nil || id == 0:
+		return ""
+	case chat.Username != "":
+		return fmt.Sprintf("https://t.me/%s/%d", chat.Username, id)
+	case chat.ID < -1000000000000:
+		return fmt.Sprintf("https://t.me/c/%d/%d", -chat.ID-1000000000000, id)
+	default:
+		return ""
+	}
+}
+
 // Parses a User.Record into a formatted string.
 func RecordToStr(r Record, offset string) string {
+	provenance := ""
+
+	if r.Author != 0 {
+		provenance += fmt.Sprintf("\n%sBy: <code>%d</code>", offset, r.Author)
+	}
+
+	if r.Link != "" {
+		provenance += fmt.Sprintf("\n%sIn reply to: <a href=\"%s\">this message</a>", offset, r.Link)
+	}
+
+	if r.Evidence != nil {
+		provenance += fmt.Sprintf("\n%sEvidence: kept%s", offset, BoolToStr(r.Evidence.MediaType != "", ", with "+r.Evidence.MediaType, ""))
+	}
+
+	edited := ""
+
+	if len(r.Edits) > 0 {
+		last := r.Edits[len(r.Edits)-1]
+		edited = fmt.Sprintf("\n%sEdited: %v, by <code>%d</code> (%d edit%s)", offset, last.Date, last.By, len(r.Edits), BoolToStr(len(r.Edits) != 1, "s", ""))
+	}
+
 	return fmt.Sprintf(
-		"Date: %v\n%sChat ID: <code>%d</code>%s",
+		"Date: %v\n%sChat ID: <code>%d</code>%s%s%s",
 		r.Date,
 		offset,
 		r.ChatID,
+		provenance,
+		edited,
 		BoolToStr(len(r.Notes) > 0, "\n"+offset+"Notes:\n"+offset+"- "+strings.Join(r.Notes, "\n"+offset+"- "), ""))
 }
 
@@ -113,6 +162,25 @@ func IntToStrSlice(a ...int64) []string {
 func DisplayUser(user *User) string {
 	records := make([]string, 0, len(user.Records))
 
+	for _, k := range SortedCategories(user.Records) {
+		records = append(records, DisplayCategory(k, user.Records[k]))
+	}
+
+	return DisplayIdentity(user) + BoolToStr(
+		len(records) > 0,
+		fmt.Sprintf(
+			"\n\nRecords:\n\n\t%s",
+			strings.Join(records, "\n\n\t"),
+		), "")
+}
+
+// Parses a category of records to a formatted string.
+func DisplayCategory(category string, records []Record) string {
+	return fmt.Sprintf("<b>%s</b>:\n\t%s", category, strings.Join(RecordStrArr("\t", records...), "\n\n\t"))
+}
+
+// Parses everything about a user but their records to a formatted string.
+func DisplayIdentity(user *User) string {
 	var (
 		name     = ""
 		username = ""
@@ -138,43 +206,81 @@ func DisplayUser(user *User) string {
 		username = user.Usernames[len(user.Usernames)-1]
 	}
 
-	for k, v := range user.Records {
-		records = append(records, fmt.Sprintf("<b>%s</b>:\n\t%s", k, strings.Join(RecordStrArr("\t", v...), "\n\n\t")))
-	}
-
 	perm := PermissionNames[user.Permission]
 
+	identities := BoolToStr(
+		len(user.Names) > 1, // The the last element in user.Names slice won't be displayed here.
+		"\n\nAlso known by the "+BoolToStr(len(user.Names) > 2, "names", "name")+":\n\t- "+names,
+		"",
+	) + BoolToStr(
+		len(user.Usernames) > 1,
+		"\n\nHeld the follwing username"+BoolToStr(len(user.Usernames) > 2, "s", "")+":\n\t- <code>"+usernames+"</code>", "",
+	) + BoolToStr(
+		len(user.AliasIDs) > 0,
+		"\n\nAlias IDs: \n\t- <code>"+
+			strings.Join(IntToStrSlice(user.AliasIDs...),
+				"</code>\n\t- <code>")+"</code>", "",
+	)
+
+	// Users with an identity history get a timeline instead.
+	if len(user.History) > 0 {
+		identities = "\n\nIdentity timeline:\n\t- " + IdentityTimeline(*user)
+	}
+
 	return fmt.Sprintf(
-		"<b>Name:</b> %s\n<b>Username:</b> <code>%s</code>\n<b>ID:</b> <code>%d</code>\n<b>Permission Level:</b> %s%s%s%s%s%s",
+		"<b>Name:</b> %s\n<b>Username:</b> <code>%s</code>\n<b>ID:</b> <code>%d</code>\n<b>Permission Level:</b> %s%s%s",
 		BoolToStr(len(user.Names) > 0, name, ""),
 		BoolToStr(len(user.Usernames) > 0, username, ""),
 		user.TelegramID,
 		perm,
 		BoolToStr(len(user.Description) > 0, "\n\n"+user.Description, ""),
-		BoolToStr(
-			len(user.Names) > 1, // The the last element in user.Names slice won't be displayed here.
-			"\n\nAlso known by the "+BoolToStr(len(user.Names) > 2, "names", "name")+":\n\t- "+names,
-			"",
-		),
-		BoolToStr(
-			len(user.Usernames) > 1,
-			"\n\nHeld the follwing username"+BoolToStr(len(user.Usernames) > 2, "s", "")+":\n\t- <code>"+usernames+"</code>", "",
-		),
-		BoolToStr(
-			len(user.AliasIDs) > 0,
-			"\n\nAlias IDs: \n\t- <code>"+
-				strings.Join(IntToStrSlice(user.AliasIDs...),
-					"</code>\n\t- <code>")+"</code>", "",
-		),
-		BoolToStr(
-			len(user.Records) > 0,
-			fmt.Sprintf(
-				"\n\nRecords:\n\n\t%s",
-				strings.Join(records, "\n\n\t"),
-			), ""),
+		identities,
 	)
 }
 
+// Reports whether a button's data fits in Telegram's callback data, along with the unique
+// name that telebot puts in front of it.
+func FitsCallbackData(unique string, data string) bool {
+	return len("\f"+unique+"|"+data) <= CALLBACK_DATA_LIMIT
+}
+
+// Adds the records of src to dst, skipping the ones dst already has, and keeps every category
+// sorted by date. Returns the number of records added.
+func MergeRecords(dst map[string][]Record, src map[string][]Record) int {
+	count := 0
+
+	for category, records := range src {
+	outter:
+		for _, r := range records {
+			for _, existing := range dst[category] {
+				if SameRecord(existing, r) {
+					continue outter
+				}
+			}
+
+			dst[category] = append(dst[category], r)
+			count++
+		}
+
+		sort.SliceStable(dst[category], func(i, j int) bool {
+			return dst[category][i].Date.Before(dst[category][j].Date)
+		})
+	}
+
+	return count
+}
+
+// Returns the last element of a slice, or the zero value if it's empty.
+func LastOf[K any](arr []K) K {
+	var last K
+
+	if len(arr) > 0 {
+		last = arr[len(arr)-1]
+	}
+
+	return last
+}
+
 // Filter duplicate items from arr1, if they exist in arr2, and returns the a new array with the
 // filtered elements.
 func Undupe[K comparable](arr1, arr2 []K) []K {